@@ -2,17 +2,25 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
-	"regexp"
+	"path/filepath"
 	goruntime "runtime"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/liggitt/audit2rbac/pkg"
 	"github.com/spf13/cobra"
@@ -20,13 +28,17 @@ import (
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/apiserver/pkg/apis/audit"
 	"k8s.io/apiserver/pkg/authentication/serviceaccount"
 	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/apiserver/pkg/authorization/authorizer"
 	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+	sigsyaml "sigs.k8s.io/yaml"
 )
 
 func main() {
@@ -51,12 +63,23 @@ func NewAudit2RBACCommand(stdout, stderr io.Writer) *cobra.Command {
 		ExpandMultipleNamespacesToClusterScoped: true,
 		ExpandMultipleNamesToUnnamed:            true,
 
+		RecognizePatterns:             pkg.DefaultGenerateOptions().RecognizePatterns,
+		EscalationMode:                pkg.DefaultGenerateOptions().EscalationMode,
+		NonResourceURLWildcards:       pkg.DefaultGenerateOptions().NonResourceURLWildcards,
+		NonResourceURLPrefixThreshold: pkg.DefaultGenerateOptions().NonResourceURLPrefixThreshold,
+
 		Stdout: stdout,
 		Stderr: stderr,
 	}
 
 	serviceAccount := ""
 
+	deprecatedGroups := []string{}
+	for groupResource, preferredGroup := range pkg.DefaultGenerateOptions().DeprecatedGroups {
+		deprecatedGroups = append(deprecatedGroups, groupResource.Group+"/"+groupResource.Resource+"="+preferredGroup)
+	}
+	sort.Strings(deprecatedGroups)
+
 	showVersion := false
 
 	cmd := &cobra.Command{
@@ -69,7 +92,7 @@ func NewAudit2RBACCommand(stdout, stderr io.Writer) *cobra.Command {
 				return
 			}
 
-			checkErr(stderr, options.Complete(serviceAccount, args, name, annotations, labels))
+			checkErr(stderr, options.Complete(serviceAccount, args, name, annotations, labels, deprecatedGroups))
 
 			if err := options.Validate(); err != nil {
 				fmt.Fprintln(stderr, err)
@@ -82,22 +105,61 @@ func NewAudit2RBACCommand(stdout, stderr io.Writer) *cobra.Command {
 		},
 	}
 
-	cmd.Flags().StringArrayVarP(&options.AuditSources, "filename", "f", options.AuditSources, "File, URL, or - for STDIN to read audit events from")
+	cmd.PersistentFlags().StringArrayVarP(&options.AuditSources, "filename", "f", options.AuditSources, "File, URL, or - for STDIN to read audit events from")
+
+	cmd.PersistentFlags().StringVar(&options.User, "user", options.User, "User to filter audit events to and generate role bindings for")
+	cmd.PersistentFlags().StringVar(&serviceAccount, "serviceaccount", serviceAccount, "Service account to filter audit events to and generate role bindings for, in format <namespace>:<name>")
+
+	cmd.PersistentFlags().StringVarP(&options.Namespace, "namespace", "n", options.Namespace, "Namespace to filter audit events to")
+
+	cmd.PersistentFlags().StringArrayVar(&options.FilterExpressions, "filter", options.FilterExpressions, "CEL expression events must match, like 'userAgent.startsWith(\"kube-controller-manager\") && responseStatus.code < 400 && objectRef.apiGroup == \"apps\"'. Can reference user, groups, userAgent, sourceIPs, verb, objectRef, responseStatus, and annotations. Can be specified more than once, events must match every expression")
+
+	cmd.PersistentFlags().BoolVar(&options.ExpandMultipleNamespacesToClusterScoped, "expand-multi-namespace", options.ExpandMultipleNamespacesToClusterScoped, "Allow identical operations performed in more than one namespace to be performed in any namespace")
+	cmd.PersistentFlags().BoolVar(&options.ExpandMultipleNamesToUnnamed, "expand-multi-name", options.ExpandMultipleNamesToUnnamed, "Allow identical operations performed on more than one resource name (e.g. 'get pods pod1' and 'get pods pod2') to be allowed on any name")
+
+	cmd.PersistentFlags().StringSliceVar(&deprecatedGroups, "deprecated-groups", deprecatedGroups, "Resources in deprecated API groups, in the format <group>/<resource>=<preferred group>. Requests in either group are treated as the same operation when expanding names and namespaces")
+	cmd.PersistentFlags().BoolVar(&options.ShareIdenticalNamespacedRoles, "share-namespaced-roles", options.ShareIdenticalNamespacedRoles, "Replace identical roles generated in more than one namespace with a single cluster role, bound in each namespace with role bindings")
 
-	cmd.Flags().StringVar(&options.User, "user", options.User, "User to filter audit events to and generate role bindings for")
-	cmd.Flags().StringVar(&serviceAccount, "serviceaccount", serviceAccount, "Service account to filter audit events to and generate role bindings for, in format <namespace>:<name>")
+	cmd.PersistentFlags().BoolVar(&options.RecognizePatterns, "recognize-patterns", options.RecognizePatterns, "Generate the canonical rules for well-known access patterns (leader election locks, event recording) instead of expanding them. The canonical rules can allow requests that were not made, like patching events that were only created")
 
-	cmd.Flags().StringVarP(&options.Namespace, "namespace", "n", options.Namespace, "Namespace to filter audit events to")
+	cmd.PersistentFlags().StringVar(&options.EscalationMode, "escalation", options.EscalationMode, "How to handle permissions required to write the roles and bindings found in request objects: "+strings.Join(pkg.EscalationModes, ", ")+". 'report' prints missing permissions, 'add' adds the granted permissions, 'bind-escalate' adds escalate or bind permission on the written or referenced roles")
 
-	cmd.Flags().BoolVar(&options.ExpandMultipleNamespacesToClusterScoped, "expand-multi-namespace", options.ExpandMultipleNamespacesToClusterScoped, "Allow identical operations performed in more than one namespace to be performed in any namespace")
-	cmd.Flags().BoolVar(&options.ExpandMultipleNamesToUnnamed, "expand-multi-name", options.ExpandMultipleNamesToUnnamed, "Allow identical operations performed on more than one resource name (e.g. 'get pods pod1' and 'get pods pod2') to be allowed on any name")
+	cmd.PersistentFlags().BoolVar(&options.KeepExactNonResourceURLs, "keep-exact-urls", options.KeepExactNonResourceURLs, "Generate rules for the exact paths of non-resource requests, instead of using --url-wildcards and --url-prefix-threshold")
+	cmd.PersistentFlags().StringSliceVar(&options.NonResourceURLWildcards, "url-wildcards", options.NonResourceURLWildcards, "Known-safe non-resource paths ending in '*' to allow in place of any matching path that is requested")
+	cmd.PersistentFlags().IntVar(&options.NonResourceURLPrefixThreshold, "url-prefix-threshold", options.NonResourceURLPrefixThreshold, "Allow <prefix>/* if more than this many distinct non-resource paths under the same prefix are requested with the same verb. 0 disables collapsing paths")
 
-	cmd.Flags().StringVar(&name, "generate-name", name, "Name to use for generated objects")
-	cmd.Flags().StringSliceVar(&annotations, "generate-annotations", annotations, "Annotations to add to generated objects")
-	cmd.Flags().StringSliceVar(&labels, "generate-labels", labels, "Labels to add to generated objects")
+	cmd.PersistentFlags().StringArrayVar(&options.PreviousSources, "previous", options.PreviousSources, "File or URL containing objects generated by an earlier run, identified by the generated labels. The generated objects start from them and only add permissions")
+
+	cmd.PersistentFlags().StringArrayVar(&options.NeverGrantSources, "never-grant", options.NeverGrantSources, "Policy rule in YAML or JSON, or a file or URL containing a list of them or a role, matching requests that must never be granted. Rules can set 'clusterWide: true' to only match requests not made in a namespace. Matching requests are reported instead of granted, and expanded rules that would allow them are narrowed or removed")
+	cmd.PersistentFlags().StringArrayVar(&options.IgnoreSources, "ignore", options.IgnoreSources, "Policy rule in YAML or JSON, like '{verbs: [create], apiGroups: [\"\"], resources: [events]}', or a file or URL containing a list of them, matching requests to ignore. The number of events ignored by each rule is reported")
+	cmd.PersistentFlags().StringArrayVar(&options.CeilingSources, "ceiling", options.CeilingSources, "File, directory, or URL containing roles and cluster roles that generated rules must not exceed. Cluster role rules are allowed in any namespace, role rules in the namespace of the role")
+
+	cmd.PersistentFlags().StringArrayVar(&options.DiscoverySources, "discovery", options.DiscoverySources, "File, URL, or - for STDIN to read saved API discovery documents (/api/<version> and /apis/<group>/<version> responses) or 'kubectl api-resources -o wide' output from. Requests for discovered cluster-scoped resources that include a namespace are granted cluster-wide, and requests for undiscovered resources are reported")
+	cmd.PersistentFlags().BoolVar(&options.ExpandCompleteGroupsToWildcard, "expand-wildcard-resources", options.ExpandCompleteGroupsToWildcard, "Replace the resources of a rule with '*' when it already includes every discovered resource and subresource in its API groups. Requires --discovery with discovery documents, since 'kubectl api-resources' output does not list subresources")
+
+	cmd.Flags().BoolVar(&options.ProvenanceAnnotations, "provenance-annotations", options.ProvenanceAnnotations, "Annotate generated objects with a digest of the audit logs, and the time range and number of the events supporting them")
+	cmd.Flags().BoolVar(&options.SplitByUserAgent, "split-by-user-agent", options.SplitByUserAgent, "Generate separate roles and bindings for each user agent, ignoring versions and platform details, and print a matrix of the rules each user agent needs. Generated names have the user agent appended")
+	cmd.Flags().StringVar(&options.ProvenanceFile, "provenance-file", options.ProvenanceFile, "File to write the events supporting each generated object and rule to, including first and last seen times, user agents, and a sample of audit IDs")
+
+	cmd.PersistentFlags().StringVar(&name, "generate-name", name, "Name to use for generated objects. Can reference "+templateVariableNames)
+	cmd.PersistentFlags().StringSliceVar(&annotations, "generate-annotations", annotations, "Annotations to add to generated objects. Can reference "+templateVariableNames)
+	cmd.PersistentFlags().StringSliceVar(&labels, "generate-labels", labels, "Labels to add to generated objects. Can reference "+templateVariableNames)
+
+	cmd.Flags().StringVar(&options.OutputFile, "output", options.OutputFile, "File to write the generated objects to instead of STDOUT. Findings about the generated objects in --sarif-report and --junit-report point at the lines of this file")
+	cmd.Flags().StringVar(&options.FailOn, "fail-on", options.FailOn, "Exit with an error instead of writing the generated objects if they include risky permissions of this severity or higher: "+strings.Join(pkg.Severities, ", "))
+	addReportFlags(cmd, &options.Reports)
 
 	cmd.Flags().BoolVar(&showVersion, "version", false, "Display version")
 
+	complete := func() error {
+		return options.Complete(serviceAccount, nil, name, annotations, labels, deprecatedGroups)
+	}
+	cmd.AddCommand(newExplainCommand(options, complete))
+	cmd.AddCommand(newUnusedCommand(options, complete))
+	cmd.AddCommand(newPruneCommand(options, complete))
+	cmd.AddCommand(newVerifyCommand(options, complete))
+	cmd.AddCommand(newPersonasCommand(options, complete))
+
 	return cmd
 }
 
@@ -112,6 +174,8 @@ type Audit2RBACOptions struct {
 
 	// User to filter audit events to and generate roles for
 	User string
+	// users to filter audit events to and generate roles for separately, set instead of User by the personas command
+	users []string
 
 	// Namespace limits the audit events considered to the specified namespace
 	Namespace string
@@ -125,16 +189,80 @@ type Audit2RBACOptions struct {
 	// Annotations to apply to generated object names.
 	Annotations map[string]string
 
+	// Templates for Name, Labels, and Annotations, which can reference
+	// ${user}, ${namespace}, ${sa}, ${group}, ${version}, ${date}, and ${hash}
+	nameTemplate        string
+	labelTemplates      []string
+	annotationTemplates []string
+	// legacyLabels are the labels earlier releases generated from labelTemplates, used to select their objects with --previous
+	legacyLabels map[string]string
+
 	// If the same operation is performed in multiple namespaces, expand the permission to allow it in any namespace
 	ExpandMultipleNamespacesToClusterScoped bool
 	// If the same operation is performed on resources with different names, expand the permission to allow it on any name
 	ExpandMultipleNamesToUnnamed bool
+	// Resources in deprecated API groups, mapped to the API group that replaces them
+	DeprecatedGroups map[schema.GroupResource]string
+	// If identical roles are generated in multiple namespaces, generate a single cluster role bound in each namespace instead
+	ShareIdenticalNamespacedRoles bool
+
+	// If set, generate the canonical rules for well-known access patterns
+	RecognizePatterns bool
+
+	// How to handle permissions required to write roles and bindings. One of pkg.EscalationModes.
+	EscalationMode string
+
+	// If set, generate rules for exact non-resource paths
+	KeepExactNonResourceURLs bool
+	// Known-safe non-resource paths ending in "*" to allow in place of any matching path
+	NonResourceURLWildcards []string
+	// Number of distinct non-resource paths under the same prefix to allow before allowing <prefix>/* instead
+	NonResourceURLPrefixThreshold int
+
+	// PreviousSources is a list of files or URLs containing objects generated by an earlier run.
+	// Objects with the generated labels (or the generated name, if no labels are generated) are used as the starting generated set.
+	PreviousSources []string
+
+	// FilterExpressions is a list of CEL expressions audit events must match, in addition to the user and namespace
+	FilterExpressions []string
+
+	// NeverGrantSources is a list of inline rules, files, or URLs containing rules for requests that must never be granted.
+	// Format must be a YAML or JSON list of pkg.RequestRule objects, or an object with a list of rules, like a Role.
+	NeverGrantSources []string
+	// IgnoreSources is a list of inline rules, files, or URLs containing rules for requests to ignore, in the same format as NeverGrantSources
+	IgnoreSources []string
+
+	// CeilingSources is a list of files, directories, or URLs containing roles and cluster roles.
+	// Generated rules are limited to the permissions they allow.
+	CeilingSources []string
+
+	// DiscoverySources is a list of files, URLs or - for STDIN.
+	// Format must be JSON or YAML APIResourceList objects, or the output of `kubectl api-resources -o wide`.
+	DiscoverySources []string
+	// If a rule includes every discovered resource in its API groups, replace the resources with "*"
+	ExpandCompleteGroupsToWildcard bool
+
+	// If set, annotate generated objects with the source digest, and the time range and number of supporting events
+	ProvenanceAnnotations bool
+	// File to write the events supporting each generated object and rule to
+	ProvenanceFile string
+
+	// If set, generate separate objects for the events of each normalized user agent
+	SplitByUserAgent bool
+
+	// File to write the generated objects to. Defaults to STDOUT.
+	OutputFile string
+
+	// If set, fail instead of writing generated objects with risks of this severity or higher. One of pkg.Severities.
+	FailOn string
+	// Reports to write the risks of the generated objects to
+	Reports ReportOptions
 
 	Stdout io.Writer
 	Stderr io.Writer
 }
 
-func (a *Audit2RBACOptions) Complete(serviceAccount string, args []string, name string, annotations, labels []string) error {
+func (a *Audit2RBACOptions) Complete(serviceAccount string, args []string, name string, annotations, labels, deprecatedGroups []string) error {
 	if len(serviceAccount) > 0 && len(a.User) > 0 {
 		return fmt.Errorf("cannot set both user and service account")
 	}
@@ -146,48 +274,23 @@ func (a *Audit2RBACOptions) Complete(serviceAccount string, args []string, name
 		a.User = serviceaccount.MakeUsername(parts[0], parts[1])
 	}
 
-	a.Annotations = nil
-	for _, s := range annotations {
-		s = strings.TrimSpace(s)
-		if len(s) == 0 {
-			continue
-		}
-		if a.Annotations == nil {
-			a.Annotations = map[string]string{}
-		}
-		s = strings.Replace(s, "${user}", a.User, -1)
-		s = strings.Replace(s, "${version}", pkg.Version, -1)
-		parts := strings.SplitN(s, "=", 2)
-		if len(parts) == 1 {
-			a.Annotations[parts[0]] = ""
-		} else {
-			a.Annotations[parts[0]] = parts[1]
-		}
-	}
+	// templates are rendered once the groups of the user are known from the audit events
+	a.nameTemplate = name
+	a.labelTemplates = labels
+	a.annotationTemplates = annotations
 
-	a.Labels = nil
-	for _, s := range labels {
+	a.DeprecatedGroups = map[schema.GroupResource]string{}
+	for _, s := range deprecatedGroups {
 		s = strings.TrimSpace(s)
 		if len(s) == 0 {
 			continue
 		}
-		if a.Labels == nil {
-			a.Labels = map[string]string{}
-		}
-		s = strings.Replace(s, "${user}", sanitizeLabel(a.User), -1)
-		s = strings.Replace(s, "${version}", sanitizeLabel(pkg.Version), -1)
 		parts := strings.SplitN(s, "=", 2)
-		if len(parts) == 1 {
-			a.Labels[parts[0]] = ""
-		} else {
-			a.Labels[parts[0]] = parts[1]
+		groupResource := strings.SplitN(parts[0], "/", 2)
+		if len(parts) != 2 || len(groupResource) != 2 || groupResource[1] == "" || parts[1] == "" {
+			return fmt.Errorf("deprecated groups must be in the format <group>/<resource>=<preferred group>: %s", s)
 		}
-	}
-
-	if len(name) > 0 {
-		name = strings.Replace(name, "${user}", sanitizeName(a.User), -1)
-		name = strings.Replace(name, "${version}", sanitizeName(pkg.Version), -1)
-		a.Name = name
+		a.DeprecatedGroups[schema.GroupResource{Group: groupResource[0], Resource: groupResource[1]}] = parts[1]
 	}
 
 	if a.Stderr == nil {
@@ -201,7 +304,7 @@ func (a *Audit2RBACOptions) Complete(serviceAccount string, args []string, name
 }
 
 func (a *Audit2RBACOptions) Validate() error {
-	if len(a.User) == 0 {
+	if len(a.User) == 0 && len(a.users) == 0 {
 		return fmt.Errorf("--user is required")
 	}
 	if len(a.AuditSources) == 0 {
@@ -210,11 +313,186 @@ func (a *Audit2RBACOptions) Validate() error {
 	if len(a.GeneratedPath) == 0 {
 		return fmt.Errorf("--output is required")
 	}
+	for _, wildcard := range a.NonResourceURLWildcards {
+		if !strings.HasPrefix(wildcard, "/") || !strings.HasSuffix(wildcard, "*") {
+			return fmt.Errorf("--url-wildcards must be paths ending in '*': %s", wildcard)
+		}
+	}
+	if !sets.NewString(pkg.EscalationModes...).Has(a.EscalationMode) {
+		return fmt.Errorf("--escalation must be one of %s", strings.Join(pkg.EscalationModes, ", "))
+	}
+	if a.NonResourceURLPrefixThreshold < 0 {
+		return fmt.Errorf("--url-prefix-threshold must not be negative")
+	}
+	if a.ExpandCompleteGroupsToWildcard && len(a.DiscoverySources) == 0 {
+		return fmt.Errorf("--expand-wildcard-resources requires --discovery")
+	}
+	if a.SplitByUserAgent && len(a.PreviousSources) > 0 {
+		return fmt.Errorf("--split-by-user-agent cannot be combined with --previous")
+	}
+	if len(a.FailOn) > 0 {
+		if _, err := pkg.ParseSeverity(a.FailOn); err != nil {
+			return fmt.Errorf("--fail-on must be one of %s", strings.Join(pkg.Severities, ", "))
+		}
+	}
 	return nil
 }
 
 func (a *Audit2RBACOptions) Run() error {
-	hasErrors := false
+	events, err := a.loadEvents()
+	if err != nil {
+		return err
+	}
+	sources, err := a.loadGenerateSources(events)
+	if err != nil {
+		return err
+	}
+
+	generated := &pkg.RBACObjects{}
+	generatedByUserAgent := map[string]*pkg.RBACObjects{}
+	provenance := []pkg.ObjectProvenance{}
+	for _, partition := range events.partitions() {
+		generator, partitionGenerated, err := a.generate(partition, sources, false)
+		if err != nil {
+			return err
+		}
+
+		if len(a.PreviousSources) > 0 {
+			if diffs := pkg.DiffRules(&generator.Previous, partitionGenerated); len(diffs) == 0 {
+				fmt.Fprintln(a.Stderr, "No permissions were added to the previous objects")
+			} else {
+				fmt.Fprintln(a.Stderr, "Added permissions:")
+				writeRulesDiff(a.Stderr, diffs)
+			}
+		}
+
+		if a.ProvenanceAnnotations || len(a.ProvenanceFile) > 0 {
+			partitionProvenance := pkg.NewProvenance(partitionGenerated, partition.requests.Requests())
+			if a.ProvenanceAnnotations {
+				pkg.AnnotateProvenance(partitionGenerated, partitionProvenance, sourceDigest(events.digests))
+			}
+			provenance = append(provenance, partitionProvenance...)
+		}
+
+		if len(partition.userAgent) > 0 {
+			generatedByUserAgent[partition.userAgent] = partitionGenerated
+		}
+		generated.Roles = append(generated.Roles, partitionGenerated.Roles...)
+		generated.ClusterRoles = append(generated.ClusterRoles, partitionGenerated.ClusterRoles...)
+		generated.RoleBindings = append(generated.RoleBindings, partitionGenerated.RoleBindings...)
+		generated.ClusterRoleBindings = append(generated.ClusterRoleBindings, partitionGenerated.ClusterRoleBindings...)
+	}
+
+	if len(a.ProvenanceFile) > 0 {
+		data, err := sigsyaml.Marshal(provenance)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(a.ProvenanceFile, data, 0644); err != nil {
+			return err
+		}
+	}
+
+	if len(generatedByUserAgent) > 0 {
+		writeUserAgentMatrix(a.Stderr, pkg.NewUserAgentMatrix(generatedByUserAgent))
+	}
+
+	if errs := validateGenerated(generated); len(errs) > 0 {
+		return fmt.Errorf("generated objects are invalid:\n  %s", strings.Join(errs, "\n  "))
+	}
+
+	out := &bytes.Buffer{}
+	generatedFiles := writeObjects(out, generated, a.OutputFile)
+
+	risks := pkg.AnalyzeRisks(generated)
+	writeRisks(a.Stderr, risks)
+	findings := append(riskFindings(risks, generatedFiles), policyFindings(events.neverGrant, events.neverGrantFiles, events.deniedByPolicy.Requests(), a.User)...)
+	if err := a.Reports.Write("audit2rbac", findings); err != nil {
+		return err
+	}
+	if len(a.FailOn) > 0 {
+		failOn, _ := pkg.ParseSeverity(a.FailOn)
+		if failed := countRisks(risks, failOn); failed > 0 {
+			return fmt.Errorf("generated roles have %d risky permissions of %s severity or higher", failed, failOn)
+		}
+	}
+
+	fmt.Fprintln(a.Stderr, "Generating roles...")
+
+	if len(a.OutputFile) > 0 {
+		if err := ioutil.WriteFile(a.OutputFile, out.Bytes(), 0644); err != nil {
+			return err
+		}
+	} else {
+		a.Stdout.Write(out.Bytes())
+	}
+
+	fmt.Fprintln(a.Stderr, "Complete!")
+
+	if events.hasErrors {
+		return fmt.Errorf("Errors occurred reading audit events")
+	}
+	return nil
+}
+
+// auditEvents holds the requests and grants read from the audit sources
+type auditEvents struct {
+	// requests is not populated if events are split by user agent, the requests are held by byUserAgent instead
+	requests *pkg.RequestAggregator
+	// groups are the groups of the user in the first event read
+	groups []string
+	// deniedByPolicy holds the requests matching a neverGrant rule, which are not included in requests
+	deniedByPolicy *pkg.RequestAggregator
+	neverGrant     []pkg.RequestRule
+	// neverGrantFiles holds the file each neverGrant rule was read from, or "" for rules specified inline
+	neverGrantFiles []string
+	// ignored holds the number of events ignored by each ignore rule
+	ignored     []int
+	ignoreRules []pkg.RequestRule
+	grants      []pkg.Grant
+	// unknownGrants is the number of events that wrote roles or bindings without logging the object
+	unknownGrants int
+	// filters are the compiled FilterExpressions
+	filters   []*eventFilter
+	digests   []*digestReader
+	hasErrors bool
+
+	// userAgent is the normalized user agent that made the events, if they were split by user agent
+	userAgent string
+	// byUserAgent holds the requests and grants of each normalized user agent, if events are split by user agent
+	byUserAgent map[string]*auditEvents
+	// user is the user that made the events, if they were split by user
+	user string
+	// byUser holds the requests and grants of each user, if events are split by user
+	byUser map[string]*auditEvents
+}
+
+// loadEvents reads the audit events for the user and namespace from the audit sources.
+// Errors reading individual sources and events are printed, and recorded in hasErrors.
+func (a *Audit2RBACOptions) loadEvents() (*auditEvents, error) {
+	events := &auditEvents{requests: pkg.NewRequestAggregator(), deniedByPolicy: pkg.NewRequestAggregator(), grants: []pkg.Grant{}}
+
+	filters, err := compileEventFilters(a.FilterExpressions)
+	if err != nil {
+		return nil, err
+	}
+	events.filters = filters
+
+	if len(a.NeverGrantSources) > 0 {
+		rules, files, err := loadRequestRules(a.NeverGrantSources, "never-grant")
+		if err != nil {
+			return nil, err
+		}
+		events.neverGrant, events.neverGrantFiles = rules, files
+	}
+	if len(a.IgnoreSources) > 0 {
+		rules, _, err := loadRequestRules(a.IgnoreSources, "ignore")
+		if err != nil {
+			return nil, err
+		}
+		events.ignoreRules = rules
+		events.ignored = make([]int, len(rules))
+	}
 
 	if len(a.AuditSources) == 1 {
 		fmt.Fprintln(a.Stderr, "Opening audit source...")
@@ -224,106 +502,294 @@ func (a *Audit2RBACOptions) Run() error {
 
 	streams, streamErrors := openStreams(a.AuditSources)
 	for _, err := range streamErrors {
-		hasErrors = true
+		events.hasErrors = true
 		fmt.Fprintln(os.Stderr, err)
 	}
+	events.digests = make([]*digestReader, len(streams))
+	for i := range streams {
+		events.digests[i] = newDigestReader(streams[i])
+		streams[i] = events.digests[i]
+	}
 
 	fmt.Fprint(a.Stderr, "Loading events...")
 	results := stream(streams)
 	results = flatten(results)
 	results = typecast(results, pkg.Scheme)
 	results = convertinternal(results, pkg.Scheme)
-	results = filterEvents(results,
+	users := sets.NewString(a.User)
+	if len(a.users) > 0 {
+		users = sets.NewString(a.users...)
+	}
+	predicates := []func(*audit.Event) bool{
 		func(event *audit.Event) bool {
 			eventUser := &event.User
 			if event.ImpersonatedUser != nil {
 				eventUser = event.ImpersonatedUser
 			}
-			return eventUser.Username == a.User
+			return users.Has(eventUser.Username)
 		},
 		func(event *audit.Event) bool {
 			return a.Namespace == "" || (event.ObjectRef != nil && a.Namespace == event.ObjectRef.Namespace)
 		},
-	)
+	}
+	for _, filter := range events.filters {
+		predicates = append(predicates, filter.matches)
+	}
+	results = filterEvents(results, predicates...)
 
 	// TODO: allow generating intermediate results before completing stream (every X events, or every X seconds, etc)
 	// This allows piping the audit log through audit2rbac
 
-	attributes := []authorizer.AttributesRecord{}
+	// aggregate identical requests as they are read, so memory use does not grow with the size of the audit log
+	seenGrants := map[string]bool{}
 	for result := range results {
 		if result.err != nil {
-			hasErrors = true
+			events.hasErrors = true
 			fmt.Fprintln(os.Stderr, result.err)
 			continue
 		}
 
-		attrs := eventToAttributes(result.obj.(*audit.Event))
-		attributes = append(attributes, attrs)
-		if len(attributes)%100 == 0 {
+		event := result.obj.(*audit.Event)
+		attrs := eventToAttributes(event)
+		if pkg.MatchRequestRules(events.neverGrant, attrs) >= 0 {
+			events.deniedByPolicy.Add(attrs, event.RequestReceivedTimestamp.Time).AddSource(event.UserAgent, string(event.AuditID))
+			continue
+		}
+		if i := pkg.MatchRequestRules(events.ignoreRules, attrs); i >= 0 {
+			events.ignored[i]++
+			continue
+		}
+		if events.groups == nil {
+			events.groups = attrs.User.GetGroups()
+		}
+		// split events are only added to their user agent or user, so memory use does not double
+		target := events
+		if a.SplitByUserAgent {
+			target = events.forUserAgent(normalizeUserAgent(event.UserAgent))
+		} else if len(a.users) > 0 {
+			target = events.forUser(attrs.User.GetName())
+			if target.groups == nil {
+				target.groups = attrs.User.GetGroups()
+			}
+		}
+		target.requests.Add(attrs, event.RequestReceivedTimestamp.Time).AddSource(event.UserAgent, string(event.AuditID))
+		if grant, isRBACWrite, err := eventToGrant(event, attrs); err != nil {
+			events.hasErrors = true
+			fmt.Fprintln(os.Stderr, err)
+		} else if grant != nil {
+			// events for the same role or binding repeat across stages and updates
+			key := target.userAgent + "/" + target.user + "/" + fmt.Sprintf("%#v", *grant)
+			if !seenGrants[key] {
+				seenGrants[key] = true
+				target.grants = append(target.grants, *grant)
+			}
+		} else if isRBACWrite {
+			target.unknownGrants++
+		}
+		if total := events.total(); total%100 == 0 {
 			fmt.Fprintf(a.Stderr, ".")
 		}
 	}
 	fmt.Fprintln(a.Stderr)
 
-	if len(attributes) == 0 {
-		message := fmt.Sprintf("No audit events matched user %s", a.User)
+	writeFilterErrors(a.Stderr, events.filters)
+	writeDeniedByPolicy(a.Stderr, events.neverGrant, events.deniedByPolicy.Requests())
+	writeIgnored(a.Stderr, events.ignoreRules, events.ignored)
+
+	total := events.total()
+	userNames := strings.Join(users.List(), ", ")
+	if total == 0 && events.deniedByPolicy.Total() > 0 {
+		return nil, fmt.Errorf("All %d audit events matching user %s were denied by policy or ignored", events.deniedByPolicy.Total()+sum(events.ignored), userNames)
+	}
+	if total == 0 && sum(events.ignored) > 0 {
+		return nil, fmt.Errorf("All %d audit events matching user %s were ignored", sum(events.ignored), userNames)
+	}
+	if total == 0 {
+		message := fmt.Sprintf("No audit events matched user %s", userNames)
 		if len(a.Namespace) > 0 {
 			message += fmt.Sprintf(" in namespace %s", a.Namespace)
 		}
-		return errors.New(message)
+		if len(a.FilterExpressions) > 0 {
+			message += " and --filter"
+		}
+		return nil, errors.New(message)
 	}
+	return events, nil
+}
 
-	fmt.Fprintln(a.Stderr, "Evaluating API calls...")
+// total returns the number of requests in the events, including the requests of each partition if they were split
+func (e *auditEvents) total() int {
+	total := e.requests.Total()
+	for _, events := range e.byUserAgent {
+		total += events.requests.Total()
+	}
+	for _, events := range e.byUser {
+		total += events.requests.Total()
+	}
+	return total
+}
 
-	opts := pkg.DefaultGenerateOptions()
-	opts.Labels = a.Labels
-	opts.Annotations = a.Annotations
-	opts.Name = a.Name
-	opts.ExpandMultipleNamespacesToClusterScoped = a.ExpandMultipleNamespacesToClusterScoped
-	opts.ExpandMultipleNamesToUnnamed = a.ExpandMultipleNamesToUnnamed
+// generateSources holds the inputs shared by the generators of each partition of the events
+type generateSources struct {
+	discovery *pkg.Discovery
+	ceiling   *pkg.Ceiling
+	previous  pkg.RBACObjects
+}
 
-	generated := pkg.NewGenerator(getDiscoveryRoles(), attributes, opts).Generate()
+// loadGenerateSources renders the name, label, and annotation templates, and loads the discovery documents, ceiling roles, and previous objects.
+// They are loaded once for all partitions of the events, since STDIN and URLs cannot be read again.
+func (a *Audit2RBACOptions) loadGenerateSources(events *auditEvents) (*generateSources, error) {
+	sources := &generateSources{}
+	if len(a.DiscoverySources) > 0 {
+		fmt.Fprintln(a.Stderr, "Loading discovery data...")
+		discovery, err := loadDiscovery(a.DiscoverySources)
+		if err != nil {
+			return nil, err
+		}
+		if a.ExpandCompleteGroupsToWildcard && !discovery.SubresourcesKnown() {
+			return nil, fmt.Errorf("--expand-wildcard-resources requires discovery documents listing subresources, 'kubectl api-resources' output does not include them")
+		}
+		sources.discovery = discovery
+	}
 
-	fmt.Fprintln(a.Stderr, "Generating roles...")
+	a.renderMetadata(events.groups)
 
-	firstSeparator := true
-	printSeparator := func() {
-		if firstSeparator {
-			firstSeparator = false
-			return
+	if len(a.PreviousSources) > 0 {
+		fmt.Fprintln(a.Stderr, "Loading previous objects...")
+		objects, err := loadRBACObjects(a.PreviousSources)
+		if err != nil {
+			return nil, err
 		}
-		fmt.Fprintln(os.Stdout, "---")
+		sources.previous = selectPrevious(objects, a.Labels, a.legacyLabels, a.Name)
 	}
-	for _, obj := range generated.Roles {
-		printSeparator()
-		pkg.Output(os.Stdout, obj, "yaml")
+
+	if len(a.CeilingSources) > 0 {
+		fmt.Fprintln(a.Stderr, "Loading ceiling roles...")
+		objects, err := loadRBACObjects(a.CeilingSources)
+		if err != nil {
+			return nil, err
+		}
+		if len(objects.Roles) == 0 && len(objects.ClusterRoles) == 0 {
+			return nil, fmt.Errorf("no roles or cluster roles found in %s", strings.Join(a.CeilingSources, ", "))
+		}
+		sources.ceiling = pkg.NewCeiling(&objects)
 	}
-	for _, obj := range generated.ClusterRoles {
-		printSeparator()
-		pkg.Output(os.Stdout, obj, "yaml")
+	return sources, nil
+}
+
+// renderMetadata renders the name, label, and annotation templates for the user, who is a member of the groups
+func (a *Audit2RBACOptions) renderMetadata(groups []string) {
+	vars := newTemplateVariables(a.User, a.Namespace, groups, time.Now())
+	name, labels, annotations := vars.renderMetadata(a.nameTemplate, a.labelTemplates, a.annotationTemplates)
+	if len(name) > 0 {
+		a.Name = name
 	}
-	for _, obj := range generated.RoleBindings {
-		printSeparator()
-		pkg.Output(os.Stdout, obj, "yaml")
+	if labels != nil {
+		a.Labels = labels
 	}
-	for _, obj := range generated.ClusterRoleBindings {
-		printSeparator()
-		pkg.Output(os.Stdout, obj, "yaml")
+	if annotations != nil {
+		a.Annotations = annotations
 	}
+	a.legacyLabels = vars.renderLegacyLabels(a.labelTemplates)
+}
 
-	fmt.Fprintln(a.Stderr, "Complete!")
+// generate generates roles and bindings for the events, printing warnings.
+// If trace is set, the returned generator records how each request contributed to the generated rules.
+func (a *Audit2RBACOptions) generate(events *auditEvents, sources *generateSources, trace bool) (*pkg.Generator, *pkg.RBACObjects, error) {
+	if len(events.userAgent) > 0 {
+		fmt.Fprintf(a.Stderr, "Evaluating %d distinct API calls from %d events by user agent %s...\n", events.requests.Len(), events.requests.Total(), events.userAgent)
+	} else if len(events.user) > 0 {
+		fmt.Fprintf(a.Stderr, "Evaluating %d distinct API calls from %d events by user %s...\n", events.requests.Len(), events.requests.Total(), events.user)
+	} else {
+		fmt.Fprintf(a.Stderr, "Evaluating %d distinct API calls from %d events...\n", events.requests.Len(), events.requests.Total())
+	}
 
-	if hasErrors {
-		return fmt.Errorf("Errors occurred reading audit events")
+	opts := pkg.DefaultGenerateOptions()
+	opts.Labels = a.Labels
+	opts.Annotations = a.Annotations
+	opts.Name = a.Name
+	if len(events.userAgent) > 0 {
+		opts.Name, opts.Labels = userAgentMetadata(a.Name, a.Labels, events.userAgent)
+	}
+	opts.ExpandMultipleNamespacesToClusterScoped = a.ExpandMultipleNamespacesToClusterScoped
+	opts.ExpandMultipleNamesToUnnamed = a.ExpandMultipleNamesToUnnamed
+	opts.DeprecatedGroups = a.DeprecatedGroups
+	opts.ShareIdenticalNamespacedRoles = a.ShareIdenticalNamespacedRoles
+	opts.RecognizePatterns = a.RecognizePatterns
+	opts.EscalationMode = a.EscalationMode
+	opts.KeepExactNonResourceURLs = a.KeepExactNonResourceURLs
+	opts.NonResourceURLWildcards = a.NonResourceURLWildcards
+	opts.NonResourceURLPrefixThreshold = a.NonResourceURLPrefixThreshold
+	opts.Discovery = sources.discovery
+	opts.ExpandCompleteGroupsToWildcard = a.ExpandCompleteGroupsToWildcard
+	opts.Trace = trace
+	opts.NeverGrant = events.neverGrant
+
+	if events.unknownGrants > 0 {
+		fmt.Fprintf(a.Stderr, "Warning: %d events wrote roles or bindings without logging the request object, log at the Request level to check the permissions they require\n", events.unknownGrants)
 	}
-	return nil
-}
 
-func sanitizeName(s string) string {
-	return strings.ToLower(string(regexp.MustCompile(`[^a-zA-Z0-9:]`).ReplaceAll([]byte(s), []byte("-"))))
+	generator := pkg.NewGenerator(getDiscoveryRoles(), events.requests.Attributes(), opts)
+	generator.Grants = events.grants
+	generator.Previous = sources.previous
+	generator.Ceiling = sources.ceiling
+	generated := generator.Generate()
+	for _, warning := range generator.Warnings() {
+		fmt.Fprintln(a.Stderr, "Warning: "+warning)
+	}
+	if exceeded := generator.Exceeded(); len(exceeded) > 0 {
+		fmt.Fprintf(a.Stderr, "Warning: %d distinct API calls are not allowed by the ceiling, and were not granted:\n", len(exceeded))
+		for _, request := range exceeded {
+			fmt.Fprintf(a.Stderr, "  %s%s\n", describeRequest(request), describeEvidence(events.requests.Get(request)))
+		}
+	}
+	return generator, generated, nil
 }
-func sanitizeLabel(s string) string {
-	return strings.ToLower(string(regexp.MustCompile(`[^a-zA-Z0-9]`).ReplaceAll([]byte(s), []byte("-"))))
+
+// writeObjects writes the roles, cluster roles, role bindings, and cluster role bindings as a YAML stream,
+// and returns the lines the objects and their rules start at, located in file
+func writeObjects(w io.Writer, objects *pkg.RBACObjects, file string) rbacObjectFiles {
+	files := rbacObjectFiles{}
+	line := 1
+	write := func(key string, obj runtime.Object) {
+		if line > 1 {
+			fmt.Fprintln(w, "---")
+			line++
+		}
+		out := &bytes.Buffer{}
+		pkg.Output(out, obj, "yaml")
+
+		// rules are a top-level list, so each rule starts with an unindented "- "
+		source := objectSource{file: file, line: line}
+		inRules := false
+		for i, text := range strings.Split(out.String(), "\n") {
+			switch {
+			case text == "rules:":
+				inRules = true
+			case inRules && strings.HasPrefix(text, "- "):
+				source.ruleLines = append(source.ruleLines, line+i)
+			case !strings.HasPrefix(text, " "):
+				inRules = false
+			}
+		}
+		files[key] = source
+
+		line += strings.Count(out.String(), "\n")
+		w.Write(out.Bytes())
+	}
+	for _, obj := range objects.Roles {
+		write(files.key("Role", obj.Namespace, obj.Name), obj)
+	}
+	for _, obj := range objects.ClusterRoles {
+		write(files.key("ClusterRole", "", obj.Name), obj)
+	}
+	for _, obj := range objects.RoleBindings {
+		write(files.key("RoleBinding", obj.Namespace, obj.Name), obj)
+	}
+	for _, obj := range objects.ClusterRoleBindings {
+		write(files.key("ClusterRoleBinding", "", obj.Name), obj)
+	}
+	return files
 }
 
 func openStreams(sources []string) ([]io.ReadCloser, []error) {
@@ -365,6 +831,158 @@ func openStreams(sources []string) ([]io.ReadCloser, []error) {
 	return streams, errors
 }
 
+func loadDiscovery(sources []string) (*pkg.Discovery, error) {
+	streams, errs := openStreams(sources)
+	defer func() {
+		for _, stream := range streams {
+			stream.Close()
+		}
+	}()
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	discovery := pkg.NewDiscovery()
+	for i, stream := range streams {
+		if err := discovery.Load(stream); err != nil {
+			return nil, fmt.Errorf("error loading discovery data from %s: %v", sources[i], err)
+		}
+	}
+	return discovery, nil
+}
+
+// digestReader computes a digest of the data read from a source
+type digestReader struct {
+	io.ReadCloser
+	hash hash.Hash
+}
+
+func newDigestReader(r io.ReadCloser) *digestReader {
+	return &digestReader{ReadCloser: r, hash: sha256.New()}
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.ReadCloser.Read(p)
+	d.hash.Write(p[:n])
+	return n, err
+}
+
+// sourceDigest returns the digest of a single source, or a digest of the digests of multiple sources, in the order they were specified
+func sourceDigest(digests []*digestReader) string {
+	if len(digests) == 1 {
+		return "sha256:" + hex.EncodeToString(digests[0].hash.Sum(nil))
+	}
+	combined := sha256.New()
+	for _, d := range digests {
+		combined.Write(d.hash.Sum(nil))
+	}
+	return "sha256:" + hex.EncodeToString(combined.Sum(nil))
+}
+
+// loadRBACObjects reads roles, cluster roles, role bindings, and cluster role bindings from the sources.
+// Sources that are directories are expanded to the JSON and YAML files they contain. Other kinds of objects are ignored.
+func loadRBACObjects(sources []string) (pkg.RBACObjects, error) {
+	objects, _, err := loadRBACObjectFiles(sources)
+	return objects, err
+}
+
+// rbacObjectFiles records the source each RBAC object was read from, or the file it was written to
+type rbacObjectFiles map[string]objectSource
+
+// objectSource is the file containing an object, and the lines the object and its rules start at, if known
+type objectSource struct {
+	file      string
+	line      int
+	ruleLines []int
+}
+
+func (f rbacObjectFiles) key(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// location returns the location of the rule with the specified index in the object, or of the whole object if rule is -1
+func (f rbacObjectFiles) location(kind, namespace, name string, rule int) *pkg.ObjectLocation {
+	source := f[f.key(kind, namespace, name)]
+	line := source.line
+	if rule >= 0 && rule < len(source.ruleLines) {
+		line = source.ruleLines[rule]
+	}
+	return &pkg.ObjectLocation{File: source.file, Line: line, Kind: kind, Namespace: namespace, Name: name, Rule: rule}
+}
+
+// loadRBACObjectFiles reads RBAC objects like loadRBACObjects, and records the source each object was read from.
+// Sources are read in order.
+func loadRBACObjectFiles(sources []string) (pkg.RBACObjects, rbacObjectFiles, error) {
+	objects := pkg.RBACObjects{}
+	files := rbacObjectFiles{}
+	sources, err := expandDirectories(sources)
+	if err != nil {
+		return objects, files, err
+	}
+	streams, errs := openStreams(sources)
+	if len(errs) > 0 {
+		for _, stream := range streams {
+			stream.Close()
+		}
+		return objects, files, errs[0]
+	}
+
+	for i := range streams {
+		for result := range typecast(flatten(stream(streams[i:i+1])), pkg.Scheme) {
+			switch {
+			case result.err != nil:
+				if err == nil {
+					err = result.err
+				}
+			case result.obj != nil:
+				switch obj := result.obj.(type) {
+				case *rbacv1.Role:
+					objects.Roles = append(objects.Roles, obj)
+					files[files.key("Role", obj.Namespace, obj.Name)] = objectSource{file: sources[i]}
+				case *rbacv1.ClusterRole:
+					objects.ClusterRoles = append(objects.ClusterRoles, obj)
+					files[files.key("ClusterRole", "", obj.Name)] = objectSource{file: sources[i]}
+				case *rbacv1.RoleBinding:
+					objects.RoleBindings = append(objects.RoleBindings, obj)
+					files[files.key("RoleBinding", obj.Namespace, obj.Name)] = objectSource{file: sources[i]}
+				case *rbacv1.ClusterRoleBinding:
+					objects.ClusterRoleBindings = append(objects.ClusterRoleBindings, obj)
+					files[files.key("ClusterRoleBinding", "", obj.Name)] = objectSource{file: sources[i]}
+				}
+			}
+		}
+	}
+	return objects, files, err
+}
+
+// expandDirectories replaces sources that are local directories with the .json, .yaml, and .yml files
+// they contain, recursively, in lexical order
+func expandDirectories(sources []string) ([]string, error) {
+	expanded := []string{}
+	for _, source := range sources {
+		if info, err := os.Stat(source); err != nil || !info.IsDir() {
+			expanded = append(expanded, source)
+			continue
+		}
+		err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			switch filepath.Ext(path) {
+			case ".json", ".yaml", ".yml":
+				if !info.IsDir() {
+					expanded = append(expanded, path)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return expanded, nil
+}
+
 type streamObject struct {
 	obj runtime.Object
 	err error
@@ -561,7 +1179,8 @@ func eventToAttributes(event *audit.Event) authorizer.AttributesRecord {
 
 	attrs := authorizer.AttributesRecord{
 		Verb: event.Verb,
-		Path: event.RequestURI,
+		// The query string is not part of the path that is authorized
+		Path: strings.SplitN(event.RequestURI, "?", 2)[0],
 		User: &user.DefaultInfo{
 			Name:   eventUser.Username,
 			Groups: eventUser.Groups,
@@ -577,6 +1196,10 @@ func eventToAttributes(event *audit.Event) authorizer.AttributesRecord {
 		attrs.APIGroup = event.ObjectRef.APIGroup
 		attrs.APIVersion = event.ObjectRef.APIVersion
 	}
+	if attrs.ResourceRequest && attrs.Name == "" && (event.Verb == "list" || event.Verb == "watch") {
+		// List and watch requests for a single object are authorized with that object's name
+		attrs.Name = fieldSelectorName(event.RequestURI)
+	}
 	if event.Verb == "create" {
 		// The name attribute is not available to authorization of create requests
 		// (see https://kubernetes.io/docs/reference/access-authn-authz/rbac/#referring-to-resources),
@@ -588,6 +1211,64 @@ func eventToAttributes(event *audit.Event) authorizer.AttributesRecord {
 	return attrs
 }
 
+// eventToGrant returns the role or binding written by the event's request, if it was logged.
+// isRBACWrite is true if the event wrote a role or binding, whether or not it was logged.
+func eventToGrant(event *audit.Event, attrs authorizer.AttributesRecord) (grant *pkg.Grant, isRBACWrite bool, err error) {
+	if !attrs.ResourceRequest || attrs.APIGroup != rbacv1.GroupName || attrs.Subresource != "" {
+		return nil, false, nil
+	}
+	switch attrs.Verb {
+	case "create", "update", "patch":
+	default:
+		return nil, false, nil
+	}
+	switch attrs.Resource {
+	case "roles", "clusterroles", "rolebindings", "clusterrolebindings":
+	default:
+		return nil, false, nil
+	}
+	if event.Stage != "" && event.Stage != audit.StageResponseComplete {
+		// only completed requests wrote anything
+		return nil, false, nil
+	}
+	if event.ResponseStatus != nil && event.ResponseStatus.Code >= 300 {
+		// failed requests did not write anything
+		return nil, false, nil
+	}
+
+	// the response object is the object that was written, including the result of a patch
+	var data []byte
+	switch {
+	case event.ResponseObject != nil && len(event.ResponseObject.Raw) > 0:
+		data = event.ResponseObject.Raw
+	case event.RequestObject != nil && len(event.RequestObject.Raw) > 0 && attrs.Verb != "patch":
+		data = event.RequestObject.Raw
+	default:
+		return nil, true, nil
+	}
+
+	decoded, err := pkg.DecodeGrant(attrs.User, attrs.Resource, attrs.Namespace, data)
+	if err != nil {
+		return nil, true, fmt.Errorf("error decoding %s written by audit event %s: %v", attrs.Resource, event.AuditID, err)
+	}
+	return &decoded, true, nil
+}
+
+// fieldSelectorName returns the name required by the metadata.name field selector in the request URI, if any,
+// matching how the apiserver populates the name of list and watch requests
+func fieldSelectorName(requestURI string) string {
+	u, err := url.ParseRequestURI(requestURI)
+	if err != nil {
+		return ""
+	}
+	selector, err := fields.ParseSelector(u.Query().Get("fieldSelector"))
+	if err != nil {
+		return ""
+	}
+	name, _ := selector.RequiresExactMatch("metadata.name")
+	return name
+}
+
 func getDiscoveryRoles() pkg.RBACObjects {
 	return pkg.RBACObjects{
 		ClusterRoles: []*rbacv1.ClusterRole{
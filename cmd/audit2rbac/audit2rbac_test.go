@@ -3,9 +3,12 @@ package main
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/liggitt/audit2rbac/pkg"
 
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apiserver/pkg/apis/audit"
 	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/apiserver/pkg/authorization/authorizer"
@@ -39,6 +42,104 @@ func TestEventToAttributes(t *testing.T) {
 				ResourceRequest: true,
 			},
 		},
+		{
+			name: "single-name watch",
+			event: &audit.Event{
+				Verb:       "watch",
+				RequestURI: "/api/v1/namespaces/mynamespace/configmaps?fieldSelector=metadata.name%3Dmyname&watch=true",
+				ObjectRef: &audit.ObjectReference{
+					APIVersion: "v1",
+					Resource:   "configmaps",
+					Namespace:  "mynamespace",
+				},
+			},
+			expectedAttributes: authorizer.AttributesRecord{
+				User:            &user.DefaultInfo{},
+				Verb:            "watch",
+				Path:            "/api/v1/namespaces/mynamespace/configmaps",
+				Namespace:       "mynamespace",
+				APIVersion:      "v1",
+				Resource:        "configmaps",
+				Name:            "myname",
+				ResourceRequest: true,
+			},
+		},
+		{
+			name: "single-name list",
+			event: &audit.Event{
+				Verb:       "list",
+				RequestURI: "/api/v1/namespaces/mynamespace/configmaps?fieldSelector=metadata.name%3Dmyname%2Cmetadata.namespace%3Dmynamespace",
+				ObjectRef: &audit.ObjectReference{
+					APIVersion: "v1",
+					Resource:   "configmaps",
+					Namespace:  "mynamespace",
+				},
+			},
+			expectedAttributes: authorizer.AttributesRecord{
+				User:            &user.DefaultInfo{},
+				Verb:            "list",
+				Path:            "/api/v1/namespaces/mynamespace/configmaps",
+				Namespace:       "mynamespace",
+				APIVersion:      "v1",
+				Resource:        "configmaps",
+				Name:            "myname",
+				ResourceRequest: true,
+			},
+		},
+		{
+			name: "list with name inequality",
+			event: &audit.Event{
+				Verb:       "list",
+				RequestURI: "/api/v1/namespaces/mynamespace/configmaps?fieldSelector=metadata.name%21%3Dmyname",
+				ObjectRef: &audit.ObjectReference{
+					APIVersion: "v1",
+					Resource:   "configmaps",
+					Namespace:  "mynamespace",
+				},
+			},
+			expectedAttributes: authorizer.AttributesRecord{
+				User:            &user.DefaultInfo{},
+				Verb:            "list",
+				Path:            "/api/v1/namespaces/mynamespace/configmaps",
+				Namespace:       "mynamespace",
+				APIVersion:      "v1",
+				Resource:        "configmaps",
+				ResourceRequest: true,
+			},
+		},
+		{
+			name: "delete collection with name",
+			event: &audit.Event{
+				Verb:       "deletecollection",
+				RequestURI: "/api/v1/namespaces/mynamespace/configmaps?fieldSelector=metadata.name%3Dmyname",
+				ObjectRef: &audit.ObjectReference{
+					APIVersion: "v1",
+					Resource:   "configmaps",
+					Namespace:  "mynamespace",
+				},
+			},
+			expectedAttributes: authorizer.AttributesRecord{
+				User:            &user.DefaultInfo{},
+				Verb:            "deletecollection",
+				Path:            "/api/v1/namespaces/mynamespace/configmaps",
+				Namespace:       "mynamespace",
+				APIVersion:      "v1",
+				Resource:        "configmaps",
+				ResourceRequest: true,
+			},
+		},
+		{
+			name: "nonresource request with query",
+			event: &audit.Event{
+				Verb:       "get",
+				RequestURI: "/metrics?format=text",
+			},
+			expectedAttributes: authorizer.AttributesRecord{
+				User: &user.DefaultInfo{},
+				Verb: "get",
+				Path: "/metrics",
+			},
+		},
 		{
 			name: "accepted create",
 			event: &audit.Event{
@@ -72,3 +173,91 @@ func TestEventToAttributes(t *testing.T) {
 		})
 	}
 }
+
+func TestAggregateQueryStrings(t *testing.T) {
+	events := []*audit.Event{
+		{
+			Verb:       "watch",
+			RequestURI: "/api/v1/namespaces/mynamespace/pods?allowWatchBookmarks=true&resourceVersion=100&timeoutSeconds=312&watch=true",
+			ObjectRef:  &audit.ObjectReference{APIVersion: "v1", Resource: "pods", Namespace: "mynamespace"},
+		},
+		{
+			Verb:       "watch",
+			RequestURI: "/api/v1/namespaces/mynamespace/pods?allowWatchBookmarks=true&resourceVersion=250&timeoutSeconds=457&watch=true",
+			ObjectRef:  &audit.ObjectReference{APIVersion: "v1", Resource: "pods", Namespace: "mynamespace"},
+		},
+		{Verb: "get", RequestURI: "/metrics"},
+		{Verb: "get", RequestURI: "/metrics?format=text"},
+	}
+
+	aggregator := pkg.NewRequestAggregator()
+	for _, event := range events {
+		aggregator.Add(eventToAttributes(event), time.Time{})
+	}
+	if aggregator.Len() != 2 || aggregator.Total() != 4 {
+		t.Errorf("expected 4 events to aggregate into 2 requests, got %d events in %d requests", aggregator.Total(), aggregator.Len())
+	}
+	for _, request := range aggregator.Requests() {
+		if request.Count != 2 {
+			t.Errorf("expected 2 events for %#v, got %d", request.Attributes, request.Count)
+		}
+	}
+}
+
+func TestEventToGrant(t *testing.T) {
+	binding := []byte(`{"metadata":{"name":"view"},"roleRef":{"apiGroup":"rbac.authorization.k8s.io","kind":"ClusterRole","name":"view"}}`)
+	testcases := []struct {
+		name          string
+		event         *audit.Event
+		expectedGrant bool
+		expectedWrite bool
+	}{
+		{
+			name:          "metadata level",
+			event:         &audit.Event{Verb: "create", Stage: audit.StageResponseComplete, ObjectRef: &audit.ObjectReference{APIGroup: "rbac.authorization.k8s.io", Resource: "rolebindings", Namespace: "ns1"}},
+			expectedWrite: true,
+		},
+		{
+			name:          "request level create",
+			event:         &audit.Event{Verb: "create", Stage: audit.StageResponseComplete, ObjectRef: &audit.ObjectReference{APIGroup: "rbac.authorization.k8s.io", Resource: "rolebindings", Namespace: "ns1"}, RequestObject: &runtime.Unknown{Raw: binding}},
+			expectedGrant: true,
+			expectedWrite: true,
+		},
+		{
+			name:          "request level patch",
+			event:         &audit.Event{Verb: "patch", Stage: audit.StageResponseComplete, ObjectRef: &audit.ObjectReference{APIGroup: "rbac.authorization.k8s.io", Resource: "rolebindings", Namespace: "ns1", Name: "view"}, RequestObject: &runtime.Unknown{Raw: []byte(`{"subjects":[]}`)}},
+			expectedWrite: true,
+		},
+		{
+			name:          "request response level patch",
+			event:         &audit.Event{Verb: "patch", Stage: audit.StageResponseComplete, ObjectRef: &audit.ObjectReference{APIGroup: "rbac.authorization.k8s.io", Resource: "rolebindings", Namespace: "ns1", Name: "view"}, ResponseObject: &runtime.Unknown{Raw: binding}},
+			expectedGrant: true,
+			expectedWrite: true,
+		},
+		{
+			name:  "request received stage",
+			event: &audit.Event{Verb: "create", Stage: audit.StageRequestReceived, ObjectRef: &audit.ObjectReference{APIGroup: "rbac.authorization.k8s.io", Resource: "rolebindings", Namespace: "ns1"}, RequestObject: &runtime.Unknown{Raw: binding}},
+		},
+		{
+			name:  "other resource",
+			event: &audit.Event{Verb: "create", Stage: audit.StageResponseComplete, ObjectRef: &audit.ObjectReference{Resource: "configmaps", Namespace: "ns1"}, RequestObject: &runtime.Unknown{Raw: []byte(`{}`)}},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			grant, isRBACWrite, err := eventToGrant(tc.event, eventToAttributes(tc.event))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isRBACWrite != tc.expectedWrite {
+				t.Errorf("expected isRBACWrite=%v, got %v", tc.expectedWrite, isRBACWrite)
+			}
+			if (grant != nil) != tc.expectedGrant {
+				t.Fatalf("expected grant=%v, got %#v", tc.expectedGrant, grant)
+			}
+			if grant != nil && (grant.Kind != "RoleBinding" || grant.Namespace != "ns1" || grant.RoleRef.Name != "view") {
+				t.Errorf("unexpected grant %#v", grant)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/liggitt/audit2rbac/pkg"
+	"github.com/spf13/cobra"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func newExplainCommand(options *Audit2RBACOptions, complete func() error) *cobra.Command {
+	explain := &ExplainOptions{Audit2RBACOptions: options}
+
+	cmd := &cobra.Command{
+		Use:   "explain --filename=audit.log [ --user=bob | --serviceaccount=my-namespace:my-sa ] [ --rule='get,list pods -n ns1' | --role=role.yaml ]",
+		Short: "Show the audit events that produced or expanded generated rules",
+		Long: "Show the audit events that produced or expanded generated rules, and why the rules allow more than the requests in the events.\n\n" +
+			"Rules are specified as '<verbs> <resources> [<names>] [-n <namespace>]', with comma-separated values. " +
+			"Resources are in the format <resource>[.<group>][/<subresource>], or are non-resource paths starting with '/'.",
+		Run: func(cmd *cobra.Command, args []string) {
+			checkErr(options.Stderr, complete())
+
+			if err := explain.Validate(); err != nil {
+				fmt.Fprintln(options.Stderr, err)
+				fmt.Fprintln(options.Stderr)
+				cmd.Help()
+				os.Exit(1)
+			}
+
+			checkErr(options.Stderr, explain.Run())
+		},
+	}
+
+	cmd.Flags().StringArrayVar(&explain.Rules, "rule", explain.Rules, "Rule to explain, like 'get,list pods -n ns1'")
+	cmd.Flags().StringArrayVar(&explain.RoleSources, "role", explain.RoleSources, "File or URL containing roles or cluster roles to explain the rules of")
+
+	return cmd
+}
+
+type ExplainOptions struct {
+	*Audit2RBACOptions
+
+	// Rules to explain, in the format '<verbs> <resources> [<names>] [-n <namespace>]'
+	Rules []string
+	// RoleSources is a list of files or URLs containing roles and cluster roles to explain the rules of
+	RoleSources []string
+}
+
+func (e *ExplainOptions) Validate() error {
+	if err := e.Audit2RBACOptions.Validate(); err != nil {
+		return err
+	}
+	if len(e.Rules) == 0 && len(e.RoleSources) == 0 {
+		return fmt.Errorf("--rule or --role is required")
+	}
+	return nil
+}
+
+// explainQuery is a rule to explain, granted in a namespace or cluster-wide
+type explainQuery struct {
+	description string
+	namespace   string
+	rule        rbacv1.PolicyRule
+}
+
+func (e *ExplainOptions) Run() error {
+	queries := []explainQuery{}
+	for _, s := range e.Rules {
+		namespace, rules, err := parseRule(s)
+		if err != nil {
+			return err
+		}
+		for _, rule := range rules {
+			description := "rule " + rbacv1helper.CompactString(rule)
+			if len(namespace) > 0 {
+				description += " in namespace " + namespace
+			}
+			queries = append(queries, explainQuery{description: description, namespace: namespace, rule: rule})
+		}
+	}
+	if len(e.RoleSources) > 0 {
+		roles, err := loadRBACObjects(e.RoleSources)
+		if err != nil {
+			return err
+		}
+		if len(roles.Roles) == 0 && len(roles.ClusterRoles) == 0 {
+			return fmt.Errorf("no roles or cluster roles found in %s", strings.Join(e.RoleSources, ", "))
+		}
+		for _, role := range roles.Roles {
+			for _, rule := range role.Rules {
+				queries = append(queries, explainQuery{description: fmt.Sprintf("role %s/%s rule %s", role.Namespace, role.Name, rbacv1helper.CompactString(rule)), namespace: role.Namespace, rule: rule})
+			}
+		}
+		for _, role := range roles.ClusterRoles {
+			for _, rule := range role.Rules {
+				queries = append(queries, explainQuery{description: fmt.Sprintf("clusterrole %s rule %s", role.Name, rbacv1helper.CompactString(rule)), rule: rule})
+			}
+		}
+	}
+
+	events, err := e.loadEvents()
+	if err != nil {
+		return err
+	}
+	sources, err := e.loadGenerateSources(events)
+	if err != nil {
+		return err
+	}
+	generator, _, err := e.generate(events, sources, true)
+	if err != nil {
+		return err
+	}
+
+	for _, query := range queries {
+		writeExplanation(e.Stdout, query.description, pkg.Explain(generator.Traces(), query.namespace, query.rule), events.requests)
+	}
+
+	if events.hasErrors {
+		return fmt.Errorf("Errors occurred reading audit events")
+	}
+	return nil
+}
+
+// writeExplanation writes the requests that produced or were allowed by a rule, the events they were seen in,
+// and why the generated rules allow more than the requests
+func writeExplanation(w io.Writer, description string, traces []pkg.Trace, requests *pkg.RequestAggregator) {
+	fmt.Fprintf(w, "%s:\n", description)
+	if len(traces) == 0 {
+		fmt.Fprintln(w, "  no audit events produced or were allowed by this rule")
+	}
+	for _, trace := range traces {
+		indent := "  "
+		if request := describeRequest(trace.Request); len(request) > 0 {
+			fmt.Fprintf(w, "  %s%s\n", request, describeEvidence(requests.Get(trace.Request)))
+			indent = "    "
+		}
+		for _, reason := range trace.Reasons {
+			fmt.Fprintf(w, "%s%s\n", indent, reason)
+		}
+	}
+}
+
+// describeRequest formats a request like the rules passed to --rule, or returns an empty string if the request has no verb
+func describeRequest(request authorizer.AttributesRecord) string {
+	if len(request.Verb) == 0 {
+		return ""
+	}
+	if !request.ResourceRequest {
+		return request.Verb + " " + request.Path
+	}
+	parts := []string{request.Verb, request.Resource}
+	if len(request.APIGroup) > 0 {
+		parts[1] += "." + request.APIGroup
+	}
+	if len(request.Subresource) > 0 {
+		parts[1] += "/" + request.Subresource
+	}
+	if len(request.Name) > 0 {
+		parts = append(parts, request.Name)
+	}
+	if len(request.Namespace) > 0 {
+		parts = append(parts, "-n", request.Namespace)
+	}
+	return strings.Join(parts, " ")
+}
+
+func describeEvidence(request *pkg.AggregatedRequest) string {
+	if request == nil {
+		return ""
+	}
+	s := fmt.Sprintf(" (%d events", request.Count)
+	if request.Count == 1 {
+		s = " (1 event"
+	}
+	if !request.FirstSeen.IsZero() {
+		s += fmt.Sprintf(" from %s to %s", request.FirstSeen.UTC().Format(time.RFC3339), request.LastSeen.UTC().Format(time.RFC3339))
+	}
+	if len(request.AuditIDs) > 0 {
+		s += ", audit IDs " + strings.Join(request.AuditIDs, ", ")
+	}
+	return s + ")"
+}
+
+// parseRule parses a rule in the format '<verbs> <resources> [<names>] [-n <namespace>]'.
+// Resources are in the format <resource>[.<group>][/<subresource>], or are non-resource paths starting with '/'.
+// One rule is returned for each API group.
+func parseRule(s string) (string, []rbacv1.PolicyRule, error) {
+	namespace := ""
+	fields := []string{}
+	all := strings.Fields(s)
+	for i := 0; i < len(all); i++ {
+		switch {
+		case all[i] == "-n" || all[i] == "--namespace":
+			if i+1 == len(all) {
+				return "", nil, fmt.Errorf("invalid rule %q: %s requires a namespace", s, all[i])
+			}
+			namespace = all[i+1]
+			i++
+		case strings.HasPrefix(all[i], "--namespace="):
+			namespace = strings.TrimPrefix(all[i], "--namespace=")
+		default:
+			fields = append(fields, all[i])
+		}
+	}
+	if len(fields) < 2 || len(fields) > 3 {
+		return "", nil, fmt.Errorf("invalid rule %q: expected '<verbs> <resources> [<names>] [-n <namespace>]'", s)
+	}
+	verbs := strings.Split(fields[0], ",")
+
+	if strings.HasPrefix(fields[1], "/") {
+		if len(fields) == 3 || len(namespace) > 0 {
+			return "", nil, fmt.Errorf("invalid rule %q: non-resource paths cannot have names or a namespace", s)
+		}
+		return "", []rbacv1.PolicyRule{{Verbs: verbs, NonResourceURLs: strings.Split(fields[1], ",")}}, nil
+	}
+
+	var names []string
+	if len(fields) == 3 {
+		names = strings.Split(fields[2], ",")
+	}
+	groups := []string{}
+	resources := map[string][]string{}
+	for _, resource := range strings.Split(fields[1], ",") {
+		subresource := ""
+		if i := strings.Index(resource, "/"); i >= 0 {
+			resource, subresource = resource[:i], resource[i:]
+		}
+		group := ""
+		if i := strings.Index(resource, "."); i >= 0 {
+			resource, group = resource[:i], resource[i+1:]
+		}
+		if len(resource) == 0 {
+			return "", nil, fmt.Errorf("invalid rule %q: empty resource", s)
+		}
+		if _, ok := resources[group]; !ok {
+			groups = append(groups, group)
+		}
+		resources[group] = append(resources[group], resource+subresource)
+	}
+	rules := []rbacv1.PolicyRule{}
+	for _, group := range groups {
+		rules = append(rules, rbacv1.PolicyRule{Verbs: verbs, APIGroups: []string{group}, Resources: resources[group], ResourceNames: names})
+	}
+	return namespace, rules, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/liggitt/audit2rbac/pkg"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+func TestParseRule(t *testing.T) {
+	testcases := []struct {
+		name              string
+		rule              string
+		expectedNamespace string
+		expectedRules     []rbacv1.PolicyRule
+		expectErr         bool
+	}{
+		{
+			name:              "namespaced",
+			rule:              "get,list pods -n ns1",
+			expectedNamespace: "ns1",
+			expectedRules:     []rbacv1.PolicyRule{{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+		},
+		{
+			name: "groups, subresources, and names",
+			rule: "get deployments.apps/scale,pods/log,replicasets.apps a,b --namespace=ns1",
+			expectedRules: []rbacv1.PolicyRule{
+				{Verbs: []string{"get"}, APIGroups: []string{"apps"}, Resources: []string{"deployments/scale", "replicasets"}, ResourceNames: []string{"a", "b"}},
+				{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods/log"}, ResourceNames: []string{"a", "b"}},
+			},
+			expectedNamespace: "ns1",
+		},
+		{
+			name:          "non-resource",
+			rule:          "get /healthz,/metrics",
+			expectedRules: []rbacv1.PolicyRule{{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz", "/metrics"}}},
+		},
+		{
+			name:      "non-resource with namespace",
+			rule:      "get /healthz -n ns1",
+			expectErr: true,
+		},
+		{
+			name:      "missing resources",
+			rule:      "get -n ns1",
+			expectErr: true,
+		},
+		{
+			name:      "missing namespace",
+			rule:      "get pods -n",
+			expectErr: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			namespace, rules, err := parseRule(tc.rule)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if namespace != tc.expectedNamespace {
+				t.Errorf("expected namespace %q, got %q", tc.expectedNamespace, namespace)
+			}
+			if !reflect.DeepEqual(tc.expectedRules, rules) {
+				t.Errorf("unexpected rules:\n%s", cmp.Diff(tc.expectedRules, rules))
+			}
+		})
+	}
+}
+
+func TestWriteExplanation(t *testing.T) {
+	bob := &user.DefaultInfo{Name: "bob"}
+	t1 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	getPod := authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "metrics.k8s.io", Resource: "pods", Name: "pod1"}
+	getHealthz := authorizer.AttributesRecord{User: bob, Verb: "get", Path: "/healthz"}
+
+	requests := pkg.NewRequestAggregator()
+	requests.Add(getPod, t1).AddSource("kubectl", "a")
+	requests.Add(getPod, t1.Add(time.Hour)).AddSource("kubectl", "b")
+	requests.Add(getHealthz, time.Time{})
+
+	traces := []pkg.Trace{
+		{Request: getPod, Namespace: "ns1", Reasons: []string{"allowed on any name, since it was also requested for names pod2"}},
+		{Request: getHealthz},
+		{Request: authorizer.AttributesRecord{User: bob}, Namespace: "ns1", Reasons: []string{"granted by role ns1/app"}},
+	}
+
+	out := &bytes.Buffer{}
+	writeExplanation(out, "rule get pods", traces, requests)
+	writeExplanation(out, "rule list pods", nil, requests)
+	expected := `rule get pods:
+  get pods.metrics.k8s.io pod1 -n ns1 (2 events from 2020-01-01T00:00:00Z to 2020-01-01T01:00:00Z, audit IDs a, b)
+    allowed on any name, since it was also requested for names pod2
+  get /healthz (1 event)
+  granted by role ns1/app
+rule list pods:
+  no audit events produced or were allowed by this rule
+`
+	if out.String() != expected {
+		t.Errorf("unexpected output:\n%s", cmp.Diff(expected, out.String()))
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"google.golang.org/protobuf/proto"
+
+	"k8s.io/apiserver/pkg/apis/audit"
+)
+
+// eventFilterDeclarations declares the variables available to --filter expressions
+var eventFilterDeclarations = cel.Declarations(
+	decls.NewVar("user", decls.String),
+	decls.NewVar("groups", decls.NewListType(decls.String)),
+	decls.NewVar("userAgent", decls.String),
+	decls.NewVar("sourceIPs", decls.NewListType(decls.String)),
+	decls.NewVar("verb", decls.String),
+	// objectRef has every key, empty if the event has no object reference or the field is not set
+	decls.NewVar("objectRef", decls.NewMapType(decls.String, decls.String)),
+	// responseStatus has code, status, reason, and message keys. code is 0 if the event has no response status
+	decls.NewVar("responseStatus", decls.NewMapType(decls.String, decls.Dyn)),
+	decls.NewVar("annotations", decls.NewMapType(decls.String, decls.String)),
+)
+
+// eventFilter is a CEL expression selecting the audit events to generate roles from
+type eventFilter struct {
+	expression string
+	program    cel.Program
+	// errors is the number of events the expression could not be evaluated for, which are excluded
+	errors   int
+	firstErr error
+}
+
+// compileEventFilters compiles the expressions, which must evaluate to a bool
+func compileEventFilters(expressions []string) ([]*eventFilter, error) {
+	if len(expressions) == 0 {
+		return nil, nil
+	}
+	env, err := cel.NewEnv(eventFilterDeclarations)
+	if err != nil {
+		return nil, err
+	}
+	filters := []*eventFilter{}
+	for _, expression := range expressions {
+		ast, issues := env.Compile(expression)
+		if err := issues.Err(); err != nil {
+			return nil, fmt.Errorf("invalid --filter %q: %v", expression, err)
+		}
+		if !proto.Equal(ast.ResultType(), decls.Bool) {
+			return nil, fmt.Errorf("invalid --filter %q: must evaluate to a bool", expression)
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --filter %q: %v", expression, err)
+		}
+		filters = append(filters, &eventFilter{expression: expression, program: program})
+	}
+	return filters, nil
+}
+
+// matches returns true if the expression evaluates to true for the event.
+// Events the expression cannot be evaluated for do not match, and are counted in errors.
+func (f *eventFilter) matches(event *audit.Event) bool {
+	result, _, err := f.program.Eval(eventFilterVariables(event))
+	if err == nil {
+		if matches, ok := result.Value().(bool); ok {
+			return matches
+		}
+		err = fmt.Errorf("expected bool, got %v", result.Type())
+	}
+	if f.errors == 0 {
+		f.firstErr = err
+	}
+	f.errors++
+	return false
+}
+
+// eventFilterVariables returns the values of the variables declared in eventFilterDeclarations for the event
+func eventFilterVariables(event *audit.Event) map[string]interface{} {
+	eventUser := &event.User
+	if event.ImpersonatedUser != nil {
+		eventUser = event.ImpersonatedUser
+	}
+
+	objectRef := map[string]string{"resource": "", "subresource": "", "namespace": "", "name": "", "apiGroup": "", "apiVersion": ""}
+	if ref := event.ObjectRef; ref != nil {
+		objectRef["resource"] = ref.Resource
+		objectRef["subresource"] = ref.Subresource
+		objectRef["namespace"] = ref.Namespace
+		objectRef["name"] = ref.Name
+		objectRef["apiGroup"] = ref.APIGroup
+		objectRef["apiVersion"] = ref.APIVersion
+	}
+
+	responseStatus := map[string]interface{}{"code": int64(0), "status": "", "reason": "", "message": ""}
+	if status := event.ResponseStatus; status != nil {
+		responseStatus["code"] = int64(status.Code)
+		responseStatus["status"] = status.Status
+		responseStatus["reason"] = string(status.Reason)
+		responseStatus["message"] = status.Message
+	}
+
+	annotations := event.Annotations
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+
+	return map[string]interface{}{
+		"user":           eventUser.Username,
+		"groups":         append([]string{}, eventUser.Groups...),
+		"userAgent":      event.UserAgent,
+		"sourceIPs":      append([]string{}, event.SourceIPs...),
+		"verb":           event.Verb,
+		"objectRef":      objectRef,
+		"responseStatus": responseStatus,
+		"annotations":    annotations,
+	}
+}
+
+// writeFilterErrors warns about events the filters could not be evaluated for
+func writeFilterErrors(w io.Writer, filters []*eventFilter) {
+	for _, f := range filters {
+		if f.errors > 0 {
+			fmt.Fprintf(w, "Warning: excluded %d events --filter %q could not be evaluated for: %v\n", f.errors, f.expression, f.firstErr)
+		}
+	}
+}
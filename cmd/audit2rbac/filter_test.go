@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/apis/audit"
+)
+
+func TestEventFilters(t *testing.T) {
+	controllerManager := &audit.Event{
+		Verb:      "update",
+		UserAgent: "kube-controller-manager/v1.23.4 (linux/amd64) kubernetes/e6c093d/system:serviceaccount:kube-system:deployment-controller",
+		ObjectRef: &audit.ObjectReference{Resource: "deployments", Namespace: "ns1", Name: "app", APIGroup: "apps", APIVersion: "v1"},
+	}
+	controllerManager.User.Username = "system:serviceaccount:kube-system:deployment-controller"
+	controllerManager.User.Groups = []string{"system:serviceaccounts", "system:authenticated"}
+	controllerManager.SourceIPs = []string{"10.0.0.1"}
+
+	forbidden := *controllerManager
+	forbidden.ResponseStatus = &metav1.Status{Code: 403, Reason: metav1.StatusReasonForbidden}
+	forbidden.Annotations = map[string]string{"authorization.k8s.io/decision": "forbid"}
+
+	kubectl := &audit.Event{Verb: "list", UserAgent: "kubectl/v1.23.4 (linux/amd64) kubernetes/e6c093d"}
+	kubectl.User.Username = "alice"
+
+	testcases := []struct {
+		name        string
+		expressions []string
+		expected    []bool
+		expectedErr string
+	}{
+		{
+			name:        "user agent, status, and group",
+			expressions: []string{`userAgent.startsWith("kube-controller-manager") && responseStatus.code < 400 && objectRef.apiGroup == "apps"`},
+			expected:    []bool{true, false, false},
+		},
+		{
+			name:        "every expression must match",
+			expressions: []string{`"system:serviceaccounts" in groups`, `"authorization.k8s.io/decision" in annotations && annotations["authorization.k8s.io/decision"] == "forbid"`},
+			expected:    []bool{false, true, false},
+		},
+		{
+			name:        "missing object reference",
+			expressions: []string{`objectRef.resource == "" && verb == "list" && user == "alice" && size(sourceIPs) == 0`},
+			expected:    []bool{false, false, true},
+		},
+		{
+			name:        "invalid expression",
+			expressions: []string{`userAgent.startsWith(`},
+			expectedErr: `invalid --filter "userAgent.startsWith("`,
+		},
+		{
+			name:        "not a bool",
+			expressions: []string{`userAgent`},
+			expectedErr: `invalid --filter "userAgent": must evaluate to a bool`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			filters, err := compileEventFilters(tc.expressions)
+			if len(tc.expectedErr) > 0 {
+				if err == nil || !strings.HasPrefix(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected error starting with %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			matched := []bool{}
+			for _, event := range []*audit.Event{controllerManager, &forbidden, kubectl} {
+				matches := true
+				for _, filter := range filters {
+					matches = matches && filter.matches(event)
+				}
+				matched = append(matched, matches)
+			}
+			if !cmp.Equal(tc.expected, matched) {
+				t.Errorf("unexpected matches:\n%s", cmp.Diff(tc.expected, matched))
+			}
+
+			out := &bytes.Buffer{}
+			writeFilterErrors(out, filters)
+			if out.Len() > 0 {
+				t.Errorf("unexpected errors:\n%s", out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/liggitt/audit2rbac/pkg"
+)
+
+// writeIgnored writes the number of events ignored by each rule, including rules that ignored no events
+func writeIgnored(w io.Writer, rules []pkg.RequestRule, ignored []int) {
+	if len(rules) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Ignored %d events:\n", sum(ignored))
+	for i, rule := range rules {
+		fmt.Fprintf(w, "  %d by %s\n", ignored[i], describeRequestRule(rule))
+	}
+}
+
+func sum(values []int) int {
+	total := 0
+	for _, value := range values {
+		total += value
+	}
+	return total
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	f, err := ioutil.TempFile("", "ignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("- nonResourceURLs: [/api, /api/*, /apis, /apis/*]\n  verbs: [get]\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	rules, files, err := loadRequestRules([]string{`{verbs: [create], apiGroups: [""], resources: [events]}`, f.Name()}, "ignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"", f.Name()}; !cmp.Equal(expected, files) {
+		t.Errorf("unexpected files:\n%s", cmp.Diff(expected, files))
+	}
+
+	out := &bytes.Buffer{}
+	writeIgnored(out, rules, []int{3, 0})
+	expected := `Ignored 3 events:
+  3 by {APIGroups:[""], Resources:["events"], Verbs:["create"]}
+  0 by {NonResourceURLs:["/api" "/api/*" "/apis" "/apis/*"], Verbs:["get"]}
+`
+	if out.String() != expected {
+		t.Errorf("unexpected output:\n%s", cmp.Diff(expected, out.String()))
+	}
+
+	if _, _, err := loadRequestRules([]string{`{verbs: [create]}`}, "ignore"); err == nil || err.Error() != `error loading --ignore rules from {verbs: [create]}: invalid rule 0: apiGroups and resources, or nonResourceURLs, must not be empty` {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
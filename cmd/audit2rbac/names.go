@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liggitt/audit2rbac/pkg"
+
+	"k8s.io/apimachinery/pkg/api/validation/path"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apiserver/pkg/authentication/serviceaccount"
+)
+
+// maxNameLength is the maximum length of an RBAC object name
+const maxNameLength = 253
+
+// templateVariableNames describes the variables that can be referenced in templates
+const templateVariableNames = "${user}, ${namespace} and ${sa} (for service accounts), ${group}, ${version}, ${date}, and ${hash}. " +
+	"Values changed to be valid in names or labels have a hash appended"
+
+// templateVariables holds the values substituted for ${variable} references in name, label, and annotation templates
+type templateVariables struct {
+	user    string
+	version string
+	// namespace is the namespace of the service account, or the namespace events were filtered to
+	namespace string
+	// sa is the name of the service account
+	sa string
+	// group is the first group of the user other than system:authenticated
+	group string
+	date  string
+}
+
+func newTemplateVariables(username, namespace string, groups []string, now time.Time) templateVariables {
+	v := templateVariables{user: username, version: pkg.Version, namespace: namespace, date: now.UTC().Format("20060102")}
+	if ns, name, err := serviceaccount.SplitUsername(username); err == nil {
+		v.namespace, v.sa = ns, name
+	}
+	for _, group := range groups {
+		if group != "system:authenticated" {
+			v.group = group
+			break
+		}
+	}
+	return v
+}
+
+// render substitutes the variables in the template, sanitizing substituted values
+func (v templateVariables) render(template string, sanitize func(string) string) string {
+	values := []string{
+		"${user}", v.user,
+		"${version}", v.version,
+		"${namespace}", v.namespace,
+		"${sa}", v.sa,
+		"${group}", v.group,
+		"${date}", v.date,
+	}
+	for i := 0; i < len(values); i += 2 {
+		template = strings.Replace(template, values[i], sanitize(values[i+1]), -1)
+	}
+	// ${hash} identifies the user, and is already safe for names and labels
+	return strings.Replace(template, "${hash}", shortHash(v.user), -1)
+}
+
+// renderMetadata renders the name, label, and annotation templates
+func (v templateVariables) renderMetadata(nameTemplate string, labelTemplates, annotationTemplates []string) (name string, labels, annotations map[string]string) {
+	if len(nameTemplate) > 0 {
+		name = truncateWithHash(v.render(nameTemplate, sanitizeUniqueName), maxNameLength)
+	}
+
+	for _, s := range annotationTemplates {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		parts := strings.SplitN(v.render(s, noSanitize), "=", 2)
+		if len(parts) == 1 {
+			annotations[parts[0]] = ""
+		} else {
+			annotations[parts[0]] = parts[1]
+		}
+	}
+
+	return name, v.renderLabels(labelTemplates, sanitizeUniqueLabel), annotations
+}
+
+// renderLegacyLabels renders the label templates the way earlier releases did, without appending a hash to sanitized values,
+// so objects generated by them can still be selected
+func (v templateVariables) renderLegacyLabels(labelTemplates []string) map[string]string {
+	return v.renderLabels(labelTemplates, sanitizeLabel)
+}
+
+func (v templateVariables) renderLabels(labelTemplates []string, sanitize func(string) string) map[string]string {
+	var labels map[string]string
+	for _, s := range labelTemplates {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) == 1 {
+			labels[v.render(parts[0], noSanitize)] = ""
+		} else {
+			labels[v.render(parts[0], noSanitize)] = truncateWithHash(v.render(parts[1], sanitize), validation.LabelValueMaxLength)
+		}
+	}
+	return labels
+}
+
+func noSanitize(s string) string {
+	return s
+}
+
+func sanitizeName(s string) string {
+	return strings.ToLower(string(regexp.MustCompile(`[^a-zA-Z0-9:]`).ReplaceAll([]byte(s), []byte("-"))))
+}
+
+// sanitizeUniqueName sanitizes the value for use in a name, appending a hash of the original value if sanitizing changed it,
+// so distinct values do not collide. Names can contain ':', so service account and system: usernames are unchanged.
+func sanitizeUniqueName(s string) string {
+	if sanitized := sanitizeName(s); sanitized != s {
+		return sanitized + "-" + shortHash(s)
+	}
+	return s
+}
+
+func sanitizeLabel(s string) string {
+	return strings.ToLower(string(regexp.MustCompile(`[^a-zA-Z0-9]`).ReplaceAll([]byte(s), []byte("-"))))
+}
+
+// sanitizeUniqueLabel sanitizes the value for use in a label value, appending a hash of the original value if sanitizing changed it,
+// so distinct values do not collide
+func sanitizeUniqueLabel(s string) string {
+	if sanitized := sanitizeLabel(s); sanitized != s {
+		return sanitized + "-" + shortHash(s)
+	}
+	return s
+}
+
+// shortHash returns a short, deterministic hash of the value
+func shortHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+// truncateWithHash truncates values longer than max, replacing the end with a hash of the full value
+func truncateWithHash(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	hash := shortHash(s)
+	prefix := strings.TrimRight(s[:max-len(hash)-1], "-_.:")
+	if len(prefix) == 0 {
+		return hash
+	}
+	return prefix + "-" + hash
+}
+
+// validateMetadata returns errors describing invalid names, labels, or annotations of generated objects
+func validateMetadata(kind string, meta metav1.ObjectMeta) []string {
+	errs := []string{}
+	describe := kind + " " + meta.Name
+	if len(meta.Namespace) > 0 {
+		describe = kind + " " + meta.Namespace + "/" + meta.Name
+	}
+
+	if len(meta.Name) == 0 {
+		errs = append(errs, fmt.Sprintf("%s: name is required", describe))
+	} else if len(meta.Name) > maxNameLength {
+		errs = append(errs, fmt.Sprintf("%s: name must be no more than %d characters", describe, maxNameLength))
+	}
+	for _, msg := range path.IsValidPathSegmentName(meta.Name) {
+		errs = append(errs, fmt.Sprintf("%s: invalid name: %s", describe, msg))
+	}
+
+	for _, key := range sortedKeys(meta.Labels) {
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = append(errs, fmt.Sprintf("%s: invalid label key %q: %s", describe, key, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(meta.Labels[key]) {
+			errs = append(errs, fmt.Sprintf("%s: invalid value for label %q: %s", describe, key, msg))
+		}
+	}
+	for _, key := range sortedKeys(meta.Annotations) {
+		for _, msg := range validation.IsQualifiedName(strings.ToLower(key)) {
+			errs = append(errs, fmt.Sprintf("%s: invalid annotation key %q: %s", describe, key, msg))
+		}
+	}
+	return errs
+}
+
+// validateGenerated returns errors describing invalid metadata of the generated objects,
+// and objects of the same kind generated with the same namespace and name, which would replace each other when applied
+func validateGenerated(generated *pkg.RBACObjects) []string {
+	errs := []string{}
+	seen := map[string]bool{}
+	validate := func(kind string, meta metav1.ObjectMeta) {
+		errs = append(errs, validateMetadata(kind, meta)...)
+		describe := kind + " " + meta.Name
+		if len(meta.Namespace) > 0 {
+			describe = kind + " " + meta.Namespace + "/" + meta.Name
+		}
+		if seen[describe] {
+			errs = append(errs, fmt.Sprintf("%s: generated more than once, the name must be distinct for each subject", describe))
+		}
+		seen[describe] = true
+	}
+	for _, obj := range generated.Roles {
+		validate("role", obj.ObjectMeta)
+	}
+	for _, obj := range generated.ClusterRoles {
+		validate("clusterrole", obj.ObjectMeta)
+	}
+	for _, obj := range generated.RoleBindings {
+		validate("rolebinding", obj.ObjectMeta)
+	}
+	for _, obj := range generated.ClusterRoleBindings {
+		validate("clusterrolebinding", obj.ObjectMeta)
+	}
+	return errs
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/liggitt/audit2rbac/pkg"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRenderMetadata(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	testcases := []struct {
+		name                string
+		user                string
+		groups              []string
+		nameTemplate        string
+		labelTemplates      []string
+		annotationTemplates []string
+		expectedName        string
+		expectedLabels      map[string]string
+		expectedAnnotations map[string]string
+	}{
+		{
+			name:                "user",
+			user:                "bob",
+			nameTemplate:        "audit2rbac:${user}",
+			labelTemplates:      []string{"audit2rbac.liggitt.net/user=${user}", "audit2rbac.liggitt.net/generated=true"},
+			annotationTemplates: []string{"audit2rbac.liggitt.net/date=${date}"},
+			expectedName:        "audit2rbac:bob",
+			expectedLabels:      map[string]string{"audit2rbac.liggitt.net/user": "bob", "audit2rbac.liggitt.net/generated": "true"},
+			expectedAnnotations: map[string]string{"audit2rbac.liggitt.net/date": "20200102"},
+		},
+		{
+			name:           "service account",
+			user:           "system:serviceaccount:a-b:c",
+			groups:         []string{"system:authenticated", "system:serviceaccounts", "system:serviceaccounts:a-b"},
+			nameTemplate:   "${namespace}:${sa}",
+			labelTemplates: []string{"user=${user}", "group=${group}", "sa=${sa}"},
+			expectedName:   "a-b:c",
+			expectedLabels: map[string]string{
+				"user":  "system-serviceaccount-a-b-c-" + shortHash("system:serviceaccount:a-b:c"),
+				"group": "system-serviceaccounts-" + shortHash("system:serviceaccounts"),
+				"sa":    "c",
+			},
+		},
+		{
+			name:           "colliding service account",
+			user:           "system:serviceaccount:a:b-c",
+			nameTemplate:   "audit2rbac:${user}",
+			labelTemplates: []string{"user=${user}"},
+			// sanitized to the same label value as system:serviceaccount:a-b:c, but the appended hash keeps them distinct
+			expectedName:   "audit2rbac:system:serviceaccount:a:b-c",
+			expectedLabels: map[string]string{"user": "system-serviceaccount-a-b-c-" + shortHash("system:serviceaccount:a:b-c")},
+		},
+		{
+			name:           "hash",
+			user:           "Bob@example.com",
+			nameTemplate:   "audit2rbac:${user}",
+			labelTemplates: []string{"hash=${hash}"},
+			expectedName:   "audit2rbac:bob-example-com-" + shortHash("Bob@example.com"),
+			expectedLabels: map[string]string{"hash": shortHash("Bob@example.com")},
+		},
+		{
+			name:           "long",
+			user:           strings.Repeat("a", 300),
+			nameTemplate:   "audit2rbac:${user}",
+			labelTemplates: []string{"user=${user}"},
+			expectedName:   "audit2rbac:" + strings.Repeat("a", 253-len("audit2rbac:")-9) + "-" + shortHash("audit2rbac:"+strings.Repeat("a", 300)),
+			expectedLabels: map[string]string{"user": strings.Repeat("a", 63-9) + "-" + shortHash(strings.Repeat("a", 300))},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			vars := newTemplateVariables(tc.user, "", tc.groups, now)
+			name, labels, annotations := vars.renderMetadata(tc.nameTemplate, tc.labelTemplates, tc.annotationTemplates)
+			if name != tc.expectedName {
+				t.Errorf("expected name %q, got %q", tc.expectedName, name)
+			}
+			if !reflect.DeepEqual(tc.expectedLabels, labels) {
+				t.Errorf("unexpected labels:\n%s", cmp.Diff(tc.expectedLabels, labels))
+			}
+			if !reflect.DeepEqual(tc.expectedAnnotations, annotations) {
+				t.Errorf("unexpected annotations:\n%s", cmp.Diff(tc.expectedAnnotations, annotations))
+			}
+			if errs := validateMetadata("role", metav1.ObjectMeta{Name: name, Labels: labels, Annotations: annotations}); len(errs) > 0 && name != "" {
+				t.Errorf("unexpected validation errors: %v", errs)
+			}
+		})
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	meta := metav1.ObjectMeta{
+		Name:        "a/b",
+		Namespace:   "ns1",
+		Labels:      map[string]string{"user": "-bob"},
+		Annotations: map[string]string{"bad key": ""},
+	}
+	expected := []string{
+		`role ns1/a/b: invalid name: `,
+		`role ns1/a/b: invalid value for label "user": `,
+		`role ns1/a/b: invalid annotation key "bad key": `,
+	}
+	errs := validateMetadata("role", meta)
+	if len(errs) != len(expected) {
+		t.Fatalf("unexpected errors:\n%s", cmp.Diff(expected, errs))
+	}
+	for i := range expected {
+		if !strings.HasPrefix(errs[i], expected[i]) {
+			t.Errorf("expected error starting with %q, got %q", expected[i], errs[i])
+		}
+	}
+
+	if errs := validateMetadata("role", metav1.ObjectMeta{Name: "audit2rbac:bob", Labels: map[string]string{"audit2rbac.liggitt.net/user": "bob"}}); len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestValidateGeneratedDuplicates(t *testing.T) {
+	generated := &pkg.RBACObjects{
+		Roles: []*rbacv1.Role{
+			{ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac", Namespace: "ns1"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac", Namespace: "ns2"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac", Namespace: "ns1"}},
+		},
+		ClusterRoles: []*rbacv1.ClusterRole{
+			{ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac"}},
+		},
+		RoleBindings: []*rbacv1.RoleBinding{
+			{ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac", Namespace: "ns1"}},
+		},
+	}
+	expected := []string{"role ns1/audit2rbac: generated more than once, the name must be distinct for each subject"}
+	if diff := cmp.Diff(expected, validateGenerated(generated)); diff != "" {
+		t.Errorf("unexpected errors:\n%s", diff)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/liggitt/audit2rbac/pkg"
+)
+
+// writeDeniedByPolicy writes the requests denied by policy, grouped by the never-grant rule they matched
+func writeDeniedByPolicy(w io.Writer, rules []pkg.RequestRule, denied []*pkg.AggregatedRequest) {
+	if len(denied) == 0 {
+		return
+	}
+	byRule := make([][]*pkg.AggregatedRequest, len(rules))
+	for _, request := range denied {
+		if i := pkg.MatchRequestRules(rules, request.Attributes); i >= 0 {
+			byRule[i] = append(byRule[i], request)
+		}
+	}
+
+	fmt.Fprintf(w, "Denied by policy, and not granted:\n")
+	for i, requests := range byRule {
+		if len(requests) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "  never-grant %s:\n", describeRequestRule(rules[i]))
+		for _, request := range requests {
+			fmt.Fprintf(w, "    %s%s\n", describeRequest(request.Attributes), describeEvidence(request))
+		}
+	}
+}
+
+// policyFindings returns a warning finding for each request denied by policy,
+// located in the file the matching rule was read from, if it was not specified inline
+func policyFindings(rules []pkg.RequestRule, files []string, denied []*pkg.AggregatedRequest, user string) []pkg.Finding {
+	findings := []pkg.Finding{}
+	for _, request := range denied {
+		description := describeRequest(request.Attributes)
+		finding := pkg.Finding{
+			Rule:    "denied-by-policy",
+			Level:   "warning",
+			Name:    strings.TrimSpace(description),
+			Message: fmt.Sprintf("%s by %s was denied by policy%s", description, user, describeEvidence(request)),
+		}
+		if i := pkg.MatchRequestRules(rules, request.Attributes); i >= 0 {
+			finding.Message += ", matching never-grant rule " + describeRequestRule(rules[i])
+			if i < len(files) && len(files[i]) > 0 {
+				finding.Location = &pkg.ObjectLocation{File: files[i], Rule: -1}
+			}
+		}
+		findings = append(findings, finding)
+	}
+	return findings
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/liggitt/audit2rbac/pkg"
+
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func TestWriteDeniedByPolicy(t *testing.T) {
+	rules := []pkg.RequestRule{
+		{PolicyRule: rbacv1helper.NewRule("*").Groups("").Resources("secrets").RuleOrDie(), ClusterWide: true},
+		{PolicyRule: rbacv1helper.NewRule("delete").Groups("").Resources("nodes").RuleOrDie()},
+		{PolicyRule: rbacv1helper.NewRule("get").URLs("/debug/*").RuleOrDie()},
+	}
+	denied := []*pkg.AggregatedRequest{
+		{Attributes: authorizer.AttributesRecord{ResourceRequest: true, Verb: "delete", Resource: "nodes", Name: "node1"}, Count: 1},
+		{Attributes: authorizer.AttributesRecord{ResourceRequest: true, Verb: "list", Resource: "secrets"}, Count: 2},
+		{Attributes: authorizer.AttributesRecord{ResourceRequest: true, Verb: "watch", Resource: "secrets"}, Count: 1},
+	}
+
+	out := &bytes.Buffer{}
+	writeDeniedByPolicy(out, rules, denied)
+	expected := `Denied by policy, and not granted:
+  never-grant {APIGroups:[""], Resources:["secrets"], Verbs:["*"]} cluster-wide:
+    list secrets (2 events)
+    watch secrets (1 event)
+  never-grant {APIGroups:[""], Resources:["nodes"], Verbs:["delete"]}:
+    delete nodes node1 (1 event)
+`
+	if out.String() != expected {
+		t.Errorf("unexpected output:\n%s", cmp.Diff(expected, out.String()))
+	}
+
+	out.Reset()
+	writeDeniedByPolicy(out, rules, nil)
+	if out.Len() != 0 {
+		t.Errorf("expected no output, got %q", out.String())
+	}
+
+	// findings are located in the file the matching rule was read from, unless it was specified inline
+	findings := policyFindings(rules, []string{"", "never-grant.yaml", "never-grant.yaml"}, denied, "bob")
+	locations := []*pkg.ObjectLocation{}
+	for _, finding := range findings {
+		locations = append(locations, finding.Location)
+	}
+	if expected := []*pkg.ObjectLocation{{File: "never-grant.yaml", Rule: -1}, nil, nil}; !cmp.Equal(expected, locations) {
+		t.Errorf("unexpected locations:\n%s", cmp.Diff(expected, locations))
+	}
+}
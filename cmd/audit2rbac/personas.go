@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/liggitt/audit2rbac/pkg"
+	"github.com/spf13/cobra"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apiserver/pkg/authentication/serviceaccount"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func newPersonasCommand(options *Audit2RBACOptions, complete func() error) *cobra.Command {
+	personas := &PersonasOptions{Audit2RBACOptions: options, Similarity: 0.8, SharedName: "audit2rbac"}
+	users := []string{}
+	serviceAccounts := []string{}
+
+	cmd := &cobra.Command{
+		Use:   "personas --filename=audit.log --user=alice --user=bob [ --serviceaccount=my-namespace:my-sa ... ]",
+		Short: "Generate roles for several subjects, sharing identical roles and suggesting personas",
+		Long: "Generate roles for several subjects, sharing identical roles and suggesting personas.\n\n" +
+			"Roles with identical rules in the same namespace are replaced by a single shared role bound to each subject that needs it. " +
+			"Subjects with similar permissions are grouped into suggested personas, which are printed with the permissions each subject would gain from its persona.",
+		Run: func(cmd *cobra.Command, args []string) {
+			checkErr(options.Stderr, complete())
+			checkErr(options.Stderr, personas.Complete(users, serviceAccounts))
+
+			if err := personas.Validate(); err != nil {
+				fmt.Fprintln(options.Stderr, err)
+				fmt.Fprintln(options.Stderr)
+				cmd.Help()
+				os.Exit(1)
+			}
+
+			checkErr(options.Stderr, personas.Run())
+		},
+	}
+
+	// these replace the --user and --serviceaccount flags of the parent command, which only accept one subject
+	cmd.Flags().StringArrayVar(&users, "user", users, "User to filter audit events to and generate role bindings for. Can be specified more than once")
+	cmd.Flags().StringArrayVar(&serviceAccounts, "serviceaccount", serviceAccounts, "Service account to filter audit events to and generate role bindings for, in format <namespace>:<name>. Can be specified more than once")
+	cmd.Flags().Float64Var(&personas.Similarity, "similarity", personas.Similarity, "Minimum Jaccard similarity between the permissions of a subject and a persona for the subject to join it, from 0 to 1. 1 only groups subjects with identical permissions")
+	cmd.Flags().StringVar(&personas.SharedName, "shared-name", personas.SharedName, "Name prefix for shared roles and bindings, which are named <prefix>:shared, <prefix>:shared:2, and so on")
+
+	return cmd
+}
+
+type PersonasOptions struct {
+	*Audit2RBACOptions
+
+	// Similarity is the minimum similarity of the permissions of a subject and a persona for the subject to join it
+	Similarity float64
+	// SharedName is the name prefix for shared roles and bindings
+	SharedName string
+}
+
+// Complete sets the users to generate roles for from the users and service accounts
+func (p *PersonasOptions) Complete(users, serviceAccounts []string) error {
+	p.users = append([]string{}, users...)
+	for _, serviceAccount := range serviceAccounts {
+		parts := strings.Split(serviceAccount, ":")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("service account must be in the format <namespace>:<name>")
+		}
+		p.users = append(p.users, serviceaccount.MakeUsername(parts[0], parts[1]))
+	}
+	return nil
+}
+
+func (p *PersonasOptions) Validate() error {
+	if len(p.users) < 2 {
+		return fmt.Errorf("at least two --user or --serviceaccount subjects are required")
+	}
+	if len(p.PreviousSources) > 0 {
+		return fmt.Errorf("--previous cannot be combined with personas")
+	}
+	if p.Similarity < 0 || p.Similarity > 1 {
+		return fmt.Errorf("--similarity must be between 0 and 1")
+	}
+	if len(p.SharedName) == 0 {
+		return fmt.Errorf("--shared-name is required")
+	}
+	return p.Audit2RBACOptions.Validate()
+}
+
+func (p *PersonasOptions) Run() error {
+	events, err := p.loadEvents()
+	if err != nil {
+		return err
+	}
+	sources, err := p.loadGenerateSources(events)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range p.users {
+		if events.byUser[user] == nil {
+			fmt.Fprintf(p.Stderr, "Warning: no audit events matched user %s\n", user)
+		}
+	}
+
+	results := []*pkg.RBACObjects{}
+	labels := []map[string]string{}
+	for _, partition := range events.partitions() {
+		p.User = partition.user
+		p.renderMetadata(partition.groups)
+		_, generated, err := p.generate(partition, sources, false)
+		if err != nil {
+			return err
+		}
+		results = append(results, generated)
+		labels = append(labels, p.Labels)
+	}
+
+	writePersonas(p.Stderr, pkg.SuggestPersonas(results, p.Similarity))
+
+	opts := pkg.DefaultGenerateOptions()
+	opts.Name = p.SharedName
+	// shared objects keep the labels generated for every subject
+	opts.Labels = commonLabels(labels)
+	merged := pkg.MergeIdenticalRoles(results, opts)
+	if errs := validateGenerated(merged); len(errs) > 0 {
+		return fmt.Errorf("generated objects are invalid:\n  %s", strings.Join(errs, "\n  "))
+	}
+
+	fmt.Fprintln(p.Stderr, "Generating roles...")
+	writeObjects(p.Stdout, merged, "")
+	fmt.Fprintln(p.Stderr, "Complete!")
+
+	if events.hasErrors {
+		return fmt.Errorf("Errors occurred reading audit events")
+	}
+	return nil
+}
+
+// forUser returns the events made by the user, adding them to byUser if needed
+func (e *auditEvents) forUser(user string) *auditEvents {
+	if e.byUser == nil {
+		e.byUser = map[string]*auditEvents{}
+	}
+	events, ok := e.byUser[user]
+	if !ok {
+		events = &auditEvents{user: user, requests: pkg.NewRequestAggregator(), grants: []pkg.Grant{}, neverGrant: e.neverGrant, digests: e.digests}
+		e.byUser[user] = events
+	}
+	return events
+}
+
+// commonLabels returns the labels with the same value in every set of labels
+func commonLabels(labels []map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	common := map[string]string{}
+	for k, v := range labels[0] {
+		common[k] = v
+	}
+	for _, l := range labels[1:] {
+		for k, v := range common {
+			if l[k] != v {
+				delete(common, k)
+			}
+		}
+	}
+	return common
+}
+
+// writePersonas writes the suggested personas, with their rules and the permissions each subject would gain from them
+func writePersonas(w io.Writer, personas []pkg.Persona) {
+	fmt.Fprintln(w, "Suggested personas:")
+	for i, persona := range personas {
+		subjects := make([]string, len(persona.Subjects))
+		for j, subject := range persona.Subjects {
+			subjects[j] = describeSubject(subject)
+		}
+		fmt.Fprintf(w, "  %d: %s\n", i+1, strings.Join(subjects, ", "))
+		writeScopedRules(w, "    ", persona.Rules)
+		for j, extra := range persona.ExtraRules {
+			if len(extra) == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "    %s would gain:\n", subjects[j])
+			writeScopedRules(w, "      ", extra)
+		}
+	}
+}
+
+// writeScopedRules writes rules keyed by namespace, cluster-wide rules first
+func writeScopedRules(w io.Writer, indent string, rules map[string][]rbacv1.PolicyRule) {
+	namespaces := make([]string, 0, len(rules))
+	for namespace := range rules {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		scope := "cluster-wide"
+		if len(namespace) > 0 {
+			scope = "in namespace " + namespace
+		}
+		for _, rule := range rules[namespace] {
+			fmt.Fprintf(w, "%s%s %s\n", indent, scope, rbacv1helper.CompactString(rule))
+		}
+	}
+}
+
+// describeSubject returns the username of the subject
+func describeSubject(subject rbacv1.Subject) string {
+	if subject.Kind == rbacv1.ServiceAccountKind {
+		return serviceaccount.MakeUsername(subject.Namespace, subject.Name)
+	}
+	return subject.Name
+}
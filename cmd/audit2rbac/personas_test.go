@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/liggitt/audit2rbac/pkg"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func TestWritePersonas(t *testing.T) {
+	personas := []pkg.Persona{
+		{
+			Subjects: []rbacv1.Subject{
+				{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "alice"},
+				{Kind: rbacv1.ServiceAccountKind, Namespace: "ns1", Name: "sa1"},
+			},
+			Rules: map[string][]rbacv1.PolicyRule{
+				"":    {rbacv1helper.NewRule("get").Groups("").Resources("nodes").RuleOrDie()},
+				"ns1": {rbacv1helper.NewRule("list").Groups("").Resources("pods").RuleOrDie()},
+			},
+			ExtraRules: []map[string][]rbacv1.PolicyRule{
+				{},
+				{"": {rbacv1helper.NewRule("get").Groups("").Resources("nodes").RuleOrDie()}},
+			},
+		},
+		{
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "bob"}},
+			Rules:      map[string][]rbacv1.PolicyRule{"ns2": {rbacv1helper.NewRule("create").Groups("").Resources("configmaps").RuleOrDie()}},
+			ExtraRules: []map[string][]rbacv1.PolicyRule{{}},
+		},
+	}
+
+	out := &bytes.Buffer{}
+	writePersonas(out, personas)
+	expected := `Suggested personas:
+  1: alice, system:serviceaccount:ns1:sa1
+    cluster-wide {APIGroups:[""], Resources:["nodes"], Verbs:["get"]}
+    in namespace ns1 {APIGroups:[""], Resources:["pods"], Verbs:["list"]}
+    system:serviceaccount:ns1:sa1 would gain:
+      cluster-wide {APIGroups:[""], Resources:["nodes"], Verbs:["get"]}
+  2: bob
+    in namespace ns2 {APIGroups:[""], Resources:["configmaps"], Verbs:["create"]}
+`
+	if out.String() != expected {
+		t.Errorf("unexpected output:\n%s", cmp.Diff(expected, out.String()))
+	}
+}
+
+func TestCommonLabels(t *testing.T) {
+	labels := []map[string]string{
+		{"audit2rbac.liggitt.net/user": "alice", "audit2rbac.liggitt.net/generated": "true"},
+		{"audit2rbac.liggitt.net/user": "bob", "audit2rbac.liggitt.net/generated": "true"},
+	}
+	expected := map[string]string{"audit2rbac.liggitt.net/generated": "true"}
+	if diff := cmp.Diff(expected, commonLabels(labels)); diff != "" {
+		t.Errorf("unexpected labels:\n%s", diff)
+	}
+	if len(labels[0]) != 2 {
+		t.Errorf("expected labels to be unmodified, got %v", labels[0])
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/liggitt/audit2rbac/pkg"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+// selectPrevious returns the objects generated by an earlier run, identified by the generated labels,
+// or by the generated name if no labels are generated. Objects generated by earlier releases are identified
+// by legacyLabels, which do not have a hash appended to sanitized values.
+func selectPrevious(objects pkg.RBACObjects, generatedLabels, legacyLabels map[string]string, name string) pkg.RBACObjects {
+	matches := func(meta metav1.ObjectMeta) bool {
+		if len(generatedLabels) == 0 {
+			return meta.Name == name
+		}
+		if labels.SelectorFromSet(generatedLabels).Matches(labels.Set(meta.Labels)) {
+			return true
+		}
+		return len(legacyLabels) > 0 && labels.SelectorFromSet(legacyLabels).Matches(labels.Set(meta.Labels))
+	}
+
+	selected := pkg.RBACObjects{}
+	for _, obj := range objects.Roles {
+		if matches(obj.ObjectMeta) {
+			selected.Roles = append(selected.Roles, obj)
+		}
+	}
+	for _, obj := range objects.ClusterRoles {
+		if matches(obj.ObjectMeta) {
+			selected.ClusterRoles = append(selected.ClusterRoles, obj)
+		}
+	}
+	for _, obj := range objects.RoleBindings {
+		if matches(obj.ObjectMeta) {
+			selected.RoleBindings = append(selected.RoleBindings, obj)
+		}
+	}
+	for _, obj := range objects.ClusterRoleBindings {
+		if matches(obj.ObjectMeta) {
+			selected.ClusterRoleBindings = append(selected.ClusterRoleBindings, obj)
+		}
+	}
+	return selected
+}
+
+// writeRulesDiff writes the rules in the diff, grouped by namespace
+func writeRulesDiff(w io.Writer, diffs []pkg.RulesDiff) {
+	for _, diff := range diffs {
+		if len(diff.Namespace) == 0 {
+			fmt.Fprintln(w, "  cluster-wide:")
+		} else {
+			fmt.Fprintf(w, "  in namespace %s:\n", diff.Namespace)
+		}
+		for _, rule := range diff.Rules {
+			fmt.Fprintf(w, "    %s\n", rbacv1helper.CompactString(rule))
+		}
+	}
+}
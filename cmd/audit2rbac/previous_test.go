@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/liggitt/audit2rbac/pkg"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSelectPrevious(t *testing.T) {
+	generatedLabels := map[string]string{"audit2rbac.liggitt.net/user": "bob", "audit2rbac.liggitt.net/generated": "true"}
+	objects := pkg.RBACObjects{
+		Roles: []*rbacv1.Role{
+			{ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac:bob", Namespace: "ns1", Labels: map[string]string{"audit2rbac.liggitt.net/user": "bob", "audit2rbac.liggitt.net/generated": "true", "team": "a"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac:bob", Namespace: "ns2", Labels: map[string]string{"audit2rbac.liggitt.net/user": "alice", "audit2rbac.liggitt.net/generated": "true"}}},
+		},
+		ClusterRoles: []*rbacv1.ClusterRole{
+			{ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac:bob"}},
+		},
+	}
+
+	selected := selectPrevious(objects, generatedLabels, nil, "audit2rbac:bob")
+	if len(selected.Roles) != 1 || selected.Roles[0].Namespace != "ns1" || len(selected.ClusterRoles) != 0 {
+		t.Errorf("unexpected objects selected by labels: %#v", selected)
+	}
+
+	selected = selectPrevious(objects, nil, nil, "audit2rbac:bob")
+	if len(selected.Roles) != 2 || len(selected.ClusterRoles) != 1 {
+		t.Errorf("unexpected objects selected by name: %#v", selected)
+	}
+
+	// objects generated by earlier releases have label values without a hash
+	vars := newTemplateVariables("system:serviceaccount:a-b:c", "", nil, time.Now())
+	_, generatedLabels, _ = vars.renderMetadata("", []string{"user=${user}"}, nil)
+	legacyLabels := vars.renderLegacyLabels([]string{"user=${user}"})
+	otherVars := newTemplateVariables("system:serviceaccount:a:b-c", "", nil, time.Now())
+	_, otherLabels, _ := otherVars.renderMetadata("", []string{"user=${user}"}, nil)
+	objects = pkg.RBACObjects{
+		Roles: []*rbacv1.Role{
+			{ObjectMeta: metav1.ObjectMeta{Name: "current", Namespace: "ns1", Labels: generatedLabels}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "legacy", Namespace: "ns1", Labels: map[string]string{"user": "system-serviceaccount-a-b-c"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "ns1", Labels: otherLabels}},
+		},
+	}
+	selected = selectPrevious(objects, generatedLabels, legacyLabels, "")
+	if len(selected.Roles) != 2 || selected.Roles[0].Name != "current" || selected.Roles[1].Name != "legacy" {
+		t.Errorf("unexpected objects selected by legacy labels: %#v", selected.Roles)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/liggitt/audit2rbac/pkg"
+	"github.com/spf13/cobra"
+
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func newPruneCommand(options *Audit2RBACOptions, complete func() error) *cobra.Command {
+	prune := &PruneOptions{Audit2RBACOptions: options}
+
+	cmd := &cobra.Command{
+		Use:   "prune --filename=audit.log --role=role.yaml [ --user=bob | --serviceaccount=my-namespace:my-sa ]",
+		Short: "Rewrite existing roles to keep only the permissions audit events used",
+		Long: "Rewrite existing roles to keep only the permissions audit events used, preserving their names, labels, annotations, and bindings.\n\n" +
+			"Permissions are attributed using the bindings to the user in the role files. " +
+			"Roles that are not bound to the user are considered granted in their namespace, and cluster roles cluster-wide.",
+		Run: func(cmd *cobra.Command, args []string) {
+			checkErr(options.Stderr, complete())
+
+			if err := prune.Validate(); err != nil {
+				fmt.Fprintln(options.Stderr, err)
+				fmt.Fprintln(options.Stderr)
+				cmd.Help()
+				os.Exit(1)
+			}
+
+			checkErr(options.Stderr, prune.Run())
+		},
+	}
+
+	cmd.Flags().StringArrayVar(&prune.RoleSources, "role", prune.RoleSources, "File or URL containing the roles to prune, and optionally their bindings")
+
+	return cmd
+}
+
+type PruneOptions struct {
+	*Audit2RBACOptions
+
+	// RoleSources is a list of files or URLs containing roles and bindings.
+	// Format must be JSON or YAML RBAC objects or List.v1 objects.
+	RoleSources []string
+}
+
+func (p *PruneOptions) Validate() error {
+	if err := p.Audit2RBACOptions.Validate(); err != nil {
+		return err
+	}
+	if len(p.RoleSources) == 0 {
+		return fmt.Errorf("--role is required")
+	}
+	// permissions used only by dropped requests would be removed from roles the user still needs
+	if len(p.IgnoreSources) > 0 || len(p.NeverGrantSources) > 0 {
+		return fmt.Errorf("--ignore and --never-grant cannot be combined with prune")
+	}
+	return nil
+}
+
+func (p *PruneOptions) Run() error {
+	objects, err := loadRBACObjects(p.RoleSources)
+	if err != nil {
+		return err
+	}
+	if len(objects.Roles) == 0 && len(objects.ClusterRoles) == 0 {
+		return fmt.Errorf("no roles or cluster roles found in %s", strings.Join(p.RoleSources, ", "))
+	}
+
+	events, err := p.loadEvents()
+	if err != nil {
+		return err
+	}
+
+	// the groups of the user are only known from the audit events
+	pruned := pkg.PruneRoles(&objects, events.requests.Requests()[0].Attributes.User, events.requests.Requests())
+	for _, role := range pruned.Roles {
+		if len(role.Rules) == 0 {
+			fmt.Fprintf(p.Stderr, "Warning: role %s/%s allowed no requests, and has no rules\n", role.Namespace, role.Name)
+		}
+	}
+	for _, role := range pruned.ClusterRoles {
+		if len(role.Rules) == 0 {
+			fmt.Fprintf(p.Stderr, "Warning: clusterrole %s allowed no requests, and has no rules\n", role.Name)
+		}
+	}
+
+	if removed := pkg.DiffRoleRules(pruned, &objects); len(removed) == 0 {
+		fmt.Fprintln(p.Stderr, "No permissions were removed")
+	} else {
+		fmt.Fprintln(p.Stderr, "Removed permissions:")
+		writeRoleRulesDiff(p.Stderr, removed)
+	}
+
+	writeObjects(p.Stdout, pruned, "")
+
+	if events.hasErrors {
+		return fmt.Errorf("Errors occurred reading audit events")
+	}
+	return nil
+}
+
+// writeRoleRulesDiff writes the rules in the diff, grouped by role
+func writeRoleRulesDiff(w io.Writer, diffs []pkg.RoleRulesDiff) {
+	for _, diff := range diffs {
+		if len(diff.Namespace) == 0 {
+			fmt.Fprintf(w, "  %s %s:\n", strings.ToLower(diff.Kind), diff.Name)
+		} else {
+			fmt.Fprintf(w, "  %s %s/%s:\n", strings.ToLower(diff.Kind), diff.Namespace, diff.Name)
+		}
+		for _, rule := range diff.Rules {
+			fmt.Fprintf(w, "    %s\n", rbacv1helper.CompactString(rule))
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/liggitt/audit2rbac/pkg"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func TestWriteRoleRulesDiff(t *testing.T) {
+	diffs := []pkg.RoleRulesDiff{
+		{Kind: "ClusterRole", Name: "reader", Rules: []rbacv1.PolicyRule{rbacv1helper.NewRule("get").URLs("/metrics").RuleOrDie()}},
+		{Kind: "Role", Namespace: "ns1", Name: "app", Rules: []rbacv1.PolicyRule{rbacv1helper.NewRule("update").Groups("").Resources("configmaps").Names("b").RuleOrDie()}},
+	}
+
+	out := &bytes.Buffer{}
+	writeRoleRulesDiff(out, diffs)
+	expected := `  clusterrole reader:
+    {NonResourceURLs:["/metrics"], Verbs:["get"]}
+  role ns1/app:
+    {APIGroups:[""], Resources:["configmaps"], ResourceNames:["b"], Verbs:["update"]}
+`
+	if out.String() != expected {
+		t.Errorf("unexpected output:\n%s", cmp.Diff(expected, out.String()))
+	}
+}
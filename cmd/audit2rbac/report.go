@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	"github.com/liggitt/audit2rbac/pkg"
+	"github.com/spf13/cobra"
+)
+
+// ReportOptions holds the files to write machine-readable reports of findings to
+type ReportOptions struct {
+	// JUnitFile is the file to write a JUnit XML report to
+	JUnitFile string
+	// SARIFFile is the file to write a SARIF report to
+	SARIFFile string
+}
+
+func addReportFlags(cmd *cobra.Command, r *ReportOptions) {
+	cmd.Flags().StringVar(&r.JUnitFile, "junit-report", r.JUnitFile, "File to write a JUnit XML report to, with a failing test case for each finding")
+	cmd.Flags().StringVar(&r.SARIFFile, "sarif-report", r.SARIFFile, "File to write a SARIF report of findings to")
+}
+
+// Write writes the findings to the requested reports. The suite names the JUnit test suite.
+func (r *ReportOptions) Write(suite string, findings []pkg.Finding) error {
+	if len(r.JUnitFile) > 0 {
+		out := &bytes.Buffer{}
+		if err := pkg.WriteJUnit(out, suite, findings); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(r.JUnitFile, out.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	if len(r.SARIFFile) > 0 {
+		out := &bytes.Buffer{}
+		if err := pkg.WriteSARIF(out, findings); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(r.SARIFFile, out.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
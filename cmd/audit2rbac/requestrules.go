@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/liggitt/audit2rbac/pkg"
+
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+// loadRequestRules reads request rules from the sources. Sources starting with '{' or '[' are inline YAML or JSON rules,
+// others are files or URLs. The flag name is used in errors.
+func loadRequestRules(sources []string, flag string) ([]pkg.RequestRule, []string, error) {
+	rules := []pkg.RequestRule{}
+	files := []string{}
+	for _, source := range sources {
+		var data []byte
+		file := ""
+		if trimmed := strings.TrimSpace(source); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			data = []byte(source)
+		} else {
+			streams, errs := openStreams([]string{source})
+			if len(errs) > 0 {
+				return nil, nil, errs[0]
+			}
+			var err error
+			data, err = readAndClose(streams[0])
+			if err != nil {
+				return nil, nil, err
+			}
+			file = source
+		}
+		sourceRules, err := pkg.ParseRequestRules(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error loading --%s rules from %s: %v", flag, source, err)
+		}
+		rules = append(rules, sourceRules...)
+		for range sourceRules {
+			files = append(files, file)
+		}
+	}
+	return rules, files, nil
+}
+
+func readAndClose(r io.ReadCloser) ([]byte, error) {
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func describeRequestRule(rule pkg.RequestRule) string {
+	s := rbacv1helper.CompactString(rule.PolicyRule)
+	if rule.ClusterWide {
+		s += " cluster-wide"
+	}
+	return s
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/liggitt/audit2rbac/pkg"
+
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+// writeRisks writes a summary of the risks, followed by each risk
+func writeRisks(w io.Writer, risks []pkg.Risk) {
+	if len(risks) == 0 {
+		fmt.Fprintln(w, "No risky permissions found in generated roles")
+		return
+	}
+	fmt.Fprintf(w, "Risky permissions found in generated roles: %d high, %d medium, %d low\n",
+		countSeverity(risks, pkg.SeverityHigh), countSeverity(risks, pkg.SeverityMedium), countSeverity(risks, pkg.SeverityLow))
+	for _, risk := range risks {
+		fmt.Fprintf(w, "  %s: %s %s", risk.Severity, strings.ToLower(risk.Kind), risk.Name)
+		if len(risk.Namespace) > 0 {
+			fmt.Fprintf(w, " in namespace %s", risk.Namespace)
+		}
+		fmt.Fprintf(w, " %s %s (%s)\n", rbacv1helper.CompactString(risk.Rule), risk.Explanation, risk.Check)
+	}
+}
+
+func countSeverity(risks []pkg.Risk, severity pkg.Severity) int {
+	count := 0
+	for _, risk := range risks {
+		if risk.Severity == severity {
+			count++
+		}
+	}
+	return count
+}
+
+// countRisks returns the number of risks of the specified severity or higher
+func countRisks(risks []pkg.Risk, severity pkg.Severity) int {
+	count := 0
+	for _, risk := range risks {
+		if risk.Severity >= severity {
+			count++
+		}
+	}
+	return count
+}
+
+// riskFindings returns a finding for each risk, located at the risky rule in the file the objects are written to
+func riskFindings(risks []pkg.Risk, files rbacObjectFiles) []pkg.Finding {
+	levels := map[pkg.Severity]string{pkg.SeverityHigh: "error", pkg.SeverityMedium: "warning", pkg.SeverityLow: "note"}
+	findings := []pkg.Finding{}
+	for _, risk := range risks {
+		location := files.location(risk.Kind, risk.Namespace, risk.Name, risk.RuleIndex)
+		findings = append(findings, pkg.Finding{
+			Rule:     "risk-" + risk.Check,
+			Level:    levels[risk.Severity],
+			Name:     location.String(),
+			Message:  fmt.Sprintf("%s severity: %s %s", risk.Severity, rbacv1helper.CompactString(risk.Rule), risk.Explanation),
+			Location: location,
+		})
+	}
+	return findings
+}
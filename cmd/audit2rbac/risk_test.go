@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/liggitt/audit2rbac/pkg"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func TestWriteRisks(t *testing.T) {
+	objects := &pkg.RBACObjects{
+		Roles: []*rbacv1.Role{{
+			ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac:bob", Namespace: "ns1"},
+			Rules:      []rbacv1.PolicyRule{rbacv1helper.NewRule("create").Groups("").Resources("pods").RuleOrDie()},
+		}},
+		ClusterRoles: []*rbacv1.ClusterRole{{
+			ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac:bob"},
+			Rules:      []rbacv1.PolicyRule{rbacv1helper.NewRule("list").Groups("").Resources("secrets").RuleOrDie()},
+		}},
+	}
+	risks := pkg.AnalyzeRisks(objects)
+
+	out := &bytes.Buffer{}
+	writeRisks(out, risks)
+	expected := `Risky permissions found in generated roles: 1 high, 1 medium, 0 low
+  medium: role audit2rbac:bob in namespace ns1 {APIGroups:[""], Resources:["pods"], Verbs:["create"]} allows running pods as any service account in the namespace, with its permissions, and mounting any secret in the namespace (create-pods)
+  high: clusterrole audit2rbac:bob {APIGroups:[""], Resources:["secrets"], Verbs:["list"]} allows reading the contents of every secret, including service account tokens (list-secrets)
+`
+	if out.String() != expected {
+		t.Errorf("unexpected output:\n%s", cmp.Diff(expected, out.String()))
+	}
+
+	if count := countRisks(risks, pkg.SeverityHigh); count != 1 {
+		t.Errorf("expected 1 high risk, got %d", count)
+	}
+	if count := countRisks(risks, pkg.SeverityMedium); count != 2 {
+		t.Errorf("expected 2 risks of medium severity or higher, got %d", count)
+	}
+
+	out.Reset()
+	writeRisks(out, nil)
+	if out.String() != "No risky permissions found in generated roles\n" {
+		t.Errorf("unexpected output: %q", out.String())
+	}
+}
+
+func TestRiskFindings(t *testing.T) {
+	objects := &pkg.RBACObjects{
+		Roles: []*rbacv1.Role{{
+			ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac:bob", Namespace: "ns1"},
+			Rules: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("get").Groups("").Resources("configmaps").RuleOrDie(),
+				rbacv1helper.NewRule("create").Groups("").Resources("pods").RuleOrDie(),
+			},
+		}},
+		ClusterRoles: []*rbacv1.ClusterRole{{
+			ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac:bob"},
+			Rules:      []rbacv1.PolicyRule{rbacv1helper.NewRule("list").Groups("").Resources("secrets").RuleOrDie()},
+		}},
+	}
+
+	out := &bytes.Buffer{}
+	files := writeObjects(out, objects, "generated.yaml")
+	findings := riskFindings(pkg.AnalyzeRisks(objects), files)
+
+	lines := strings.Split(out.String(), "\n")
+	expected := []struct {
+		location pkg.ObjectLocation
+		text     string
+	}{
+		{pkg.ObjectLocation{File: "generated.yaml", Line: 13, Kind: "Role", Namespace: "ns1", Name: "audit2rbac:bob", Rule: 1}, "- apiGroups:"},
+		{pkg.ObjectLocation{File: "generated.yaml", Line: 25, Kind: "ClusterRole", Name: "audit2rbac:bob", Rule: 0}, "- apiGroups:"},
+	}
+	if len(findings) != len(expected) {
+		t.Fatalf("expected %d findings, got %#v", len(expected), findings)
+	}
+	for i := range expected {
+		if diff := cmp.Diff(&expected[i].location, findings[i].Location); diff != "" {
+			t.Errorf("unexpected location:\n%s", diff)
+		}
+		if text := lines[findings[i].Location.Line-1]; text != expected[i].text {
+			t.Errorf("expected line %d to be %q, got %q", findings[i].Location.Line, expected[i].text, text)
+		}
+	}
+}
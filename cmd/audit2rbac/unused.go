@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/liggitt/audit2rbac/pkg"
+	"github.com/spf13/cobra"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	helpervalidation "k8s.io/component-helpers/auth/rbac/validation"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func newUnusedCommand(options *Audit2RBACOptions, complete func() error) *cobra.Command {
+	unused := &UnusedOptions{Audit2RBACOptions: options}
+
+	cmd := &cobra.Command{
+		Use:   "unused --filename=audit.log --rbac=roles.yaml [ --user=bob | --serviceaccount=my-namespace:my-sa ]",
+		Short: "List permissions granted to a user that no audit event used",
+		Run: func(cmd *cobra.Command, args []string) {
+			checkErr(options.Stderr, complete())
+
+			if err := unused.Validate(); err != nil {
+				fmt.Fprintln(options.Stderr, err)
+				fmt.Fprintln(options.Stderr)
+				cmd.Help()
+				os.Exit(1)
+			}
+
+			checkErr(options.Stderr, unused.Run())
+		},
+	}
+
+	cmd.Flags().StringArrayVar(&unused.RBACSources, "rbac", unused.RBACSources, "File or URL containing the roles and bindings granting permissions to the user")
+	addReportFlags(cmd, &unused.Reports)
+
+	return cmd
+}
+
+type UnusedOptions struct {
+	*Audit2RBACOptions
+
+	// RBACSources is a list of files or URLs containing roles and bindings.
+	// Format must be JSON or YAML RBAC objects or List.v1 objects.
+	RBACSources []string
+
+	Reports ReportOptions
+}
+
+func (u *UnusedOptions) Validate() error {
+	if err := u.Audit2RBACOptions.Validate(); err != nil {
+		return err
+	}
+	if len(u.RBACSources) == 0 {
+		return fmt.Errorf("--rbac is required")
+	}
+	// permissions used only by dropped requests would be reported as unused
+	if len(u.IgnoreSources) > 0 || len(u.NeverGrantSources) > 0 {
+		return fmt.Errorf("--ignore and --never-grant cannot be combined with unused")
+	}
+	return nil
+}
+
+func (u *UnusedOptions) Run() error {
+	objects, files, err := loadRBACObjectFiles(u.RBACSources)
+	if err != nil {
+		return err
+	}
+
+	events, err := u.loadEvents()
+	if err != nil {
+		return err
+	}
+
+	// the groups of the user are only known from the audit events
+	usages := pkg.PermissionUsages(&objects, events.requests.Requests()[0].Attributes.User, events.requests.Requests())
+	unused := writeUnused(u.Stdout, usages)
+	if err := u.Reports.Write("audit2rbac unused", unusedFindings(&objects, files, usages, u.User)); err != nil {
+		return err
+	}
+	fmt.Fprintf(u.Stderr, "%d of %d permissions granted to %s were not used by %d events\n", unused, len(usages), u.User, events.requests.Total())
+
+	if events.hasErrors {
+		return fmt.Errorf("Errors occurred reading audit events")
+	}
+	return nil
+}
+
+// writeUnused writes the permissions that allowed no requests, grouped by the role and namespace granting them,
+// and returns the number of unused permissions
+func writeUnused(w io.Writer, usages []pkg.PermissionUsage) int {
+	unused := 0
+	lastHeader := ""
+	for _, usage := range usages {
+		if usage.Requests > 0 {
+			continue
+		}
+		unused++
+
+		header := strings.ToLower(usage.RoleRef.Kind) + " " + usage.RoleRef.Name
+		switch {
+		case usage.RoleRef.Kind == "Role":
+			header = "role " + usage.Namespace + "/" + usage.RoleRef.Name
+		case len(usage.Namespace) > 0:
+			header += " in namespace " + usage.Namespace
+		default:
+			header += " cluster-wide"
+		}
+		if header != lastHeader {
+			fmt.Fprintf(w, "%s:\n", header)
+			lastHeader = header
+		}
+		fmt.Fprintf(w, "  %s\n", rbacv1helper.CompactString(usage.Permission))
+	}
+	return unused
+}
+
+// unusedFindings returns a warning finding for each permission that allowed no requests,
+// located at the first rule of the granting role that allows the permission
+func unusedFindings(objects *pkg.RBACObjects, files rbacObjectFiles, usages []pkg.PermissionUsage, user string) []pkg.Finding {
+	findings := []pkg.Finding{}
+	for _, usage := range usages {
+		if usage.Requests > 0 {
+			continue
+		}
+
+		var rules []rbacv1.PolicyRule
+		namespace := ""
+		switch usage.RoleRef.Kind {
+		case "ClusterRole":
+			for _, role := range objects.ClusterRoles {
+				if role.Name == usage.RoleRef.Name {
+					rules = role.Rules
+				}
+			}
+		case "Role":
+			namespace = usage.Namespace
+			for _, role := range objects.Roles {
+				if role.Namespace == usage.Namespace && role.Name == usage.RoleRef.Name {
+					rules = role.Rules
+				}
+			}
+		}
+		index := -1
+		for i := range rules {
+			if covers, _ := helpervalidation.Covers(rules[i:i+1], []rbacv1.PolicyRule{usage.Permission}); covers {
+				index = i
+				break
+			}
+		}
+
+		scope := "cluster-wide"
+		if len(usage.Namespace) > 0 {
+			scope = "in namespace " + usage.Namespace
+		}
+		permission := rbacv1helper.CompactString(usage.Permission)
+		findings = append(findings, pkg.Finding{
+			Rule:     "unused-permission",
+			Level:    "warning",
+			Name:     fmt.Sprintf("%s %s %s", files.location(usage.RoleRef.Kind, namespace, usage.RoleRef.Name, -1), scope, permission),
+			Message:  fmt.Sprintf("%s %s grants %s to %s %s, but it allowed no requests", strings.ToLower(usage.RoleRef.Kind), usage.RoleRef.Name, permission, user, scope),
+			Location: files.location(usage.RoleRef.Kind, namespace, usage.RoleRef.Name, index),
+		})
+	}
+	return findings
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/liggitt/audit2rbac/pkg"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func TestWriteUnused(t *testing.T) {
+	view := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"}
+	app := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "app"}
+	usages := []pkg.PermissionUsage{
+		{RoleRef: view, Permission: rbacv1helper.NewRule("get").Groups("").Resources("pods").RuleOrDie(), Requests: 3},
+		{RoleRef: view, Permission: rbacv1helper.NewRule("list").Groups("").Resources("pods").RuleOrDie()},
+		{Namespace: "ns1", RoleRef: view, Permission: rbacv1helper.NewRule("list").Groups("").Resources("pods").RuleOrDie()},
+		{Namespace: "ns1", RoleRef: app, Permission: rbacv1helper.NewRule("update").Groups("").Resources("configmaps").Names("a").RuleOrDie()},
+		{Namespace: "ns1", RoleRef: app, Permission: rbacv1helper.NewRule("update").Groups("").Resources("configmaps").Names("b").RuleOrDie()},
+	}
+
+	out := &bytes.Buffer{}
+	if unused := writeUnused(out, usages); unused != 4 {
+		t.Errorf("expected 4 unused permissions, got %d", unused)
+	}
+	expected := `clusterrole view cluster-wide:
+  {APIGroups:[""], Resources:["pods"], Verbs:["list"]}
+clusterrole view in namespace ns1:
+  {APIGroups:[""], Resources:["pods"], Verbs:["list"]}
+role ns1/app:
+  {APIGroups:[""], Resources:["configmaps"], ResourceNames:["a"], Verbs:["update"]}
+  {APIGroups:[""], Resources:["configmaps"], ResourceNames:["b"], Verbs:["update"]}
+`
+	if out.String() != expected {
+		t.Errorf("unexpected output:\n%s", cmp.Diff(expected, out.String()))
+	}
+}
+
+func TestUnusedFindings(t *testing.T) {
+	app := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "app"}
+	objects := &pkg.RBACObjects{
+		Roles: []*rbacv1.Role{{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "ns1"},
+			Rules: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("get").Groups("").Resources("pods").RuleOrDie(),
+				rbacv1helper.NewRule("update").Groups("").Resources("configmaps").Names("a", "b").RuleOrDie(),
+			},
+		}},
+	}
+	files := rbacObjectFiles{}
+	files[files.key("Role", "ns1", "app")] = objectSource{file: "rbac/app.yaml"}
+	usages := []pkg.PermissionUsage{
+		{Namespace: "ns1", RoleRef: app, Permission: rbacv1helper.NewRule("get").Groups("").Resources("pods").RuleOrDie(), Requests: 1},
+		{Namespace: "ns1", RoleRef: app, Permission: rbacv1helper.NewRule("update").Groups("").Resources("configmaps").Names("b").RuleOrDie()},
+	}
+
+	expected := []pkg.Finding{{
+		Rule:     "unused-permission",
+		Level:    "warning",
+		Name:     `Role/ns1/app in namespace ns1 {APIGroups:[""], Resources:["configmaps"], ResourceNames:["b"], Verbs:["update"]}`,
+		Message:  `role app grants {APIGroups:[""], Resources:["configmaps"], ResourceNames:["b"], Verbs:["update"]} to bob in namespace ns1, but it allowed no requests`,
+		Location: &pkg.ObjectLocation{File: "rbac/app.yaml", Kind: "Role", Namespace: "ns1", Name: "app", Rule: 1},
+	}}
+	if diff := cmp.Diff(expected, unusedFindings(objects, files, usages, "bob")); diff != "" {
+		t.Errorf("unexpected findings:\n%s", diff)
+	}
+}
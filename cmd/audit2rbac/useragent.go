@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/liggitt/audit2rbac/pkg"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+// userAgentLabel is set on objects generated with --split-by-user-agent to the user agent they were generated for
+const userAgentLabel = "audit2rbac.liggitt.net/user-agent"
+
+// unknownUserAgent is used for events without a user agent
+const unknownUserAgent = "unknown"
+
+// normalizeUserAgent returns the normalized user agent events are partitioned by
+func normalizeUserAgent(userAgent string) string {
+	if normalized := pkg.NormalizeUserAgent(userAgent); len(normalized) > 0 {
+		return normalized
+	}
+	return unknownUserAgent
+}
+
+// forUserAgent returns the events made by the normalized user agent, adding them to byUserAgent if needed
+func (e *auditEvents) forUserAgent(userAgent string) *auditEvents {
+	if e.byUserAgent == nil {
+		e.byUserAgent = map[string]*auditEvents{}
+	}
+	events, ok := e.byUserAgent[userAgent]
+	if !ok {
+		events = &auditEvents{userAgent: userAgent, requests: pkg.NewRequestAggregator(), grants: []pkg.Grant{}, neverGrant: e.neverGrant, digests: e.digests}
+		e.byUserAgent[userAgent] = events
+	}
+	return events
+}
+
+// partitions returns the events made by each user agent or user, sorted by user agent or user, if they were split.
+// Otherwise, it returns the events themselves.
+func (e *auditEvents) partitions() []*auditEvents {
+	split := e.byUserAgent
+	if e.byUser != nil {
+		split = e.byUser
+	}
+	if split == nil {
+		return []*auditEvents{e}
+	}
+	keys := make([]string, 0, len(split))
+	for key := range split {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	partitions := make([]*auditEvents, 0, len(keys))
+	for _, key := range keys {
+		partitions = append(partitions, split[key])
+	}
+	return partitions
+}
+
+// userAgentMetadata returns the name and labels for objects generated for the user agent
+func userAgentMetadata(name string, labels map[string]string, userAgent string) (string, map[string]string) {
+	nameSuffix := sanitizeName(userAgent)
+	labelValue := sanitizeLabel(userAgent)
+	if nameSuffix != userAgent || labelValue != userAgent {
+		nameSuffix += "-" + shortHash(userAgent)
+		labelValue += "-" + shortHash(userAgent)
+	}
+
+	userAgentLabels := map[string]string{}
+	for k, v := range labels {
+		userAgentLabels[k] = v
+	}
+	userAgentLabels[userAgentLabel] = truncateWithHash(labelValue, validation.LabelValueMaxLength)
+	return truncateWithHash(name+":"+nameSuffix, maxNameLength), userAgentLabels
+}
+
+// writeUserAgentMatrix writes a table of the rules generated for each user agent, marking the user agents whose generated objects allow each rule
+func writeUserAgentMatrix(w io.Writer, matrix *pkg.UserAgentMatrix) {
+	fmt.Fprintln(w, "Rules needed by each user agent:")
+	columns := make([]string, len(matrix.UserAgents))
+	for i, userAgent := range matrix.UserAgents {
+		columns[i] = strconv.Itoa(i + 1)
+		fmt.Fprintf(w, "  %s: %s\n", columns[i], userAgent)
+	}
+	fmt.Fprintf(w, "  %s\n", strings.Join(columns, " "))
+	for _, row := range matrix.Rows {
+		cells := make([]string, len(columns))
+		for i, column := range columns {
+			mark := "-"
+			if row.Needed[i] {
+				mark = "x"
+			}
+			cells[i] = mark + strings.Repeat(" ", len(column)-1)
+		}
+		scope := "cluster-wide"
+		if len(row.Namespace) > 0 {
+			scope = "in namespace " + row.Namespace
+		}
+		fmt.Fprintf(w, "  %s  %s %s\n", strings.Join(cells, " "), scope, rbacv1helper.CompactString(row.Rule))
+	}
+}
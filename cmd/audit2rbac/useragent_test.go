@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/liggitt/audit2rbac/pkg"
+
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func TestUserAgentMetadata(t *testing.T) {
+	labels := map[string]string{"audit2rbac.liggitt.net/user": "bob"}
+
+	name, agentLabels := userAgentMetadata("audit2rbac:bob", labels, "kube-controller-manager")
+	if name != "audit2rbac:bob:kube-controller-manager" {
+		t.Errorf("unexpected name %q", name)
+	}
+	expectedLabels := map[string]string{"audit2rbac.liggitt.net/user": "bob", userAgentLabel: "kube-controller-manager"}
+	if diff := cmp.Diff(expectedLabels, agentLabels); diff != "" {
+		t.Errorf("unexpected labels:\n%s", diff)
+	}
+	if len(labels) != 1 {
+		t.Errorf("expected labels to be unmodified, got %v", labels)
+	}
+
+	// user agents that are not valid in names or labels have a hash appended, so they do not collide
+	name, agentLabels = userAgentMetadata("audit2rbac:bob", labels, "Go-http-client")
+	if expected := "audit2rbac:bob:go-http-client-" + shortHash("Go-http-client"); name != expected {
+		t.Errorf("expected name %q, got %q", expected, name)
+	}
+	if expected := "go-http-client-" + shortHash("Go-http-client"); agentLabels[userAgentLabel] != expected {
+		t.Errorf("expected label %q, got %q", expected, agentLabels[userAgentLabel])
+	}
+}
+
+func TestPartitions(t *testing.T) {
+	events := &auditEvents{requests: pkg.NewRequestAggregator()}
+	if partitions := events.partitions(); len(partitions) != 1 || partitions[0] != events {
+		t.Errorf("expected unsplit events to be their only partition, got %v", partitions)
+	}
+
+	kubectl := events.forUserAgent(normalizeUserAgent("kubectl/v1.23.4 (linux/amd64) kubernetes/e6c093d"))
+	unknown := events.forUserAgent(normalizeUserAgent(""))
+	controller := events.forUserAgent(normalizeUserAgent("controller/v0.1.0"))
+	if events.forUserAgent("kubectl") != kubectl {
+		t.Errorf("expected the same events for the same user agent")
+	}
+	partitions := events.partitions()
+	if len(partitions) != 3 || partitions[0] != controller || partitions[1] != kubectl || partitions[2] != unknown {
+		t.Errorf("unexpected partitions: %v", partitions)
+	}
+	if unknown.userAgent != unknownUserAgent {
+		t.Errorf("expected user agent %q, got %q", unknownUserAgent, unknown.userAgent)
+	}
+}
+
+func TestGenerateSplitByUserAgent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit2rbac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	auditLog := &bytes.Buffer{}
+	for i, userAgent := range []string{"kubectl/v1.23.4", "controller/v0.1.0", "kubectl/v1.23.5"} {
+		fmt.Fprintf(auditLog, `{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"Metadata","auditID":"%d","stage":"ResponseComplete","requestURI":"/api/v1/namespaces/ns1/pods","verb":"list","user":{"username":"bob"},"userAgent":%q,"objectRef":{"resource":"pods","namespace":"ns1","apiVersion":"v1"}}`+"\n", i, userAgent)
+	}
+	auditFile := filepath.Join(dir, "audit.log")
+	if err := ioutil.WriteFile(auditFile, auditLog.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write([]byte(`{"kind":"APIResourceList","apiVersion":"v1","groupVersion":"v1","resources":[{"name":"pods","namespaced":true,"kind":"Pod","verbs":["get","list"]}]}`))
+	}))
+	defer server.Close()
+
+	options := &Audit2RBACOptions{
+		AuditSources:     []string{auditFile},
+		DiscoverySources: []string{server.URL},
+		User:             "bob",
+		Name:             "audit2rbac:bob",
+		SplitByUserAgent: true,
+		Stdout:           &bytes.Buffer{},
+		Stderr:           &bytes.Buffer{},
+	}
+	events, err := options.loadEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// split events are only held by their user agent
+	if events.requests.Len() != 0 {
+		t.Errorf("expected no unsplit requests, got %d", events.requests.Len())
+	}
+	if events.total() != 3 {
+		t.Errorf("expected 3 events, got %d", events.total())
+	}
+
+	sources, err := options.loadGenerateSources(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, partition := range events.partitions() {
+		_, generated, err := options.generate(partition, sources, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, role := range generated.Roles {
+			names = append(names, role.Name)
+		}
+	}
+	if diff := cmp.Diff([]string{"audit2rbac:bob:controller", "audit2rbac:bob:kubectl"}, names); diff != "" {
+		t.Errorf("unexpected roles:\n%s", diff)
+	}
+	if fetches != 1 {
+		t.Errorf("expected discovery to be fetched once, got %d", fetches)
+	}
+}
+
+func TestWriteUserAgentMatrix(t *testing.T) {
+	matrix := &pkg.UserAgentMatrix{
+		UserAgents: []string{"a1", "a2", "a3", "a4", "a5", "a6", "a7", "a8", "a9", "a10"},
+		Rows: []pkg.UserAgentMatrixRow{
+			{
+				Rule:   rbacv1helper.NewRule("get").Groups("").Resources("nodes").RuleOrDie(),
+				Needed: []bool{true, false, false, false, false, false, false, false, false, true},
+			},
+			{
+				Namespace: "ns1",
+				Rule:      rbacv1helper.NewRule("get", "list").Groups("").Resources("pods").RuleOrDie(),
+				Needed:    []bool{false, true, false, false, false, false, false, false, false, false},
+			},
+		},
+	}
+
+	out := &bytes.Buffer{}
+	writeUserAgentMatrix(out, matrix)
+	expected := `Rules needed by each user agent:
+  1: a1
+  2: a2
+  3: a3
+  4: a4
+  5: a5
+  6: a6
+  7: a7
+  8: a8
+  9: a9
+  10: a10
+  1 2 3 4 5 6 7 8 9 10
+  x - - - - - - - - x   cluster-wide {APIGroups:[""], Resources:["nodes"], Verbs:["get"]}
+  - x - - - - - - - -   in namespace ns1 {APIGroups:[""], Resources:["pods"], Verbs:["get" "list"]}
+`
+	if out.String() != expected {
+		t.Errorf("unexpected output:\n%s", cmp.Diff(expected, out.String()))
+	}
+}
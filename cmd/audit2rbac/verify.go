@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/liggitt/audit2rbac/pkg"
+	"github.com/spf13/cobra"
+)
+
+func newVerifyCommand(options *Audit2RBACOptions, complete func() error) *cobra.Command {
+	verify := &VerifyOptions{Audit2RBACOptions: options}
+
+	cmd := &cobra.Command{
+		Use:   "verify --filename=audit.log --rbac=dir/ [ --user=bob | --serviceaccount=my-namespace:my-sa ]",
+		Short: "Verify roles and bindings allow every request in audit events",
+		Long: "Verify roles and bindings allow every request in audit events.\n\n" +
+			"Requests the roles and bindings would deny are listed, and the command exits with a non-zero status if any request would be denied.",
+		Run: func(cmd *cobra.Command, args []string) {
+			checkErr(options.Stderr, complete())
+
+			if err := verify.Validate(); err != nil {
+				fmt.Fprintln(options.Stderr, err)
+				fmt.Fprintln(options.Stderr)
+				cmd.Help()
+				os.Exit(1)
+			}
+
+			checkErr(options.Stderr, verify.Run())
+		},
+	}
+
+	cmd.Flags().StringArrayVar(&verify.RBACSources, "rbac", verify.RBACSources, "File, directory, or URL containing the roles and bindings to verify. Denied requests are not caused by any one object, so their findings in --junit-report and --sarif-report are not located in these files")
+	addReportFlags(cmd, &verify.Reports)
+
+	return cmd
+}
+
+type VerifyOptions struct {
+	*Audit2RBACOptions
+
+	// RBACSources is a list of files, directories, or URLs containing roles and bindings.
+	// Format must be JSON or YAML RBAC objects or List.v1 objects.
+	RBACSources []string
+
+	Reports ReportOptions
+}
+
+func (v *VerifyOptions) Validate() error {
+	if err := v.Audit2RBACOptions.Validate(); err != nil {
+		return err
+	}
+	if len(v.RBACSources) == 0 {
+		return fmt.Errorf("--rbac is required")
+	}
+	// every request made by the user is authorized, so none can be dropped or limited before it is checked
+	if len(v.IgnoreSources) > 0 || len(v.NeverGrantSources) > 0 || len(v.CeilingSources) > 0 {
+		return fmt.Errorf("--ignore, --never-grant, and --ceiling cannot be combined with verify")
+	}
+	return nil
+}
+
+func (v *VerifyOptions) Run() error {
+	objects, err := loadRBACObjects(v.RBACSources)
+	if err != nil {
+		return err
+	}
+
+	events, err := v.loadEvents()
+	if err != nil {
+		return err
+	}
+
+	denied := pkg.DeniedRequests(&objects, events.requests.Requests())
+	deniedEvents := writeDenied(v.Stdout, denied)
+	if err := v.Reports.Write("audit2rbac verify", deniedFindings(denied, v.User)); err != nil {
+		return err
+	}
+
+	if events.hasErrors {
+		return fmt.Errorf("Errors occurred reading audit events")
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("%d of %d events by %s would be denied", deniedEvents, events.requests.Total(), v.User)
+	}
+	fmt.Fprintf(v.Stderr, "All %d events by %s would be allowed\n", events.requests.Total(), v.User)
+	return nil
+}
+
+// writeDenied writes the denied requests grouped by namespace, with cluster-wide requests first,
+// and returns the number of events denied
+func writeDenied(w io.Writer, denied []*pkg.AggregatedRequest) int {
+	denied = append([]*pkg.AggregatedRequest{}, denied...)
+	sort.SliceStable(denied, func(i, j int) bool {
+		return denied[i].Attributes.Namespace < denied[j].Attributes.Namespace
+	})
+
+	events := 0
+	lastHeader := ""
+	for _, request := range denied {
+		events += request.Count
+
+		header := "cluster-wide"
+		if len(request.Attributes.Namespace) > 0 {
+			header = "in namespace " + request.Attributes.Namespace
+		}
+		if header != lastHeader {
+			fmt.Fprintf(w, "denied %s:\n", header)
+			lastHeader = header
+		}
+		fmt.Fprintf(w, "  %s%s\n", describeRequest(request.Attributes), describeEvidence(request))
+	}
+	return events
+}
+
+// deniedFindings returns an error finding for each denied request
+func deniedFindings(denied []*pkg.AggregatedRequest, user string) []pkg.Finding {
+	findings := []pkg.Finding{}
+	for _, request := range denied {
+		description := describeRequest(request.Attributes)
+		findings = append(findings, pkg.Finding{
+			Rule:    "denied-request",
+			Level:   "error",
+			Name:    description,
+			Message: fmt.Sprintf("%s by %s would be denied%s", description, user, describeEvidence(request)),
+		})
+	}
+	return findings
+}
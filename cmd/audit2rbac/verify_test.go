@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/liggitt/audit2rbac/pkg"
+
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+func TestWriteDenied(t *testing.T) {
+	denied := []*pkg.AggregatedRequest{
+		{Attributes: authorizer.AttributesRecord{ResourceRequest: true, Verb: "list", Namespace: "ns2", Resource: "pods"}, Count: 2},
+		{Attributes: authorizer.AttributesRecord{ResourceRequest: true, Verb: "delete", Namespace: "ns1", Resource: "pods", Name: "p1"}, Count: 1, AuditIDs: []string{"a"}},
+		{Attributes: authorizer.AttributesRecord{Verb: "get", Path: "/healthz"}, Count: 3},
+		{Attributes: authorizer.AttributesRecord{ResourceRequest: true, Verb: "get", Namespace: "ns2", Resource: "deployments", APIGroup: "apps"}, Count: 1},
+	}
+
+	out := &bytes.Buffer{}
+	if events := writeDenied(out, denied); events != 7 {
+		t.Errorf("expected 7 denied events, got %d", events)
+	}
+	expected := `denied cluster-wide:
+  get /healthz (3 events)
+denied in namespace ns1:
+  delete pods p1 -n ns1 (1 event, audit IDs a)
+denied in namespace ns2:
+  list pods -n ns2 (2 events)
+  get deployments.apps -n ns2 (1 event)
+`
+	if out.String() != expected {
+		t.Errorf("unexpected output:\n%s", cmp.Diff(expected, out.String()))
+	}
+}
+
+func TestExpandDirectories(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit2rbac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"b.yaml", "a.json", "README.md", "sub/c.yml"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expanded, err := expandDirectories([]string{"-", dir, "https://example.com/roles.yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"-", filepath.Join(dir, "a.json"), filepath.Join(dir, "b.yaml"), filepath.Join(dir, "sub/c.yml"), "https://example.com/roles.yaml"}
+	if diff := cmp.Diff(expected, expanded); diff != "" {
+		t.Errorf("unexpected sources:\n%s", diff)
+	}
+}
@@ -0,0 +1,103 @@
+package pkg
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+// MaxSampleAuditIDs is the number of audit IDs kept as a sample of the events for each distinct request
+const MaxSampleAuditIDs = 5
+
+// AggregatedRequest holds a distinct request and how often it was observed
+type AggregatedRequest struct {
+	Attributes authorizer.AttributesRecord
+	Count      int
+	FirstSeen  time.Time
+	LastSeen   time.Time
+	// UserAgents holds the distinct user agents that made the request
+	UserAgents sets.String
+	// AuditIDs holds the audit IDs of the first events for the request, up to MaxSampleAuditIDs
+	AuditIDs []string
+}
+
+// AddSource records the user agent and audit ID of an event for the request. Empty values are ignored.
+func (a *AggregatedRequest) AddSource(userAgent, auditID string) {
+	if len(userAgent) > 0 {
+		if a.UserAgents == nil {
+			a.UserAgents = sets.NewString()
+		}
+		a.UserAgents.Insert(userAgent)
+	}
+	if len(auditID) > 0 && len(a.AuditIDs) < MaxSampleAuditIDs {
+		a.AuditIDs = append(a.AuditIDs, auditID)
+	}
+}
+
+// RequestAggregator deduplicates requests by the attributes relevant to authorization,
+// so memory use grows with the number of distinct requests rather than the number observed
+type RequestAggregator struct {
+	index    map[requestKey]*AggregatedRequest
+	requests []*AggregatedRequest
+	total    int
+}
+
+// NewRequestAggregator creates a new RequestAggregator
+func NewRequestAggregator() *RequestAggregator {
+	return &RequestAggregator{index: map[requestKey]*AggregatedRequest{}}
+}
+
+// Add records an observation of the request at the specified time, and returns the aggregated request.
+// A zero timestamp does not affect the first and last seen times.
+func (r *RequestAggregator) Add(attributes authorizer.AttributesRecord, timestamp time.Time) *AggregatedRequest {
+	r.total++
+
+	key := newRequestKey(attributes)
+	aggregated, ok := r.index[key]
+	if !ok {
+		aggregated = &AggregatedRequest{Attributes: attributes}
+		r.index[key] = aggregated
+		r.requests = append(r.requests, aggregated)
+	}
+
+	aggregated.Count++
+	if !timestamp.IsZero() {
+		if aggregated.FirstSeen.IsZero() || timestamp.Before(aggregated.FirstSeen) {
+			aggregated.FirstSeen = timestamp
+		}
+		if timestamp.After(aggregated.LastSeen) {
+			aggregated.LastSeen = timestamp
+		}
+	}
+	return aggregated
+}
+
+// Get returns the aggregated request with the same attributes relevant to authorization, or nil if none was added
+func (r *RequestAggregator) Get(attributes authorizer.AttributesRecord) *AggregatedRequest {
+	return r.index[newRequestKey(attributes)]
+}
+
+// Requests returns the distinct requests, in the order they were first added
+func (r *RequestAggregator) Requests() []*AggregatedRequest {
+	return r.requests
+}
+
+// Attributes returns the attributes of the distinct requests, in the order they were first added
+func (r *RequestAggregator) Attributes() []authorizer.AttributesRecord {
+	attributes := make([]authorizer.AttributesRecord, 0, len(r.requests))
+	for _, request := range r.requests {
+		attributes = append(attributes, request.Attributes)
+	}
+	return attributes
+}
+
+// Len returns the number of distinct requests
+func (r *RequestAggregator) Len() int {
+	return len(r.requests)
+}
+
+// Total returns the number of requests added
+func (r *RequestAggregator) Total() int {
+	return r.total
+}
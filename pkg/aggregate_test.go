@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+func TestRequestAggregator(t *testing.T) {
+	aggregator := NewRequestAggregator()
+
+	t1 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	t3 := t2.Add(time.Hour)
+
+	// each event produces a distinct user.Info, identical requests must still aggregate
+	getPod := func() authorizer.AttributesRecord {
+		return authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "bob"}, ResourceRequest: true, Verb: "get", Namespace: "ns1", Resource: "pods", Name: "pod1"}
+	}
+	aggregator.Add(getPod(), t2).AddSource("kubectl", "1")
+	aggregator.Add(authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "bob"}, Verb: "get", Path: "/healthz"}, t1)
+	aggregator.Add(getPod(), t3).AddSource("kubectl", "2")
+	aggregator.Add(getPod(), t1).AddSource("controller", "3")
+	aggregator.Add(getPod(), time.Time{}).AddSource("", "")
+
+	if aggregator.Total() != 5 {
+		t.Errorf("expected 5 total requests, got %d", aggregator.Total())
+	}
+	if aggregator.Len() != 2 {
+		t.Fatalf("expected 2 distinct requests, got %d", aggregator.Len())
+	}
+
+	requests := aggregator.Requests()
+	if requests[0].Attributes.Resource != "pods" || requests[1].Attributes.Path != "/healthz" {
+		t.Errorf("expected requests in the order they were first added, got %#v", aggregator.Attributes())
+	}
+	if aggregator.Get(getPod()) != requests[0] || aggregator.Get(authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "bob"}, Verb: "get", Path: "/livez"}) != nil {
+		t.Errorf("unexpected result from Get")
+	}
+	if requests[0].Count != 4 {
+		t.Errorf("expected count of 4, got %d", requests[0].Count)
+	}
+	if !requests[0].FirstSeen.Equal(t1) || !requests[0].LastSeen.Equal(t3) {
+		t.Errorf("expected first seen %v and last seen %v, got %v and %v", t1, t3, requests[0].FirstSeen, requests[0].LastSeen)
+	}
+	if !reflect.DeepEqual(requests[0].UserAgents.List(), []string{"controller", "kubectl"}) {
+		t.Errorf("unexpected user agents: %v", requests[0].UserAgents.List())
+	}
+	if !reflect.DeepEqual(requests[0].AuditIDs, []string{"1", "2", "3"}) {
+		t.Errorf("unexpected audit IDs: %v", requests[0].AuditIDs)
+	}
+
+	for i := 0; i < 10; i++ {
+		requests[1].AddSource("", fmt.Sprint(i))
+	}
+	if len(requests[1].AuditIDs) != MaxSampleAuditIDs || requests[1].UserAgents.Len() != 0 {
+		t.Errorf("expected %d audit IDs and no user agents, got %v and %v", MaxSampleAuditIDs, requests[1].AuditIDs, requests[1].UserAgents.List())
+	}
+}
+
+func TestRequestAggregatorAPIVersions(t *testing.T) {
+	aggregator := NewRequestAggregator()
+	bob := &user.DefaultInfo{Name: "bob"}
+	aggregator.Add(authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "apps", APIVersion: "v1", Resource: "deployments", Name: "d1", Path: "/apis/apps/v1/namespaces/ns1/deployments/d1"}, time.Time{})
+	aggregator.Add(authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "apps", APIVersion: "v1beta2", Resource: "deployments", Name: "d1", Path: "/apis/apps/v1beta2/namespaces/ns1/deployments/d1"}, time.Time{})
+	// non-resource requests are still distinguished by path
+	aggregator.Add(authorizer.AttributesRecord{User: bob, Verb: "get", Path: "/apis/apps/v1"}, time.Time{})
+	aggregator.Add(authorizer.AttributesRecord{User: bob, Verb: "get", Path: "/apis/apps/v1beta2"}, time.Time{})
+
+	if aggregator.Len() != 3 {
+		t.Fatalf("expected 3 distinct requests, got %d: %#v", aggregator.Len(), aggregator.Attributes())
+	}
+	if requests := aggregator.Requests(); requests[0].Count != 2 || requests[0].Attributes.APIVersion != "v1" {
+		t.Errorf("expected both versions of the deployment request to aggregate into the first, got %#v", requests[0])
+	}
+}
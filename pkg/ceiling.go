@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	helpervalidation "k8s.io/component-helpers/auth/rbac/validation"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+	rbacauthorizer "k8s.io/kubernetes/plugin/pkg/auth/authorizer/rbac"
+)
+
+// Ceiling is the maximum set of permissions a generator may grant.
+// Rules of cluster roles are allowed cluster-wide and in every namespace, and rules of roles in the namespace of the role.
+type Ceiling struct {
+	rules map[string][]rbacv1.PolicyRule
+}
+
+// NewCeiling returns a ceiling allowing the rules of the roles and cluster roles in objects. Bindings are ignored.
+func NewCeiling(objects *RBACObjects) *Ceiling {
+	c := &Ceiling{rules: map[string][]rbacv1.PolicyRule{}}
+	for _, role := range objects.ClusterRoles {
+		c.rules[""] = append(c.rules[""], role.Rules...)
+	}
+	for _, role := range objects.Roles {
+		c.rules[role.Namespace] = append(c.rules[role.Namespace], role.Rules...)
+	}
+	return c
+}
+
+// rulesFor returns the rules the ceiling allows in the namespace, or cluster-wide if namespace is empty
+func (c *Ceiling) rulesFor(namespace string) []rbacv1.PolicyRule {
+	if namespace == "" {
+		return c.rules[""]
+	}
+	return append(append([]rbacv1.PolicyRule{}, c.rules[""]...), c.rules[namespace]...)
+}
+
+// AllowsRequest returns true if the ceiling allows the request in its namespace
+func (c *Ceiling) AllowsRequest(request authorizer.AttributesRecord) bool {
+	return rbacauthorizer.RulesAllow(request, c.rulesFor(request.Namespace)...)
+}
+
+// Allows returns true if the ceiling allows the rules in the namespace, or cluster-wide if namespace is empty
+func (c *Ceiling) Allows(namespace string, rules ...rbacv1.PolicyRule) bool {
+	covered, _ := helpervalidation.Covers(c.rulesFor(namespace), rules)
+	return covered
+}
+
+// Clamp returns the parts of the rules the ceiling allows in the namespace, and the parts it does not allow.
+// Rules the ceiling allows completely are returned unchanged, others are broken down into individual permissions.
+func (c *Ceiling) Clamp(namespace string, rules []rbacv1.PolicyRule) (allowed, disallowed []rbacv1.PolicyRule) {
+	for _, rule := range rules {
+		if c.Allows(namespace, rule) {
+			allowed = append(allowed, rule)
+			continue
+		}
+		for _, permission := range helpervalidation.BreakdownRule(rule) {
+			if c.Allows(namespace, permission) {
+				allowed = append(allowed, permission)
+			} else {
+				disallowed = append(disallowed, permission)
+			}
+		}
+	}
+	return allowed, disallowed
+}
+
+// ceilingAllowsRequest returns true if there is no ceiling, or the ceiling allows the request
+func (g *Generator) ceilingAllowsRequest(request authorizer.AttributesRecord) bool {
+	return g.Ceiling == nil || g.Ceiling.AllowsRequest(request)
+}
+
+// ceilingAllows returns true if there is no ceiling, or the ceiling allows the rules in the namespace
+func (g *Generator) ceilingAllows(namespace string, rules ...rbacv1.PolicyRule) bool {
+	return g.Ceiling == nil || g.Ceiling.Allows(namespace, rules...)
+}
+
+// clampToCeiling returns the parts of the rules the ceiling allows in the namespace, and describes the parts it does not allow
+func (g *Generator) clampToCeiling(namespace string, rules []rbacv1.PolicyRule) ([]rbacv1.PolicyRule, string) {
+	if g.Ceiling == nil {
+		return rules, ""
+	}
+	allowed, disallowed := g.Ceiling.Clamp(namespace, rules)
+	if len(disallowed) == 0 {
+		return allowed, ""
+	}
+	descriptions := []string{}
+	for _, rule := range compactRules(disallowed) {
+		descriptions = append(descriptions, rbacv1helper.CompactString(rule))
+	}
+	return allowed, strings.Join(descriptions, "; ")
+}
+
+// warnRulesAboveCeiling warns about generated rules the ceiling does not allow, which can only be kept from previous objects
+func (g *Generator) warnRulesAboveCeiling() {
+	if g.Ceiling == nil {
+		return
+	}
+	for _, role := range g.generated.ClusterRoles {
+		if _, disallowed := g.clampToCeiling("", role.Rules); len(disallowed) > 0 {
+			g.warn(fmt.Sprintf("clusterrole %s kept rules from previous objects that the ceiling does not allow: %s", role.Name, disallowed))
+		}
+	}
+	for _, role := range g.generated.Roles {
+		if _, disallowed := g.clampToCeiling(role.Namespace, role.Rules); len(disallowed) > 0 {
+			g.warn(fmt.Sprintf("role %s/%s kept rules from previous objects that the ceiling does not allow: %s", role.Namespace, role.Name, disallowed))
+		}
+	}
+}
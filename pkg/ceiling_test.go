@@ -0,0 +1,81 @@
+package pkg
+
+import (
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func TestGenerateCeiling(t *testing.T) {
+	bob := &user.DefaultInfo{Name: "bob"}
+	ceiling := NewCeiling(&RBACObjects{
+		ClusterRoles: []*rbacv1.ClusterRole{{
+			ObjectMeta: metav1.ObjectMeta{Name: "ceiling"},
+			Rules: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("get", "list", "watch").Groups("").Resources("pods").RuleOrDie(),
+				rbacv1helper.NewRule("get").Groups("").Resources("configmaps").Names("c1", "c2").RuleOrDie(),
+			},
+		}},
+		Roles: []*rbacv1.Role{{
+			ObjectMeta: metav1.ObjectMeta{Name: "ceiling", Namespace: "ns1"},
+			Rules:      []rbacv1.PolicyRule{rbacv1helper.NewRule("update").Groups("").Resources("configmaps").RuleOrDie()},
+		}},
+	})
+	requests := []authorizer.AttributesRecord{
+		{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", Resource: "pods", Name: "p1"},
+		{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns2", Resource: "pods", Name: "p2"},
+		// the ceiling allows any namespace, but not any name
+		{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", Resource: "configmaps", Name: "c1"},
+		{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns2", Resource: "configmaps", Name: "c2"},
+		// the ceiling allows update, but not the verbs it usually expands to
+		{User: bob, ResourceRequest: true, Verb: "update", Namespace: "ns1", Resource: "configmaps", Name: "c3"},
+		{User: bob, ResourceRequest: true, Verb: "delete", Namespace: "ns1", Resource: "pods", Name: "p1"},
+		{User: bob, Verb: "get", Path: "/healthz"},
+	}
+
+	generator := NewGenerator(RBACObjects{}, requests, DefaultGenerateOptions())
+	generator.Ceiling = ceiling
+	generated := generator.Generate()
+
+	expectedClusterRoles := []*rbacv1.ClusterRole{{
+		ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac"},
+		Rules: []rbacv1.PolicyRule{
+			rbacv1helper.NewRule("get").Groups("").Resources("configmaps").Names("c1", "c2").RuleOrDie(),
+			rbacv1helper.NewRule("get").Groups("").Resources("pods").RuleOrDie(),
+		},
+	}}
+	if !equality.Semantic.DeepEqual(expectedClusterRoles, generated.ClusterRoles) {
+		t.Errorf("unexpected cluster roles\n%s", diff.ObjectGoPrintSideBySide(expectedClusterRoles, generated.ClusterRoles))
+	}
+	expectedRoles := []*rbacv1.Role{{
+		ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac", Namespace: "ns1"},
+		Rules:      []rbacv1.PolicyRule{rbacv1helper.NewRule("update").Groups("").Resources("configmaps").Names("c3").RuleOrDie()},
+	}}
+	if !equality.Semantic.DeepEqual(expectedRoles, generated.Roles) {
+		t.Errorf("unexpected roles\n%s", diff.ObjectGoPrintSideBySide(expectedRoles, generated.Roles))
+	}
+
+	expectedExceeded := []authorizer.AttributesRecord{
+		{User: bob, Verb: "get", Path: "/healthz"},
+		{User: bob, ResourceRequest: true, Verb: "delete", Namespace: "ns1", Resource: "pods", Name: "p1"},
+	}
+	if exceeded := generator.Exceeded(); !equality.Semantic.DeepEqual(expectedExceeded, exceeded) {
+		t.Errorf("unexpected exceeded requests\n%s", diff.ObjectGoPrintSideBySide(expectedExceeded, exceeded))
+	}
+	for _, role := range generated.ClusterRoles {
+		if !ceiling.Allows("", role.Rules...) {
+			t.Errorf("cluster role %s exceeds the ceiling", role.Name)
+		}
+	}
+	for _, role := range generated.Roles {
+		if !ceiling.Allows(role.Namespace, role.Rules...) {
+			t.Errorf("role %s/%s exceeds the ceiling", role.Namespace, role.Name)
+		}
+	}
+}
@@ -0,0 +1,518 @@
+package pkg
+
+import (
+	"math/bits"
+	"sort"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	helpervalidation "k8s.io/component-helpers/auth/rbac/validation"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+// compactRules returns the smallest list of rules that allows exactly what the given rules allow.
+//
+// Rules are broken down into individual permissions, and permissions covered by broader permissions are dropped.
+// Every maximal rule allowing only permissions the given rules allow is found, and the fewest of them allowing all
+// remaining permissions are chosen. If there are more than maxExactPermissions permissions, or the search exceeds maxCoverSearch
+// steps, the permissions are instead merged greedily along one dimension (API group, resource, resource name, or verb)
+// at a time, which is not guaranteed to produce the fewest rules.
+// The result does not depend on the order of the input rules.
+func compactRules(rules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	resourcePermissions := map[ruleDimensions]bool{}
+	nonResourcePermissions := map[ruleDimensions]bool{}
+	for _, rule := range rules {
+		for _, permission := range helpervalidation.BreakdownRule(rule) {
+			if len(permission.NonResourceURLs) > 0 {
+				nonResourcePermissions[ruleDimensions{permission.NonResourceURLs[0], permission.Verbs[0]}] = true
+				continue
+			}
+			name := ""
+			if len(permission.ResourceNames) > 0 {
+				name = permission.ResourceNames[0]
+			}
+			resourcePermissions[ruleDimensions{permission.APIGroups[0], permission.Resources[0], name, permission.Verbs[0]}] = true
+		}
+	}
+
+	compacted := []rbacv1.PolicyRule{}
+	for _, rect := range minimalRects(dropCoveredResourcePermissions(resourcePermissions), resourcePermissions, resourceDimensions) {
+		rule := rbacv1.PolicyRule{APIGroups: rect[0].List(), Resources: rect[1].List(), Verbs: rect[3].List()}
+		if !rect[2].Has("") {
+			rule.ResourceNames = rect[2].List()
+		}
+		compacted = append(compacted, rule)
+	}
+	for _, rect := range minimalRects(dropCoveredNonResourcePermissions(nonResourcePermissions), nonResourcePermissions, nonResourceDimensions) {
+		compacted = append(compacted, rbacv1.PolicyRule{NonResourceURLs: rect[0].List(), Verbs: rect[1].List()})
+	}
+
+	sort.SliceStable(compacted, func(i, j int) bool {
+		// TODO: fix upstream sorting to prioritize API group
+		if c := strings.Compare(strings.Join(compacted[i].APIGroups, ","), strings.Join(compacted[j].APIGroups, ",")); c != 0 {
+			return c < 0
+		}
+		return strings.Compare(rbacv1helper.CompactString(compacted[i]), rbacv1helper.CompactString(compacted[j])) < 0
+	})
+	return compacted
+}
+
+// ruleDimensions holds the values of a single permission.
+// Resource permissions are group, resource, name ("" for any name), verb.
+// Non-resource permissions are URL, verb.
+type ruleDimensions [4]string
+
+// rect is a rule expressed as a set of values for each dimension, allowing the cross-product of the sets
+type rect []sets.String
+
+const (
+	resourceDimensions    = 4
+	nonResourceDimensions = 2
+	nameDimension         = 2
+)
+
+// dropCoveredResourcePermissions returns the permissions that are not covered by another, broader permission
+func dropCoveredResourcePermissions(permissions map[ruleDimensions]bool) []ruleDimensions {
+	wildcards := []rbacv1.PolicyRule{}
+	for p := range permissions {
+		if p[0] == rbacv1.APIGroupAll || p[1] == rbacv1.ResourceAll || strings.HasPrefix(p[1], "*/") || p[3] == rbacv1.VerbAll {
+			wildcards = append(wildcards, permissionRule(p))
+		}
+	}
+
+	remaining := []ruleDimensions{}
+	for p := range permissions {
+		if p[2] != "" && permissions[ruleDimensions{p[0], p[1], "", p[3]}] {
+			continue
+		}
+		covered := false
+		rule := permissionRule(p)
+		for _, wildcard := range wildcards {
+			if equalRules(wildcard, rule) {
+				continue
+			}
+			if covers, _ := helpervalidation.Covers([]rbacv1.PolicyRule{wildcard}, []rbacv1.PolicyRule{rule}); covers {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			remaining = append(remaining, p)
+		}
+	}
+	return remaining
+}
+
+// dropCoveredNonResourcePermissions returns the permissions that are not covered by another, broader permission
+func dropCoveredNonResourcePermissions(permissions map[ruleDimensions]bool) []ruleDimensions {
+	wildcards := []ruleDimensions{}
+	for p := range permissions {
+		if strings.HasSuffix(p[0], "*") || p[1] == rbacv1.VerbAll {
+			wildcards = append(wildcards, p)
+		}
+	}
+
+	remaining := []ruleDimensions{}
+	for p := range permissions {
+		covered := false
+		for _, wildcard := range wildcards {
+			if wildcard == p {
+				continue
+			}
+			rule := &rbacv1.PolicyRule{NonResourceURLs: []string{wildcard[0]}, Verbs: []string{wildcard[1]}}
+			if rbacv1helper.NonResourceURLMatches(rule, p[0]) && rbacv1helper.VerbMatches(rule, p[1]) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			remaining = append(remaining, p)
+		}
+	}
+	return remaining
+}
+
+func permissionRule(p ruleDimensions) rbacv1.PolicyRule {
+	rule := rbacv1.PolicyRule{APIGroups: []string{p[0]}, Resources: []string{p[1]}, Verbs: []string{p[3]}}
+	if p[2] != "" {
+		rule.ResourceNames = []string{p[2]}
+	}
+	return rule
+}
+
+func equalRules(a, b rbacv1.PolicyRule) bool {
+	return rbacv1helper.CompactString(a) == rbacv1helper.CompactString(b)
+}
+
+const (
+	// maxExactPermissions is the most permissions minimalRects searches the smallest list of rects for
+	maxExactPermissions = 64
+	// maxCoverSearch is the most partial lists of rects minimalRects searches
+	maxCoverSearch = 100000
+)
+
+// minimalRects returns the fewest rects allowing all of the given permissions and nothing outside allowed.
+// Rects may include allowed permissions that are already covered, if that lets them cover more of the given permissions.
+// If there are too many permissions to search, it returns the rects found by mergeRects.
+func minimalRects(permissions []ruleDimensions, allowed map[ruleDimensions]bool, dimensions int) []rect {
+	greedy := mergeRects(permissions, dimensions)
+	if len(permissions) > maxExactPermissions || len(greedy) <= 1 {
+		return greedy
+	}
+
+	sorted := append([]ruleDimensions{}, permissions...)
+	sort.Slice(sorted, func(i, j int) bool { return lessDimensions(sorted[i], sorted[j]) })
+
+	var candidates []rect
+	if dimensions == resourceDimensions {
+		// a rule cannot combine specific names with any name, so permissions for any name are merged separately
+		anyName, named := []ruleDimensions{}, []ruleDimensions{}
+		for _, p := range sorted {
+			if p[nameDimension] == "" {
+				anyName = append(anyName, p)
+			} else {
+				named = append(named, p)
+			}
+		}
+		// permissions for any name allow the same permission for each specific name
+		namedAllowed := map[ruleDimensions]bool{}
+		for p := range allowed {
+			if p[nameDimension] != "" {
+				namedAllowed[p] = true
+				continue
+			}
+			for _, n := range named {
+				p[nameDimension] = n[nameDimension]
+				namedAllowed[p] = true
+			}
+		}
+		anyNameOptions, namedOptions := allowedWithin(anyName, allowed), allowedWithin(named, namedAllowed)
+		if len(anyNameOptions)+len(namedOptions) > 2*maxExactPermissions {
+			return greedy
+		}
+		for _, r := range maximalRects(anyNameOptions, []int{0, 1, 3}, dimensions) {
+			r[nameDimension] = sets.NewString("")
+			candidates = append(candidates, r)
+		}
+		candidates = append(candidates, maximalRects(namedOptions, []int{0, 1, 2, 3}, dimensions)...)
+	} else {
+		options := allowedWithin(sorted, allowed)
+		if len(options) > 2*maxExactPermissions {
+			return greedy
+		}
+		dims := make([]int, dimensions)
+		for d := range dims {
+			dims[d] = d
+		}
+		candidates = maximalRects(options, dims, dimensions)
+	}
+
+	if cover := minimumCover(sorted, candidates, len(greedy)); cover != nil {
+		return cover
+	}
+	return greedy
+}
+
+// allowedWithin returns the sorted allowed permissions whose value in each dimension is used by one of the permissions
+func allowedWithin(permissions []ruleDimensions, allowed map[ruleDimensions]bool) []ruleDimensions {
+	values := make([]sets.String, len(ruleDimensions{}))
+	for d := range values {
+		values[d] = sets.NewString()
+		for _, p := range permissions {
+			values[d].Insert(p[d])
+		}
+	}
+	within := []ruleDimensions{}
+	for p := range allowed {
+		if rectHas(values, p) {
+			within = append(within, p)
+		}
+	}
+	sort.Slice(within, func(i, j int) bool { return lessDimensions(within[i], within[j]) })
+	return within
+}
+
+// maximalRects returns every rect allowing only the given permissions that cannot be extended along any of dims.
+// The permissions must all have the same values in the other dimensions, which are not set in the returned rects.
+func maximalRects(permissions []ruleDimensions, dims []int, dimensions int) []rect {
+	if len(permissions) == 0 {
+		return nil
+	}
+
+	// enumerate subsets of the values of the dimension with the fewest values
+	d, values := -1, []string(nil)
+	for _, dim := range dims {
+		dimValues := sets.NewString()
+		for _, p := range permissions {
+			dimValues.Insert(p[dim])
+		}
+		if d == -1 || dimValues.Len() < len(values) {
+			d, values = dim, dimValues.List()
+		}
+	}
+	if len(dims) == 1 {
+		r := make(rect, dimensions)
+		r[d] = sets.NewString(values...)
+		return []rect{r}
+	}
+	rest := []int{}
+	for _, dim := range dims {
+		if dim != d {
+			rest = append(rest, dim)
+		}
+	}
+
+	// slices holds the permissions with each value of d, without their value of d
+	slices := map[string]map[ruleDimensions]bool{}
+	for _, p := range permissions {
+		value := p[d]
+		p[d] = ""
+		if slices[value] == nil {
+			slices[value] = map[ruleDimensions]bool{}
+		}
+		slices[value][p] = true
+	}
+
+	results := []rect{}
+	var visit func(start int, chosen []string, intersection map[ruleDimensions]bool)
+	visit = func(start int, chosen []string, intersection map[ruleDimensions]bool) {
+		for i := start; i < len(values); i++ {
+			next := map[ruleDimensions]bool{}
+			for p := range slices[values[i]] {
+				if intersection == nil || intersection[p] {
+					next[p] = true
+				}
+			}
+			if len(next) == 0 {
+				continue
+			}
+			subset := append(append([]string{}, chosen...), values[i])
+
+			nextPermissions := make([]ruleDimensions, 0, len(next))
+			for p := range next {
+				nextPermissions = append(nextPermissions, p)
+			}
+			sort.Slice(nextPermissions, func(i, j int) bool { return lessDimensions(nextPermissions[i], nextPermissions[j]) })
+			for _, r := range maximalRects(nextPermissions, rest, dimensions) {
+				// the rect is only maximal if no other value of d allows all of its permissions
+				maximal := true
+				for _, value := range values {
+					if !containsString(subset, value) && rectWithin(r, rest, slices[value]) {
+						maximal = false
+						break
+					}
+				}
+				if maximal {
+					r[d] = sets.NewString(subset...)
+					results = append(results, r)
+				}
+			}
+
+			visit(i+1, subset, next)
+		}
+	}
+	visit(0, nil, nil)
+	return results
+}
+
+// rectWithin returns true if every permission the rect allows in dims is in permissions.
+// Permissions have empty values in the other dimensions.
+func rectWithin(r rect, dims []int, permissions map[ruleDimensions]bool) bool {
+	var within func(i int, p ruleDimensions) bool
+	within = func(i int, p ruleDimensions) bool {
+		if i == len(dims) {
+			return permissions[p]
+		}
+		for _, value := range r[dims[i]].UnsortedList() {
+			p[dims[i]] = value
+			if !within(i+1, p) {
+				return false
+			}
+		}
+		return true
+	}
+	return within(0, ruleDimensions{})
+}
+
+// minimumCover returns the fewest candidate rects allowing every permission, if fewer than limit are needed
+// and they are found within maxCoverSearch steps. Otherwise, it returns nil.
+func minimumCover(permissions []ruleDimensions, candidates []rect, limit int) []rect {
+	masks := make([]uint64, len(candidates))
+	largest := 0
+	for i, c := range candidates {
+		for j, p := range permissions {
+			if rectHas(c, p) {
+				masks[i] |= 1 << uint(j)
+			}
+		}
+		if size := bits.OnesCount64(masks[i]); size > largest {
+			largest = size
+		}
+	}
+	all := ^uint64(0) >> uint(64-len(permissions))
+
+	var best []int
+	steps := 0
+	var search func(allowed uint64, chosen []int)
+	search = func(allowed uint64, chosen []int) {
+		if allowed == all {
+			if len(chosen) < limit {
+				limit = len(chosen)
+				best = append([]int{}, chosen...)
+			}
+			return
+		}
+		steps++
+		remaining := bits.OnesCount64(all &^ allowed)
+		if steps > maxCoverSearch || len(chosen)+(remaining+largest-1)/largest >= limit {
+			return
+		}
+
+		// every cover includes a rect allowing the permission allowed by the fewest candidates
+		permission, options := -1, []int(nil)
+		for j := range permissions {
+			if allowed&(1<<uint(j)) != 0 {
+				continue
+			}
+			jOptions := []int{}
+			for i, mask := range masks {
+				if mask&(1<<uint(j)) != 0 {
+					jOptions = append(jOptions, i)
+				}
+			}
+			if permission == -1 || len(jOptions) < len(options) {
+				permission, options = j, jOptions
+			}
+		}
+		// try the rects allowing the most permissions not yet allowed first
+		sort.SliceStable(options, func(a, b int) bool {
+			return bits.OnesCount64(masks[options[a]]&^allowed) > bits.OnesCount64(masks[options[b]]&^allowed)
+		})
+		for _, i := range options {
+			search(allowed|masks[i], append(chosen, i))
+		}
+	}
+	search(0, nil)
+
+	if best == nil {
+		return nil
+	}
+	cover := make([]rect, 0, len(best))
+	for _, i := range best {
+		cover = append(cover, candidates[i])
+	}
+	return cover
+}
+
+func rectHas(r rect, p ruleDimensions) bool {
+	for d := range r {
+		if !r[d].Has(p[d]) {
+			return false
+		}
+	}
+	return true
+}
+
+func lessDimensions(a, b ruleDimensions) bool {
+	for d := range a {
+		if a[d] != b[d] {
+			return a[d] < b[d]
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeRects merges the given permissions into as few rects as it can find,
+// trying every order of merging the dimensions
+func mergeRects(permissions []ruleDimensions, dimensions int) []rect {
+	if len(permissions) == 0 {
+		return nil
+	}
+
+	var best []rect
+	for _, order := range permutations(dimensions) {
+		rects := make([]rect, 0, len(permissions))
+		for _, p := range permissions {
+			r := make(rect, dimensions)
+			for d := 0; d < dimensions; d++ {
+				r[d] = sets.NewString(p[d])
+			}
+			rects = append(rects, r)
+		}
+
+		for changed := true; changed; {
+			changed = false
+			for _, d := range order {
+				merged := mergeRectsAlong(rects, d, dimensions)
+				if len(merged) < len(rects) {
+					changed = true
+				}
+				rects = merged
+			}
+		}
+
+		if best == nil || len(rects) < len(best) {
+			best = rects
+		}
+	}
+	return best
+}
+
+// mergeRectsAlong combines rects that are identical in every dimension other than d
+func mergeRectsAlong(rects []rect, d, dimensions int) []rect {
+	merged := []rect{}
+	index := map[string]int{}
+	for _, r := range rects {
+		// a rule cannot combine specific names with any name
+		if d == nameDimension && dimensions == resourceDimensions && r[d].Has("") {
+			merged = append(merged, r)
+			continue
+		}
+
+		keyParts := make([]string, 0, dimensions)
+		for other := 0; other < dimensions; other++ {
+			if other != d {
+				keyParts = append(keyParts, strings.Join(r[other].List(), "\x00"))
+			}
+		}
+		key := strings.Join(keyParts, "\x01")
+
+		if i, ok := index[key]; ok {
+			merged[i][d] = merged[i][d].Union(r[d])
+			continue
+		}
+		index[key] = len(merged)
+		copied := make(rect, dimensions)
+		copy(copied, r)
+		merged = append(merged, copied)
+	}
+	return merged
+}
+
+// permutations returns every ordering of the integers [0,n)
+func permutations(n int) [][]int {
+	if n == 0 {
+		return [][]int{{}}
+	}
+	result := [][]int{}
+	for _, p := range permutations(n - 1) {
+		for i := 0; i <= len(p); i++ {
+			order := make([]int, 0, n)
+			order = append(order, p[:i]...)
+			order = append(order, n-1)
+			order = append(order, p[i:]...)
+			result = append(result, order)
+		}
+	}
+	return result
+}
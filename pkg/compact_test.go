@@ -0,0 +1,278 @@
+package pkg
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+	rbacauthorizer "k8s.io/kubernetes/plugin/pkg/auth/authorizer/rbac"
+)
+
+func TestCompactRules(t *testing.T) {
+	testcases := []struct {
+		name     string
+		rules    []rbacv1.PolicyRule
+		expected []rbacv1.PolicyRule
+	}{
+		{
+			name:     "empty",
+			rules:    []rbacv1.PolicyRule{},
+			expected: []rbacv1.PolicyRule{},
+		},
+		{
+			name: "names and resources",
+			rules: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("get").Groups("").Resources("configmaps").Names("a").RuleOrDie(),
+				rbacv1helper.NewRule("get").Groups("").Resources("secrets").Names("b").RuleOrDie(),
+				rbacv1helper.NewRule("get").Groups("").Resources("configmaps").Names("b").RuleOrDie(),
+				rbacv1helper.NewRule("get").Groups("").Resources("secrets").Names("a").RuleOrDie(),
+			},
+			expected: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("get").Groups("").Resources("configmaps", "secrets").Names("a", "b").RuleOrDie(),
+			},
+		},
+		{
+			name: "groups",
+			rules: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("get", "list").Groups("apps").Resources("deployments").RuleOrDie(),
+				rbacv1helper.NewRule("list", "get").Groups("extensions").Resources("deployments").RuleOrDie(),
+			},
+			expected: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("get", "list").Groups("apps", "extensions").Resources("deployments").RuleOrDie(),
+			},
+		},
+		{
+			name: "does not broaden",
+			rules: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("get", "list").Groups("").Resources("pods").RuleOrDie(),
+				rbacv1helper.NewRule("get").Groups("").Resources("configmaps").RuleOrDie(),
+			},
+			expected: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("get").Groups("").Resources("configmaps").RuleOrDie(),
+				rbacv1helper.NewRule("get", "list").Groups("").Resources("pods").RuleOrDie(),
+			},
+		},
+		{
+			name: "named permissions covered by unnamed permissions",
+			rules: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("get").Groups("").Resources("pods").Names("a").RuleOrDie(),
+				rbacv1helper.NewRule("get").Groups("").Resources("pods").RuleOrDie(),
+			},
+			expected: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("get").Groups("").Resources("pods").RuleOrDie(),
+			},
+		},
+		{
+			name: "permissions covered by wildcards",
+			rules: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("get").Groups("apps").Resources("deployments").RuleOrDie(),
+				rbacv1helper.NewRule("*").Groups("apps").Resources("*").RuleOrDie(),
+				rbacv1helper.NewRule("get").URLs("/metrics/cadvisor").RuleOrDie(),
+				rbacv1helper.NewRule("get").URLs("/metrics/*").RuleOrDie(),
+			},
+			expected: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("get").URLs("/metrics/*").RuleOrDie(),
+				rbacv1helper.NewRule("*").Groups("apps").Resources("*").RuleOrDie(),
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := compactRules(tc.rules)
+			if !equality.Semantic.DeepEqual(tc.expected, actual) {
+				t.Errorf("unexpected rules\n%s", diff.ObjectGoPrintSideBySide(tc.expected, actual))
+			}
+		})
+	}
+}
+
+func TestCompactRulesAllowsSameRequests(t *testing.T) {
+	groups := []string{"", "apps", "*"}
+	resources := []string{"pods", "pods/status", "configmaps", "*", "*/status"}
+	names := []string{"a", "b", "c"}
+	verbs := []string{"get", "list", "update", "*"}
+	urls := []string{"/healthz", "/metrics", "/metrics/cadvisor", "/metrics/*", "*"}
+
+	randomSubset := func(r *rand.Rand, values []string) []string {
+		subset := []string{}
+		for len(subset) == 0 {
+			for _, v := range values {
+				if r.Intn(3) == 0 {
+					subset = append(subset, v)
+				}
+			}
+		}
+		return subset
+	}
+
+	// every request that could be allowed by rules built from the values above
+	requests := []authorizer.AttributesRecord{}
+	for _, group := range []string{"", "apps", "batch"} {
+		for _, resource := range []string{"pods", "pods/status", "pods/log", "configmaps", "secrets"} {
+			for _, name := range []string{"", "a", "b", "c", "d"} {
+				for _, verb := range []string{"get", "list", "update", "delete"} {
+					parts := strings.SplitN(resource, "/", 2)
+					request := authorizer.AttributesRecord{ResourceRequest: true, Verb: verb, APIGroup: group, Resource: parts[0], Name: name}
+					if len(parts) == 2 {
+						request.Subresource = parts[1]
+					}
+					requests = append(requests, request)
+				}
+			}
+		}
+	}
+	for _, url := range []string{"/healthz", "/metrics", "/metrics/cadvisor", "/metrics/other", "/other"} {
+		for _, verb := range []string{"get", "list", "update", "delete"} {
+			requests = append(requests, authorizer.AttributesRecord{Verb: verb, Path: url})
+		}
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		rules := []rbacv1.PolicyRule{}
+		for j := r.Intn(6); j >= 0; j-- {
+			if r.Intn(4) == 0 {
+				rules = append(rules, rbacv1helper.NewRule(randomSubset(r, verbs)...).URLs(randomSubset(r, urls)...).RuleOrDie())
+				continue
+			}
+			rule := rbacv1helper.NewRule(randomSubset(r, verbs)...).Groups(randomSubset(r, groups)...).Resources(randomSubset(r, resources)...).RuleOrDie()
+			if r.Intn(2) == 0 {
+				rule.ResourceNames = randomSubset(r, names)
+			}
+			rules = append(rules, rule)
+		}
+
+		compacted := compactRules(rules)
+		for _, request := range requests {
+			if expected, actual := rbacauthorizer.RulesAllow(request, rules...), rbacauthorizer.RulesAllow(request, compacted...); expected != actual {
+				t.Fatalf("compacting rules changed whether %#v was allowed (expected %v, got %v)\nrules:\n%s\ncompacted:\n%s", request, expected, actual, rulesString(rules), rulesString(compacted))
+			}
+		}
+
+		shuffled := append([]rbacv1.PolicyRule{}, rules...)
+		r.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		if reordered := compactRules(shuffled); !equality.Semantic.DeepEqual(compacted, reordered) {
+			t.Fatalf("compacting rules depends on input order\n%s", diff.ObjectGoPrintSideBySide(compacted, reordered))
+		}
+	}
+}
+
+func rulesString(rules []rbacv1.PolicyRule) string {
+	lines := []string{}
+	for _, rule := range rules {
+		lines = append(lines, rbacv1helper.CompactString(rule))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestCompactRulesMatchesBruteForce(t *testing.T) {
+	groups := []string{"", "apps"}
+	resources := []string{"pods", "configmaps"}
+	names := []string{"a", "b"}
+	verbs := []string{"get", "list"}
+
+	// every request that could be allowed by rules built from the values above, including a name no rule lists
+	requests := []authorizer.AttributesRecord{}
+	for _, group := range groups {
+		for _, resource := range resources {
+			for _, name := range []string{"", "a", "b", "c"} {
+				for _, verb := range verbs {
+					requests = append(requests, authorizer.AttributesRecord{ResourceRequest: true, Verb: verb, APIGroup: group, Resource: resource, Name: name})
+				}
+			}
+		}
+	}
+	allowed := func(rules []rbacv1.PolicyRule) uint64 {
+		mask := uint64(0)
+		for i, request := range requests {
+			if rbacauthorizer.RulesAllow(request, rules...) {
+				mask |= 1 << uint(i)
+			}
+		}
+		return mask
+	}
+
+	nonEmptySubsets := func(values []string) [][]string {
+		subsets := [][]string{}
+		for bits := 1; bits < 1<<uint(len(values)); bits++ {
+			subset := []string{}
+			for i, v := range values {
+				if bits&(1<<uint(i)) != 0 {
+					subset = append(subset, v)
+				}
+			}
+			subsets = append(subsets, subset)
+		}
+		return subsets
+	}
+	// every rule that can be built from the values above, and the requests it allows
+	candidates := []uint64{}
+	for _, g := range nonEmptySubsets(groups) {
+		for _, r := range nonEmptySubsets(resources) {
+			for _, v := range nonEmptySubsets(verbs) {
+				for _, n := range append([][]string{nil}, nonEmptySubsets(names)...) {
+					rule := rbacv1helper.NewRule(v...).Groups(g...).Resources(r...).RuleOrDie()
+					rule.ResourceNames = n
+					candidates = append(candidates, allowed([]rbacv1.PolicyRule{rule}))
+				}
+			}
+		}
+	}
+
+	// bruteForce returns the fewest candidate rules allowing exactly the requests in want
+	bruteForce := func(want uint64) int {
+		usable := []uint64{}
+		for _, c := range candidates {
+			if c&^want == 0 {
+				usable = append(usable, c)
+			}
+		}
+		level := map[uint64]bool{0: true}
+		for count := 0; ; count++ {
+			if level[want] {
+				return count
+			}
+			next := map[uint64]bool{}
+			for mask := range level {
+				for _, c := range usable {
+					next[mask|c] = true
+				}
+			}
+			level = next
+		}
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		rules := []rbacv1.PolicyRule{}
+		for _, group := range groups {
+			for _, resource := range resources {
+				for _, verb := range verbs {
+					if r.Intn(4) == 0 {
+						rules = append(rules, rbacv1helper.NewRule(verb).Groups(group).Resources(resource).RuleOrDie())
+					}
+					for _, name := range names {
+						if r.Intn(3) == 0 {
+							rules = append(rules, rbacv1helper.NewRule(verb).Groups(group).Resources(resource).Names(name).RuleOrDie())
+						}
+					}
+				}
+			}
+		}
+
+		want := allowed(rules)
+		compacted := compactRules(rules)
+		if actual := allowed(compacted); actual != want {
+			t.Fatalf("compacting rules changed the allowed requests\nrules:\n%s\ncompacted:\n%s", rulesString(rules), rulesString(compacted))
+		}
+		if expected := bruteForce(want); len(compacted) != expected {
+			t.Fatalf("expected %d rules, got %d\nrules:\n%s\ncompacted:\n%s", expected, len(compacted), rulesString(rules), rulesString(compacted))
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Discovery holds an offline snapshot of the resources served by a cluster
+type Discovery struct {
+	// namespaced holds whether each discovered resource (or resource/subresource) is namespaced
+	namespaced map[schema.GroupResource]bool
+	// subresourcesKnown holds the API groups whose subresources were included in the snapshot
+	subresourcesKnown sets.String
+}
+
+// NewDiscovery creates an empty Discovery
+func NewDiscovery() *Discovery {
+	return &Discovery{namespaced: map[schema.GroupResource]bool{}, subresourcesKnown: sets.NewString()}
+}
+
+// Load adds the resources in the given discovery data to the snapshot.
+// The data can be one or more APIResourceList documents in JSON or YAML
+// (as served from /api/<version> and /apis/<group>/<version>),
+// or the output of `kubectl api-resources -o wide`.
+// Documents that do not list resources, like APIGroupList and APIVersions, are ignored.
+func (d *Discovery) Load(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte("NAME ")) {
+		return d.loadTable(trimmed)
+	}
+
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		list := metav1.APIResourceList{}
+		if err := decoder.Decode(&list); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if len(list.GroupVersion) == 0 {
+			continue
+		}
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return err
+		}
+		d.subresourcesKnown.Insert(gv.Group)
+		for _, resource := range list.APIResources {
+			d.namespaced[schema.GroupResource{Group: gv.Group, Resource: resource.Name}] = resource.Namespaced
+		}
+	}
+}
+
+// loadTable loads the output of `kubectl api-resources`, which does not include subresources
+func (d *Discovery) loadTable(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	if !scanner.Scan() {
+		return scanner.Err()
+	}
+
+	// columns are aligned with their headers, and some columns (like SHORTNAMES) can be empty
+	header := scanner.Text()
+	columns := map[string][2]int{}
+	fields := strings.Fields(header)
+	for i, field := range fields {
+		start := strings.Index(header, field)
+		end := -1
+		if i+1 < len(fields) {
+			end = strings.Index(header, fields[i+1])
+		}
+		columns[field] = [2]int{start, end}
+	}
+	if _, ok := columns["NAMESPACED"]; !ok {
+		return fmt.Errorf("api-resources output does not contain a NAMESPACED column")
+	}
+	column := func(line, name string) (string, bool) {
+		c, ok := columns[name]
+		if !ok || c[0] >= len(line) {
+			return "", ok
+		}
+		if c[1] < 0 || c[1] > len(line) {
+			return strings.TrimSpace(line[c[0]:]), true
+		}
+		return strings.TrimSpace(line[c[0]:c[1]]), true
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		name, _ := column(line, "NAME")
+		namespaced, _ := column(line, "NAMESPACED")
+		if len(name) == 0 || (namespaced != "true" && namespaced != "false") {
+			return fmt.Errorf("unexpected api-resources output: %q", line)
+		}
+
+		group := ""
+		if apiVersion, ok := column(line, "APIVERSION"); ok {
+			gv, err := schema.ParseGroupVersion(apiVersion)
+			if err != nil {
+				return err
+			}
+			group = gv.Group
+		} else if apiGroup, ok := column(line, "APIGROUP"); ok {
+			// older versions of kubectl print the API group instead of the API version
+			group = apiGroup
+		}
+		d.namespaced[schema.GroupResource{Group: group, Resource: name}] = namespaced == "true"
+	}
+	return scanner.Err()
+}
+
+// Resource returns whether the resource is namespaced, and whether it was discovered
+func (d *Discovery) Resource(group, resource string) (namespaced bool, found bool) {
+	namespaced, found = d.namespaced[schema.GroupResource{Group: group, Resource: resource}]
+	return namespaced, found
+}
+
+// Subresource returns whether the subresource was discovered,
+// and whether the snapshot included the subresources of the API group
+func (d *Discovery) Subresource(group, resource, subresource string) (found bool, known bool) {
+	_, found = d.namespaced[schema.GroupResource{Group: group, Resource: resource + "/" + subresource}]
+	return found, d.subresourcesKnown.Has(group)
+}
+
+// SubresourcesKnown returns true if the snapshot included the subresources of any API group.
+// Subresources are only known from discovery documents, not from `kubectl api-resources` output.
+func (d *Discovery) SubresourcesKnown() bool {
+	return d.subresourcesKnown.Len() > 0
+}
+
+// Resources returns the resources and subresources discovered in the API group, and whether the list is complete.
+// If namespacedOnly is true, only resources that can be authorized within a namespace are returned.
+func (d *Discovery) Resources(group string, namespacedOnly bool) (resources sets.String, complete bool) {
+	resources = sets.NewString()
+	for groupResource, namespaced := range d.namespaced {
+		if groupResource.Group != group {
+			continue
+		}
+		// requests for a namespace are authorized within that namespace
+		if namespacedOnly && !namespaced && !(group == "" && strings.SplitN(groupResource.Resource, "/", 2)[0] == "namespaces") {
+			continue
+		}
+		resources.Insert(groupResource.Resource)
+	}
+	return resources, resources.Len() > 0 && d.subresourcesKnown.Has(group)
+}
@@ -0,0 +1,154 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const testDiscoveryDocuments = `{"kind":"APIResourceList","groupVersion":"v1","resources":[
+{"name":"configmaps","namespaced":true,"kind":"ConfigMap","verbs":["create","delete","get","list","patch","update","watch"]},
+{"name":"namespaces","namespaced":false,"kind":"Namespace","verbs":["create","delete","get","list","patch","update","watch"]},
+{"name":"namespaces/status","namespaced":false,"kind":"Namespace","verbs":["get","patch","update"]},
+{"name":"pods","namespaced":true,"kind":"Pod","verbs":["create","delete","get","list","patch","update","watch"]},
+{"name":"pods/log","namespaced":true,"kind":"Pod","verbs":["get"]}
+]}
+{"kind":"APIGroupList","groups":[]}
+{"kind":"APIResourceList","groupVersion":"apps/v1","resources":[
+{"name":"deployments","namespaced":true,"kind":"Deployment","verbs":["get","list"]}
+]}
+`
+
+const testDiscoveryYAML = `kind: APIResourceList
+groupVersion: v1
+resources:
+- name: pods
+  namespaced: true
+  kind: Pod
+  verbs: [get, list]
+- name: pods/status
+  namespaced: true
+  kind: Pod
+  verbs: [get]
+---
+kind: APIResourceList
+groupVersion: apps/v1
+resources:
+- name: deployments
+  namespaced: true
+  kind: Deployment
+  verbs: [get, list]
+`
+
+const testDiscoveryTable = `NAME                              SHORTNAMES   APIVERSION                             NAMESPACED   KIND                             VERBS
+configmaps                        cm           v1                                     true         ConfigMap                        [create delete deletecollection get list patch update watch]
+nodes                             no           v1                                     false        Node                             [create delete deletecollection get list patch update watch]
+bindings                                       v1                                     true         Binding                          [create]
+clusterroles                                   rbac.authorization.k8s.io/v1           false        ClusterRole                      [create delete deletecollection get list patch update watch]
+`
+
+const testDiscoveryLegacyTable = `NAME          SHORTNAMES   APIGROUP                    NAMESPACED   KIND
+nodes         no                                       false        Node
+roles                      rbac.authorization.k8s.io   true         Role
+`
+
+func TestDiscovery(t *testing.T) {
+	testcases := []struct {
+		name                 string
+		data                 string
+		namespaced           []string
+		clusterScoped        []string
+		missing              []string
+		subresourcesKnown    bool
+		completeCoreResource []string
+	}{
+		{
+			name:                 "discovery documents",
+			data:                 testDiscoveryDocuments,
+			namespaced:           []string{"configmaps", "pods", "pods/log", "deployments.apps"},
+			clusterScoped:        []string{"namespaces", "namespaces/status"},
+			missing:              []string{"secrets", "deployments.extensions"},
+			subresourcesKnown:    true,
+			completeCoreResource: []string{"configmaps", "namespaces", "namespaces/status", "pods", "pods/log"},
+		},
+		{
+			name:                 "discovery yaml documents",
+			data:                 testDiscoveryYAML,
+			namespaced:           []string{"pods", "pods/status", "deployments.apps"},
+			missing:              []string{"configmaps"},
+			subresourcesKnown:    true,
+			completeCoreResource: []string{"pods", "pods/status"},
+		},
+		{
+			name:          "api-resources output",
+			data:          testDiscoveryTable,
+			namespaced:    []string{"configmaps", "bindings"},
+			clusterScoped: []string{"nodes", "clusterroles.rbac.authorization.k8s.io"},
+			missing:       []string{"clusterroles", "pods"},
+		},
+		{
+			name:          "legacy api-resources output",
+			data:          testDiscoveryLegacyTable,
+			namespaced:    []string{"roles.rbac.authorization.k8s.io"},
+			clusterScoped: []string{"nodes"},
+			missing:       []string{"roles"},
+		},
+	}
+
+	split := func(s string) (group, resource string) {
+		parts := strings.SplitN(s, ".", 2)
+		if len(parts) == 2 {
+			return parts[1], parts[0]
+		}
+		return "", parts[0]
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewDiscovery()
+			if err := d.Load(strings.NewReader(tc.data)); err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range tc.namespaced {
+				if namespaced, found := d.Resource(split(r)); !found || !namespaced {
+					t.Errorf("expected %s to be a namespaced resource, got namespaced=%v found=%v", r, namespaced, found)
+				}
+			}
+			for _, r := range tc.clusterScoped {
+				if namespaced, found := d.Resource(split(r)); !found || namespaced {
+					t.Errorf("expected %s to be a cluster-scoped resource, got namespaced=%v found=%v", r, namespaced, found)
+				}
+			}
+			for _, r := range tc.missing {
+				if _, found := d.Resource(split(r)); found {
+					t.Errorf("expected %s to be missing", r)
+				}
+			}
+			if _, known := d.Subresource("", "pods", "status"); known != tc.subresourcesKnown {
+				t.Errorf("expected subresources known=%v, got %v", tc.subresourcesKnown, known)
+			}
+			if known := d.SubresourcesKnown(); known != tc.subresourcesKnown {
+				t.Errorf("expected subresources known=%v for any group, got %v", tc.subresourcesKnown, known)
+			}
+
+			resources, complete := d.Resources("", true)
+			if complete != (tc.completeCoreResource != nil) {
+				t.Errorf("unexpected complete=%v", complete)
+			}
+			if complete && !resources.Equal(sets.NewString(tc.completeCoreResource...)) {
+				t.Errorf("unexpected resources\n%s", diff.ObjectGoPrintSideBySide(tc.completeCoreResource, resources.List()))
+			}
+		})
+	}
+}
+
+func TestDiscoveryLoadError(t *testing.T) {
+	if err := NewDiscovery().Load(strings.NewReader("NAME   KIND\npods   Pod\n")); err == nil {
+		t.Error("expected error for api-resources output without a NAMESPACED column")
+	}
+	if err := NewDiscovery().Load(strings.NewReader(`{"kind":"APIResourceList","groupVersion":"a/b/c"}`)); err == nil {
+		t.Error("expected error for invalid group version")
+	}
+}
@@ -0,0 +1,213 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	helpervalidation "k8s.io/component-helpers/auth/rbac/validation"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+	"k8s.io/kubernetes/pkg/registry/rbac/validation"
+)
+
+const (
+	// EscalationReport reports permissions a subject must hold to write the roles and bindings it wrote
+	EscalationReport = "report"
+	// EscalationAdd adds the permissions granted by roles and bindings to the roles of the subject that wrote them
+	EscalationAdd = "add"
+	// EscalationBindEscalate adds escalate permission on written roles and bind permission on roles referenced by written bindings
+	EscalationBindEscalate = "bind-escalate"
+)
+
+// EscalationModes lists the supported values of GenerateOptions.EscalationMode
+var EscalationModes = []string{EscalationReport, EscalationAdd, EscalationBindEscalate}
+
+// Grant is a role or role binding written by a request.
+// Writing roles and bindings requires the writer to already hold the permissions being granted,
+// or to have escalate permission on the role, or bind permission on the role referenced by the binding.
+type Grant struct {
+	User user.Info
+	// Kind is Role, ClusterRole, RoleBinding, or ClusterRoleBinding
+	Kind      string
+	Namespace string
+	Name      string
+	// Rules are the rules of a role
+	Rules []rbacv1.PolicyRule
+	// RoleRef is the role referenced by a binding
+	RoleRef rbacv1.RoleRef
+}
+
+// DecodeGrant decodes the JSON role or binding written to the specified RBAC resource by a request.
+// The namespace of the request is used if the object does not specify one.
+func DecodeGrant(u user.Info, resource, namespace string, data []byte) (Grant, error) {
+	grant := Grant{User: u}
+	switch resource {
+	case "roles":
+		role := &rbacv1.Role{}
+		if err := json.Unmarshal(data, role); err != nil {
+			return grant, err
+		}
+		grant.Kind, grant.Namespace, grant.Name, grant.Rules = "Role", role.Namespace, role.Name, role.Rules
+	case "clusterroles":
+		role := &rbacv1.ClusterRole{}
+		if err := json.Unmarshal(data, role); err != nil {
+			return grant, err
+		}
+		grant.Kind, grant.Name, grant.Rules = "ClusterRole", role.Name, role.Rules
+	case "rolebindings":
+		binding := &rbacv1.RoleBinding{}
+		if err := json.Unmarshal(data, binding); err != nil {
+			return grant, err
+		}
+		grant.Kind, grant.Namespace, grant.Name, grant.RoleRef = "RoleBinding", binding.Namespace, binding.Name, binding.RoleRef
+	case "clusterrolebindings":
+		binding := &rbacv1.ClusterRoleBinding{}
+		if err := json.Unmarshal(data, binding); err != nil {
+			return grant, err
+		}
+		grant.Kind, grant.Name, grant.RoleRef = "ClusterRoleBinding", binding.Name, binding.RoleRef
+	default:
+		return grant, fmt.Errorf("unsupported resource %s", resource)
+	}
+	if len(grant.Namespace) == 0 && (grant.Kind == "Role" || grant.Kind == "RoleBinding") {
+		grant.Namespace = namespace
+	}
+	return grant, nil
+}
+
+func (grant Grant) String() string {
+	if len(grant.Namespace) > 0 {
+		return fmt.Sprintf("%s %s/%s", strings.ToLower(grant.Kind), grant.Namespace, grant.Name)
+	}
+	return fmt.Sprintf("%s %s", strings.ToLower(grant.Kind), grant.Name)
+}
+
+// key identifies grants with identical requirements
+func (grant Grant) key() string {
+	return strings.Join([]string{grant.User.GetName(), grant.Kind, grant.Namespace, grant.Name, rulesKey(grant.Rules), grant.RoleRef.Kind, grant.RoleRef.Name}, "\x00")
+}
+
+// addGrantRequirements ensures the subjects writing roles and bindings hold the permissions they grant,
+// or escalate or bind permission, according to the escalation mode. Gaps that are not added are reported as warnings.
+func (g *Generator) addGrantRequirements(resolver validation.AuthorizationRuleResolver, existingAuthorizer authorizer.Authorizer) {
+	// roles written by the requests take precedence over existing roles when resolving bindings
+	written := map[string][]rbacv1.PolicyRule{}
+	for _, grant := range g.Grants {
+		if grant.Kind == "Role" || grant.Kind == "ClusterRole" {
+			written[grant.Kind+"/"+grant.Namespace+"/"+grant.Name] = grant.Rules
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, grant := range g.Grants {
+		if seen[grant.key()] {
+			continue
+		}
+		seen[grant.key()] = true
+
+		// the permissions being granted are required in the namespace of the role or binding
+		var (
+			required []rbacv1.PolicyRule
+			override authorizer.AttributesRecord
+		)
+		switch grant.Kind {
+		case "Role", "ClusterRole":
+			if len(grant.Rules) == 0 {
+				// writing a role without rules does not grant anything, so requires no escalate permission
+				continue
+			}
+			required = grant.Rules
+			override = authorizer.AttributesRecord{Verb: "escalate", Resource: strings.ToLower(grant.Kind) + "s", Name: grant.Name}
+		case "RoleBinding", "ClusterRoleBinding":
+			refNamespace := ""
+			if grant.RoleRef.Kind == "Role" {
+				refNamespace = grant.Namespace
+			}
+			if rules, ok := written[grant.RoleRef.Kind+"/"+refNamespace+"/"+grant.RoleRef.Name]; ok {
+				required = rules
+			} else if rules, err := resolver.GetRoleReferenceRules(grant.RoleRef, grant.Namespace); err == nil {
+				required = rules
+			} else {
+				g.warn(fmt.Sprintf("cannot determine the permissions required to write %s, %s %s was not found", grant, strings.ToLower(grant.RoleRef.Kind), grant.RoleRef.Name))
+			}
+			override = authorizer.AttributesRecord{Verb: "bind", Resource: strings.ToLower(grant.RoleRef.Kind) + "s", Name: grant.RoleRef.Name}
+		default:
+			continue
+		}
+		override.User = grant.User
+		override.Namespace = grant.Namespace
+		override.APIGroup = rbacv1.GroupName
+		override.ResourceRequest = true
+
+		if decision, _, _ := existingAuthorizer.Authorize(context.Background(), override); decision == authorizer.DecisionAllow || g.generatedAllows(override) {
+			continue
+		}
+		held, _ := resolver.RulesFor(grant.User, grant.Namespace)
+		held = append(held, g.generatedRules(grant.User, grant.Namespace)...)
+		covered, missing := helpervalidation.Covers(held, required)
+		if covered && required != nil {
+			continue
+		}
+
+		switch {
+		case g.Options.EscalationMode == EscalationBindEscalate:
+			rule := attributesToResourceRule(override, GenerateOptions{})
+			if !g.ceilingAllows(grant.Namespace, rule) {
+				g.warn(fmt.Sprintf("%s wrote %s, which requires %s, but the ceiling does not allow it", grant.User.GetName(), grant, rbacv1helper.CompactString(rule)))
+				continue
+			}
+			if !g.neverGrantAllows(grant.Namespace, rule) {
+				g.warn(fmt.Sprintf("%s wrote %s, which requires %s, but it matches never-grant rules", grant.User.GetName(), grant, rbacv1helper.CompactString(rule)))
+				continue
+			}
+			g.addRules(grant.User, grant.Namespace, rule)
+			g.trace(Trace{Request: authorizer.AttributesRecord{User: grant.User}, Namespace: grant.Namespace, Rules: []rbacv1.PolicyRule{rule}, Reasons: []string{fmt.Sprintf("required to write %s", grant)}})
+		case g.Options.EscalationMode == EscalationAdd && required != nil:
+			missing, disallowed := g.clampToCeiling(grant.Namespace, missing)
+			if len(disallowed) > 0 {
+				g.warn(fmt.Sprintf("%s wrote %s, which requires %s, but the ceiling does not allow it", grant.User.GetName(), grant, disallowed))
+			}
+			missing, _ = g.clampToNeverGrant(grant.Namespace, missing)
+			if len(missing) == 0 {
+				continue
+			}
+			g.addRules(grant.User, grant.Namespace, missing...)
+			g.trace(Trace{Request: authorizer.AttributesRecord{User: grant.User}, Namespace: grant.Namespace, Rules: missing, Reasons: []string{fmt.Sprintf("granted by %s", grant)}})
+		case required != nil:
+			missingStrings := []string{}
+			for _, rule := range compactRules(missing) {
+				missingStrings = append(missingStrings, rbacv1helper.CompactString(rule))
+			}
+			g.warn(fmt.Sprintf("%s wrote %s, which requires %s, or the permissions it grants: %s",
+				grant.User.GetName(), grant, rbacv1helper.CompactString(attributesToResourceRule(override, GenerateOptions{})), strings.Join(missingStrings, "; ")))
+		}
+	}
+}
+
+// generatedRules returns the rules generated so far for the user in the namespace, including cluster-wide rules
+func (g *Generator) generatedRules(u user.Info, namespace string) []rbacv1.PolicyRule {
+	subject := userToSubject(u)
+	rules := []rbacv1.PolicyRule{}
+	if g.clusterRole != nil && g.clusterRoleBinding.Subjects[0] == subject {
+		rules = append(rules, g.clusterRole.Rules...)
+	}
+	if role := g.namespacedRole[namespace]; namespace != "" && role != nil && g.namespacedRoleBinding[namespace].Subjects[0] == subject {
+		rules = append(rules, role.Rules...)
+	}
+	return rules
+}
+
+// addRules adds rules to the generated cluster role, or the generated role in the namespace
+func (g *Generator) addRules(u user.Info, namespace string, rules ...rbacv1.PolicyRule) {
+	if namespace == "" {
+		clusterRole := g.ensureClusterRoleAndBinding(userToSubject(u))
+		clusterRole.Rules = append(clusterRole.Rules, rules...)
+	} else {
+		role := g.ensureNamespacedRoleAndBinding(userToSubject(u), namespace)
+		role.Rules = append(role.Rules, rules...)
+	}
+}
@@ -0,0 +1,149 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func TestDecodeGrant(t *testing.T) {
+	bob := &user.DefaultInfo{Name: "bob"}
+	testcases := []struct {
+		name     string
+		resource string
+		data     string
+		expected Grant
+	}{
+		{
+			name:     "role without namespace",
+			resource: "roles",
+			data:     `{"metadata":{"name":"app"},"rules":[{"verbs":["get"],"apiGroups":[""],"resources":["pods"]}]}`,
+			expected: Grant{User: bob, Kind: "Role", Namespace: "ns1", Name: "app", Rules: []rbacv1.PolicyRule{rbacv1helper.NewRule("get").Groups("").Resources("pods").RuleOrDie()}},
+		},
+		{
+			name:     "cluster role binding",
+			resource: "clusterrolebindings",
+			data:     `{"metadata":{"name":"view"},"roleRef":{"apiGroup":"rbac.authorization.k8s.io","kind":"ClusterRole","name":"view"}}`,
+			expected: Grant{User: bob, Kind: "ClusterRoleBinding", Name: "view", RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"}},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			grant, err := DecodeGrant(bob, tc.resource, "ns1", []byte(tc.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equality.Semantic.DeepEqual(tc.expected, grant) {
+				t.Errorf("unexpected grant\n%s", diff.ObjectGoPrintSideBySide(tc.expected, grant))
+			}
+		})
+	}
+
+	if _, err := DecodeGrant(bob, "pods", "ns1", []byte(`{}`)); err == nil {
+		t.Error("expected error for non-RBAC resource")
+	}
+}
+
+func TestGrantRequirements(t *testing.T) {
+	bob := &user.DefaultInfo{Name: "bob", Groups: []string{"system:authenticated"}}
+	existing := RBACObjects{
+		ClusterRoles: []*rbacv1.ClusterRole{
+			&rbacv1.ClusterRole{
+				ObjectMeta: metav1.ObjectMeta{Name: "view"},
+				Rules:      []rbacv1.PolicyRule{rbacv1helper.NewRule("get", "list").Groups("").Resources("configmaps").RuleOrDie()},
+			},
+			&rbacv1.ClusterRole{
+				ObjectMeta: metav1.ObjectMeta{Name: "nodes"},
+				Rules:      []rbacv1.PolicyRule{rbacv1helper.NewRule("get").Groups("").Resources("nodes").RuleOrDie()},
+			},
+		},
+		ClusterRoleBindings: []*rbacv1.ClusterRoleBinding{
+			&rbacv1.ClusterRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: "nodes"},
+				Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "bob"}},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "nodes"},
+			},
+		},
+	}
+	requests := []authorizer.AttributesRecord{
+		authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "create", Namespace: "ns1", APIGroup: rbacv1.GroupName, Resource: "roles"},
+		authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "create", Namespace: "ns1", APIGroup: rbacv1.GroupName, Resource: "rolebindings"},
+	}
+	grants := []Grant{
+		{User: bob, Kind: "Role", Namespace: "ns1", Name: "app", Rules: []rbacv1.PolicyRule{rbacv1helper.NewRule("get").Groups("").Resources("pods").RuleOrDie()}},
+		{User: bob, Kind: "RoleBinding", Namespace: "ns1", Name: "app", RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "app"}},
+		{User: bob, Kind: "RoleBinding", Namespace: "ns1", Name: "view", RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"}},
+		// grants nothing
+		{User: bob, Kind: "Role", Namespace: "ns1", Name: "empty"},
+		// already held
+		{User: bob, Kind: "RoleBinding", Namespace: "ns1", Name: "nodes", RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "nodes"}},
+		{User: bob, Kind: "RoleBinding", Namespace: "ns1", Name: "missing", RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "missing"}},
+	}
+
+	testcases := []struct {
+		mode     string
+		rules    []rbacv1.PolicyRule
+		warnings []string
+	}{
+		{
+			mode: EscalationReport,
+			rules: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("create").Groups("rbac.authorization.k8s.io").Resources("rolebindings", "roles").RuleOrDie(),
+			},
+			warnings: []string{
+				`bob wrote role ns1/app, which requires {APIGroups:["rbac.authorization.k8s.io"], Resources:["roles"], ResourceNames:["app"], Verbs:["escalate"]}, or the permissions it grants: {APIGroups:[""], Resources:["pods"], Verbs:["get"]}`,
+				`bob wrote rolebinding ns1/app, which requires {APIGroups:["rbac.authorization.k8s.io"], Resources:["roles"], ResourceNames:["app"], Verbs:["bind"]}, or the permissions it grants: {APIGroups:[""], Resources:["pods"], Verbs:["get"]}`,
+				`bob wrote rolebinding ns1/view, which requires {APIGroups:["rbac.authorization.k8s.io"], Resources:["clusterroles"], ResourceNames:["view"], Verbs:["bind"]}, or the permissions it grants: {APIGroups:[""], Resources:["configmaps"], Verbs:["get" "list"]}`,
+				`cannot determine the permissions required to write rolebinding ns1/missing, clusterrole missing was not found`,
+			},
+		},
+		{
+			mode: EscalationAdd,
+			rules: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("get", "list").Groups("").Resources("configmaps").RuleOrDie(),
+				rbacv1helper.NewRule("get").Groups("").Resources("pods").RuleOrDie(),
+				rbacv1helper.NewRule("create").Groups("rbac.authorization.k8s.io").Resources("rolebindings", "roles").RuleOrDie(),
+			},
+			warnings: []string{
+				`cannot determine the permissions required to write rolebinding ns1/missing, clusterrole missing was not found`,
+			},
+		},
+		{
+			mode: EscalationBindEscalate,
+			rules: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("bind").Groups("rbac.authorization.k8s.io").Resources("clusterroles").Names("missing", "view").RuleOrDie(),
+				rbacv1helper.NewRule("create").Groups("rbac.authorization.k8s.io").Resources("rolebindings", "roles").RuleOrDie(),
+				rbacv1helper.NewRule("bind", "escalate").Groups("rbac.authorization.k8s.io").Resources("roles").Names("app").RuleOrDie(),
+			},
+			warnings: []string{
+				`cannot determine the permissions required to write rolebinding ns1/missing, clusterrole missing was not found`,
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.mode, func(t *testing.T) {
+			opts := DefaultGenerateOptions()
+			opts.EscalationMode = tc.mode
+			generator := NewGenerator(existing, append([]authorizer.AttributesRecord{}, requests...), opts)
+			generator.Grants = grants
+			generated := generator.Generate()
+
+			if len(generated.ClusterRoles) != 0 || len(generated.Roles) != 1 {
+				t.Fatalf("expected a single role, got %#v", generated)
+			}
+			if !equality.Semantic.DeepEqual(tc.rules, generated.Roles[0].Rules) {
+				t.Errorf("unexpected rules\n%s", diff.ObjectGoPrintSideBySide(tc.rules, generated.Roles[0].Rules))
+			}
+			if !reflect.DeepEqual(tc.warnings, generator.Warnings()) {
+				t.Errorf("unexpected warnings\n%s", diff.ObjectGoPrintSideBySide(tc.warnings, generator.Warnings()))
+			}
+		})
+	}
+}
@@ -0,0 +1,440 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	helpervalidation "k8s.io/component-helpers/auth/rbac/validation"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+// MergeIdenticalRoles combines the objects generated for multiple subjects.
+// Roles with identical rules in the same namespace are replaced by a single shared role,
+// bound to all of the subjects that used them. Shared roles are named, labeled,
+// and annotated using the name, labels, and annotations in options, and keep the
+// annotations of the roles and bindings they replace as described by mergeAnnotations.
+func MergeIdenticalRoles(results []*RBACObjects, options GenerateOptions) *RBACObjects {
+	merged := &RBACObjects{}
+
+	// refs maps the roles referenced by bindings in each result to the shared role that replaces them
+	refs := map[resultRoleRef]rbacv1.RoleRef{}
+	sharedRoles := sets.NewString()
+	sharedName := func() string {
+		name := options.Name + ":shared"
+		if sharedRoles.Len() > 0 {
+			name = fmt.Sprintf("%s:shared:%d", options.Name, sharedRoles.Len()+1)
+		}
+		return name
+	}
+
+	clusterRoles := map[string][]resultRoleRef{}
+	clusterRoleKeys := []string{}
+	for i, result := range results {
+		for _, role := range result.ClusterRoles {
+			key := rulesKey(role.Rules)
+			if _, ok := clusterRoles[key]; !ok {
+				clusterRoleKeys = append(clusterRoleKeys, key)
+			}
+			clusterRoles[key] = append(clusterRoles[key], resultRoleRef{result: i, kind: "ClusterRole", name: role.Name})
+		}
+	}
+	for _, key := range clusterRoleKeys {
+		roles := clusterRoles[key]
+		first := findClusterRole(results[roles[0].result], roles[0].name)
+		if len(roles) == 1 {
+			merged.ClusterRoles = append(merged.ClusterRoles, first)
+			continue
+		}
+		replaced := []map[string]string{}
+		for _, role := range roles {
+			replaced = append(replaced, findClusterRole(results[role.result], role.name).Annotations)
+		}
+		shared := &rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: sharedName(), Labels: options.Labels, Annotations: mergeAnnotations(options.Annotations, replaced)},
+			Rules:      first.Rules,
+		}
+		sharedRoles.Insert(shared.Name)
+		merged.ClusterRoles = append(merged.ClusterRoles, shared)
+		for _, role := range roles {
+			refs[role] = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: shared.Name}
+		}
+	}
+
+	roles := map[string][]resultRoleRef{}
+	roleKeys := []string{}
+	for i, result := range results {
+		for _, role := range result.Roles {
+			key := role.Namespace + "\n" + rulesKey(role.Rules)
+			if _, ok := roles[key]; !ok {
+				roleKeys = append(roleKeys, key)
+			}
+			roles[key] = append(roles[key], resultRoleRef{result: i, kind: "Role", namespace: role.Namespace, name: role.Name})
+		}
+	}
+	for _, key := range roleKeys {
+		namespaceRoles := roles[key]
+		first := findRole(results[namespaceRoles[0].result], namespaceRoles[0].namespace, namespaceRoles[0].name)
+		if len(namespaceRoles) == 1 {
+			merged.Roles = append(merged.Roles, first)
+			continue
+		}
+		replaced := []map[string]string{}
+		for _, role := range namespaceRoles {
+			replaced = append(replaced, findRole(results[role.result], role.namespace, role.name).Annotations)
+		}
+		shared := &rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{Name: sharedName(), Namespace: first.Namespace, Labels: options.Labels, Annotations: mergeAnnotations(options.Annotations, replaced)},
+			Rules:      first.Rules,
+		}
+		sharedRoles.Insert(shared.Name)
+		merged.Roles = append(merged.Roles, shared)
+		for _, role := range namespaceRoles {
+			refs[role] = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: shared.Name}
+		}
+	}
+
+	// point bindings at the merged roles, and combine bindings to shared roles
+	sharedClusterRoleBindings := map[string]*rbacv1.ClusterRoleBinding{}
+	sharedRoleBindings := map[string]*rbacv1.RoleBinding{}
+	// replacedBindings holds the annotations of the bindings each shared binding replaces
+	replacedBindings := map[*metav1.ObjectMeta][]map[string]string{}
+	for i, result := range results {
+		for _, binding := range result.ClusterRoleBindings {
+			roleRef, ok := refs[resultRoleRef{result: i, kind: binding.RoleRef.Kind, name: binding.RoleRef.Name}]
+			if !ok || !sharedRoles.Has(roleRef.Name) {
+				merged.ClusterRoleBindings = append(merged.ClusterRoleBindings, binding)
+				continue
+			}
+			if shared, ok := sharedClusterRoleBindings[roleRef.Name]; ok {
+				shared.Subjects = appendSubjects(shared.Subjects, binding.Subjects...)
+				replacedBindings[&shared.ObjectMeta] = append(replacedBindings[&shared.ObjectMeta], binding.Annotations)
+				continue
+			}
+			shared := &rbacv1.ClusterRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: roleRef.Name, Labels: options.Labels},
+				RoleRef:    roleRef,
+				Subjects:   appendSubjects(nil, binding.Subjects...),
+			}
+			sharedClusterRoleBindings[roleRef.Name] = shared
+			replacedBindings[&shared.ObjectMeta] = []map[string]string{binding.Annotations}
+			merged.ClusterRoleBindings = append(merged.ClusterRoleBindings, shared)
+		}
+
+		for _, binding := range result.RoleBindings {
+			refNamespace := binding.Namespace
+			if binding.RoleRef.Kind == "ClusterRole" {
+				refNamespace = ""
+			}
+			roleRef, ok := refs[resultRoleRef{result: i, kind: binding.RoleRef.Kind, namespace: refNamespace, name: binding.RoleRef.Name}]
+			if !ok || !sharedRoles.Has(roleRef.Name) {
+				merged.RoleBindings = append(merged.RoleBindings, binding)
+				continue
+			}
+			key := binding.Namespace + "/" + roleRef.Name
+			if shared, ok := sharedRoleBindings[key]; ok {
+				shared.Subjects = appendSubjects(shared.Subjects, binding.Subjects...)
+				replacedBindings[&shared.ObjectMeta] = append(replacedBindings[&shared.ObjectMeta], binding.Annotations)
+				continue
+			}
+			shared := &rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: roleRef.Name, Namespace: binding.Namespace, Labels: options.Labels},
+				RoleRef:    roleRef,
+				Subjects:   appendSubjects(nil, binding.Subjects...),
+			}
+			sharedRoleBindings[key] = shared
+			replacedBindings[&shared.ObjectMeta] = []map[string]string{binding.Annotations}
+			merged.RoleBindings = append(merged.RoleBindings, shared)
+		}
+	}
+	for meta, replaced := range replacedBindings {
+		meta.Annotations = mergeAnnotations(options.Annotations, replaced)
+	}
+
+	return merged
+}
+
+// mergeAnnotations returns the annotations of a shared object replacing objects with the replaced annotations.
+// Annotations in options are kept as-is. Recognized patterns, event counts, and event time ranges are combined,
+// and other annotations are kept if every replaced object has the same value. Annotations that differ between
+// subjects only describe one of them, and are dropped.
+func mergeAnnotations(options map[string]string, replaced []map[string]string) map[string]string {
+	merged := map[string]string{}
+	keys := sets.NewString()
+	for _, annotations := range replaced {
+		for k := range annotations {
+			keys.Insert(k)
+		}
+	}
+	for _, k := range keys.List() {
+		values := []string{}
+		for _, annotations := range replaced {
+			if v, ok := annotations[k]; ok {
+				values = append(values, v)
+			}
+		}
+		switch k {
+		case PatternsAnnotation:
+			patterns := sets.NewString()
+			for _, v := range values {
+				patterns.Insert(strings.Split(v, ",")...)
+			}
+			merged[k] = strings.Join(patterns.List(), ",")
+			continue
+		case EventCountAnnotation:
+			if len(values) != len(replaced) {
+				continue
+			}
+			total := 0
+			for _, v := range values {
+				count, err := strconv.Atoi(v)
+				if err != nil {
+					total = -1
+					break
+				}
+				total += count
+			}
+			if total >= 0 {
+				merged[k] = strconv.Itoa(total)
+			}
+			continue
+		case EventTimeRangeAnnotation:
+			if len(values) != len(replaced) {
+				continue
+			}
+			// the times are formatted identically in UTC, so they sort lexically
+			first, last := "", ""
+			for _, v := range values {
+				parts := strings.Split(v, "/")
+				if len(parts) != 2 {
+					first = ""
+					break
+				}
+				if first == "" || parts[0] < first {
+					first = parts[0]
+				}
+				if parts[1] > last {
+					last = parts[1]
+				}
+			}
+			if first != "" {
+				merged[k] = first + "/" + last
+			}
+			continue
+		}
+		if len(values) == len(replaced) && sets.NewString(values...).Len() == 1 {
+			merged[k] = values[0]
+		}
+	}
+	for k, v := range options {
+		merged[k] = v
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
+
+// resultRoleRef identifies a role within one of a list of results
+type resultRoleRef struct {
+	result    int
+	kind      string
+	namespace string
+	name      string
+}
+
+func findClusterRole(objects *RBACObjects, name string) *rbacv1.ClusterRole {
+	for _, role := range objects.ClusterRoles {
+		if role.Name == name {
+			return role
+		}
+	}
+	return nil
+}
+
+func findRole(objects *RBACObjects, namespace, name string) *rbacv1.Role {
+	for _, role := range objects.Roles {
+		if role.Namespace == namespace && role.Name == name {
+			return role
+		}
+	}
+	return nil
+}
+
+func appendSubjects(subjects []rbacv1.Subject, newSubjects ...rbacv1.Subject) []rbacv1.Subject {
+	for _, newSubject := range newSubjects {
+		found := false
+		for _, subject := range subjects {
+			if subject == newSubject {
+				found = true
+				break
+			}
+		}
+		if !found {
+			subjects = append(subjects, newSubject)
+		}
+	}
+	return subjects
+}
+
+// Persona is a suggested role shared by subjects with similar permissions
+type Persona struct {
+	// Subjects are the subjects covered by the persona
+	Subjects []rbacv1.Subject
+	// Rules are the permissions granted by the persona, keyed by namespace ("" for cluster-wide permissions)
+	Rules map[string][]rbacv1.PolicyRule
+	// ExtraRules holds the permissions each subject would gain from the persona beyond what was generated for it,
+	// keyed by namespace. ExtraRules[i] corresponds to Subjects[i].
+	ExtraRules []map[string][]rbacv1.PolicyRule
+}
+
+// SuggestPersonas clusters the subjects of the given results by the similarity of their permissions.
+// A subject joins the most similar persona whose permissions have a Jaccard similarity of at least threshold
+// with its own, and otherwise starts a new persona. A threshold of 1 only groups subjects with identical permissions.
+func SuggestPersonas(results []*RBACObjects, threshold float64) []Persona {
+	type persona struct {
+		subjects    []rbacv1.Subject
+		permissions []permissionSet
+		union       permissionSet
+	}
+	personas := []*persona{}
+
+	for _, result := range results {
+		subject, ok := resultSubject(result)
+		if !ok {
+			continue
+		}
+		permissions := permissionsOf(result)
+
+		var best *persona
+		bestSimilarity := 0.0
+		for _, p := range personas {
+			if similarity := p.union.similarity(permissions); similarity >= threshold && (best == nil || similarity > bestSimilarity) {
+				best = p
+				bestSimilarity = similarity
+			}
+		}
+		if best == nil {
+			best = &persona{union: permissionSet{}}
+			personas = append(personas, best)
+		}
+		best.subjects = append(best.subjects, subject)
+		best.permissions = append(best.permissions, permissions)
+		for key, p := range permissions {
+			best.union[key] = p
+		}
+	}
+
+	suggested := make([]Persona, 0, len(personas))
+	for _, p := range personas {
+		suggestion := Persona{Subjects: p.subjects, Rules: p.union.rules()}
+		for _, permissions := range p.permissions {
+			extra := permissionSet{}
+			for key, permission := range p.union {
+				if _, ok := permissions[key]; !ok {
+					extra[key] = permission
+				}
+			}
+			suggestion.ExtraRules = append(suggestion.ExtraRules, extra.rules())
+		}
+		suggested = append(suggested, suggestion)
+	}
+	return suggested
+}
+
+// resultSubject returns the first subject bound by the given objects
+func resultSubject(objects *RBACObjects) (rbacv1.Subject, bool) {
+	for _, binding := range objects.ClusterRoleBindings {
+		if len(binding.Subjects) > 0 {
+			return binding.Subjects[0], true
+		}
+	}
+	for _, binding := range objects.RoleBindings {
+		if len(binding.Subjects) > 0 {
+			return binding.Subjects[0], true
+		}
+	}
+	return rbacv1.Subject{}, false
+}
+
+// boundRules returns the rules granted by the bindings in objects, keyed by namespace ("" for cluster-wide rules)
+func boundRules(objects *RBACObjects) map[string][]rbacv1.PolicyRule {
+	rules := map[string][]rbacv1.PolicyRule{}
+	for _, binding := range objects.ClusterRoleBindings {
+		if role := findClusterRole(objects, binding.RoleRef.Name); role != nil && binding.RoleRef.Kind == "ClusterRole" {
+			rules[""] = append(rules[""], role.Rules...)
+		}
+	}
+	for _, binding := range objects.RoleBindings {
+		switch binding.RoleRef.Kind {
+		case "ClusterRole":
+			if role := findClusterRole(objects, binding.RoleRef.Name); role != nil {
+				rules[binding.Namespace] = append(rules[binding.Namespace], role.Rules...)
+			}
+		case "Role":
+			if role := findRole(objects, binding.Namespace, binding.RoleRef.Name); role != nil {
+				rules[binding.Namespace] = append(rules[binding.Namespace], role.Rules...)
+			}
+		}
+	}
+	return rules
+}
+
+// scopedRule is a rule granted in a namespace, or cluster-wide if namespace is ""
+type scopedRule struct {
+	namespace string
+	rule      rbacv1.PolicyRule
+}
+
+// permissionSet holds individual permissions, keyed by a string representation of the permission
+type permissionSet map[string]scopedRule
+
+// permissionsOf breaks down the rules granted by the bindings in objects into individual permissions
+func permissionsOf(objects *RBACObjects) permissionSet {
+	permissions := permissionSet{}
+	for namespace, rules := range boundRules(objects) {
+		for _, rule := range rules {
+			for _, permission := range helpervalidation.BreakdownRule(rule) {
+				permissions[namespace+"\n"+rbacv1helper.CompactString(permission)] = scopedRule{namespace: namespace, rule: permission}
+			}
+		}
+	}
+	return permissions
+}
+
+// similarity returns the Jaccard similarity of the two permission sets
+func (p permissionSet) similarity(other permissionSet) float64 {
+	if len(p) == 0 && len(other) == 0 {
+		return 1
+	}
+	intersection := 0
+	for key := range other {
+		if _, ok := p[key]; ok {
+			intersection++
+		}
+	}
+	return float64(intersection) / float64(len(p)+len(other)-intersection)
+}
+
+// rules returns the compacted rules for the permissions in the set, keyed by namespace
+func (p permissionSet) rules() map[string][]rbacv1.PolicyRule {
+	keys := make([]string, 0, len(p))
+	for key := range p {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	rules := map[string][]rbacv1.PolicyRule{}
+	for _, key := range keys {
+		permission := p[key]
+		rules[permission.namespace] = append(rules[permission.namespace], permission.rule)
+	}
+	for namespace := range rules {
+		rules[namespace] = compactRules(rules[namespace])
+	}
+	return rules
+}
@@ -0,0 +1,184 @@
+package pkg
+
+import (
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func generateFor(username string, requests ...authorizer.AttributesRecord) *RBACObjects {
+	u := &user.DefaultInfo{Name: username}
+	for i := range requests {
+		requests[i].User = u
+		requests[i].ResourceRequest = true
+	}
+	opts := DefaultGenerateOptions()
+	opts.Name = "audit2rbac:" + username
+	return NewGenerator(RBACObjects{}, requests, opts).Generate()
+}
+
+func TestMergeIdenticalRoles(t *testing.T) {
+	results := []*RBACObjects{
+		generateFor("alice",
+			authorizer.AttributesRecord{Verb: "list", Resource: "nodes"},
+			authorizer.AttributesRecord{Verb: "get", Namespace: "ns1", Resource: "configmaps", Name: "cm1"},
+		),
+		generateFor("bob",
+			authorizer.AttributesRecord{Verb: "list", Resource: "nodes"},
+			authorizer.AttributesRecord{Verb: "get", Namespace: "ns1", Resource: "configmaps", Name: "cm2"},
+		),
+		generateFor("carol",
+			authorizer.AttributesRecord{Verb: "get", Namespace: "ns1", Resource: "configmaps", Name: "cm1"},
+		),
+	}
+
+	opts := DefaultGenerateOptions()
+	merged := MergeIdenticalRoles(results, opts)
+
+	names := func(objects *RBACObjects) (clusterRoles, roles []string) {
+		for _, role := range objects.ClusterRoles {
+			clusterRoles = append(clusterRoles, role.Name)
+		}
+		for _, role := range objects.Roles {
+			roles = append(roles, role.Namespace+"/"+role.Name)
+		}
+		return clusterRoles, roles
+	}
+	clusterRoles, roles := names(merged)
+	if expected := []string{"audit2rbac:shared"}; !equality.Semantic.DeepEqual(expected, clusterRoles) {
+		t.Errorf("unexpected cluster roles\n%s", diff.ObjectGoPrintSideBySide(expected, clusterRoles))
+	}
+	if expected := []string{"ns1/audit2rbac:shared:2", "ns1/audit2rbac:bob"}; !equality.Semantic.DeepEqual(expected, roles) {
+		t.Errorf("unexpected roles\n%s", diff.ObjectGoPrintSideBySide(expected, roles))
+	}
+
+	if len(merged.ClusterRoleBindings) != 1 {
+		t.Fatalf("expected 1 cluster role binding, got %d", len(merged.ClusterRoleBindings))
+	}
+	expectedSubjects := []rbacv1.Subject{
+		{Kind: "User", APIGroup: rbacv1.GroupName, Name: "alice"},
+		{Kind: "User", APIGroup: rbacv1.GroupName, Name: "bob"},
+	}
+	if binding := merged.ClusterRoleBindings[0]; binding.RoleRef.Name != "audit2rbac:shared" || !equality.Semantic.DeepEqual(expectedSubjects, binding.Subjects) {
+		t.Errorf("unexpected cluster role binding %#v", binding)
+	}
+
+	if len(merged.RoleBindings) != 2 {
+		t.Fatalf("expected 2 role bindings, got %d", len(merged.RoleBindings))
+	}
+	expectedSubjects = []rbacv1.Subject{
+		{Kind: "User", APIGroup: rbacv1.GroupName, Name: "alice"},
+		{Kind: "User", APIGroup: rbacv1.GroupName, Name: "carol"},
+	}
+	if binding := merged.RoleBindings[0]; binding.RoleRef.Name != "audit2rbac:shared:2" || !equality.Semantic.DeepEqual(expectedSubjects, binding.Subjects) {
+		t.Errorf("unexpected role binding %#v", binding)
+	}
+	if binding := merged.RoleBindings[1]; binding.RoleRef.Name != "audit2rbac:bob" {
+		t.Errorf("unexpected role binding %#v", binding)
+	}
+}
+
+func TestMergeIdenticalRolesAnnotations(t *testing.T) {
+	results := []*RBACObjects{
+		generateFor("alice", authorizer.AttributesRecord{Verb: "list", Resource: "nodes"}),
+		generateFor("bob", authorizer.AttributesRecord{Verb: "list", Resource: "nodes"}),
+	}
+	results[0].ClusterRoles[0].Annotations = map[string]string{
+		"owner":                  "alice",
+		"team":                   "infra",
+		PatternsAnnotation:       LeaderElectionPattern,
+		EventCountAnnotation:     "2",
+		EventTimeRangeAnnotation: "2021-01-02T00:00:00Z/2021-01-03T00:00:00Z",
+	}
+	results[1].ClusterRoles[0].Annotations = map[string]string{
+		"owner":                  "bob",
+		"team":                   "infra",
+		PatternsAnnotation:       EventRecordingPattern,
+		EventCountAnnotation:     "3",
+		EventTimeRangeAnnotation: "2021-01-01T00:00:00Z/2021-01-02T00:00:00Z",
+	}
+	results[0].ClusterRoleBindings[0].Annotations = map[string]string{EventCountAnnotation: "2"}
+	results[1].ClusterRoleBindings[0].Annotations = map[string]string{EventCountAnnotation: "3"}
+
+	opts := DefaultGenerateOptions()
+	opts.Annotations = map[string]string{"version": "v1"}
+	merged := MergeIdenticalRoles(results, opts)
+
+	expected := map[string]string{
+		"version":                "v1",
+		"team":                   "infra",
+		PatternsAnnotation:       EventRecordingPattern + "," + LeaderElectionPattern,
+		EventCountAnnotation:     "5",
+		EventTimeRangeAnnotation: "2021-01-01T00:00:00Z/2021-01-03T00:00:00Z",
+	}
+	if len(merged.ClusterRoles) != 1 || !equality.Semantic.DeepEqual(expected, merged.ClusterRoles[0].Annotations) {
+		t.Errorf("unexpected cluster roles\n%s", diff.ObjectGoPrintSideBySide(expected, merged.ClusterRoles))
+	}
+	expected = map[string]string{"version": "v1", EventCountAnnotation: "5"}
+	if len(merged.ClusterRoleBindings) != 1 || !equality.Semantic.DeepEqual(expected, merged.ClusterRoleBindings[0].Annotations) {
+		t.Errorf("unexpected cluster role bindings\n%s", diff.ObjectGoPrintSideBySide(expected, merged.ClusterRoleBindings))
+	}
+}
+
+func TestSuggestPersonas(t *testing.T) {
+	results := []*RBACObjects{
+		generateFor("alice",
+			authorizer.AttributesRecord{Verb: "get", Resource: "nodes"},
+			authorizer.AttributesRecord{Verb: "get", Resource: "namespaces"},
+			authorizer.AttributesRecord{Verb: "get", Resource: "persistentvolumes"},
+		),
+		generateFor("bob",
+			authorizer.AttributesRecord{Verb: "get", Resource: "nodes"},
+			authorizer.AttributesRecord{Verb: "get", Resource: "namespaces"},
+		),
+		generateFor("carol",
+			authorizer.AttributesRecord{Verb: "create", Namespace: "ns1", Resource: "pods"},
+		),
+	}
+
+	testcases := []struct {
+		name      string
+		threshold float64
+		personas  [][]string
+	}{
+		{name: "identical only", threshold: 1, personas: [][]string{{"alice"}, {"bob"}, {"carol"}}},
+		{name: "similar", threshold: 0.5, personas: [][]string{{"alice", "bob"}, {"carol"}}},
+		{name: "everything", threshold: 0, personas: [][]string{{"alice", "bob", "carol"}}},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			personas := SuggestPersonas(results, tc.threshold)
+			actual := [][]string{}
+			for _, persona := range personas {
+				subjects := []string{}
+				for _, subject := range persona.Subjects {
+					subjects = append(subjects, subject.Name)
+				}
+				actual = append(actual, subjects)
+			}
+			if !equality.Semantic.DeepEqual(tc.personas, actual) {
+				t.Errorf("unexpected personas\n%s", diff.ObjectGoPrintSideBySide(tc.personas, actual))
+			}
+		})
+	}
+
+	personas := SuggestPersonas(results, 0.5)
+	expectedRules := map[string][]rbacv1.PolicyRule{
+		"": {rbacv1helper.NewRule("get").Groups("").Resources("namespaces", "nodes", "persistentvolumes").RuleOrDie()},
+	}
+	if !equality.Semantic.DeepEqual(expectedRules, personas[0].Rules) {
+		t.Errorf("unexpected persona rules\n%s", diff.ObjectGoPrintSideBySide(expectedRules, personas[0].Rules))
+	}
+	expectedExtra := []map[string][]rbacv1.PolicyRule{
+		{},
+		{"": {rbacv1helper.NewRule("get").Groups("").Resources("persistentvolumes").RuleOrDie()}},
+	}
+	if !equality.Semantic.DeepEqual(expectedExtra, personas[0].ExtraRules) {
+		t.Errorf("unexpected extra rules\n%s", diff.ObjectGoPrintSideBySide(expectedExtra, personas[0].ExtraRules))
+	}
+}
@@ -0,0 +1,92 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	helpervalidation "k8s.io/component-helpers/auth/rbac/validation"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+// neverGrantRequest returns true if a never-grant rule matches the request, and reports it as a warning
+func (g *Generator) neverGrantRequest(request authorizer.AttributesRecord) bool {
+	i := MatchRequestRules(g.Options.NeverGrant, request)
+	if i < 0 {
+		return false
+	}
+	var rule rbacv1.PolicyRule
+	if request.ResourceRequest {
+		rule = attributesToResourceRule(request, GenerateOptions{})
+	} else {
+		rule = rbacv1helper.NewRule(request.Verb).URLs(request.Path).RuleOrDie()
+	}
+	g.warn(fmt.Sprintf("did not grant %s %s, which matches never-grant rule %s", rbacv1helper.CompactString(rule), describeNamespace(request.Namespace), describeRequestRule(g.Options.NeverGrant[i])))
+	return true
+}
+
+// neverGrantMatch returns the index of the first never-grant rule matching a request the rule allows in the namespace, or -1 if none do
+func (g *Generator) neverGrantMatch(namespace string, rule rbacv1.PolicyRule) int {
+	for i := range g.Options.NeverGrant {
+		if g.Options.NeverGrant[i].MatchedByRule(namespace, rule) {
+			return i
+		}
+	}
+	return -1
+}
+
+// neverGrantAllows returns true if the rules do not allow any request matched by a never-grant rule in the namespace
+func (g *Generator) neverGrantAllows(namespace string, rules ...rbacv1.PolicyRule) bool {
+	for _, rule := range rules {
+		if g.neverGrantMatch(namespace, rule) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// clampToNeverGrant returns the parts of the rules that do not allow requests matched by never-grant rules in the namespace,
+// and describes the parts that do. Each removed permission is reported as a warning.
+func (g *Generator) clampToNeverGrant(namespace string, rules []rbacv1.PolicyRule) ([]rbacv1.PolicyRule, string) {
+	if g.neverGrantAllows(namespace, rules...) {
+		return rules, ""
+	}
+	allowed := []rbacv1.PolicyRule{}
+	denied := []rbacv1.PolicyRule{}
+	for _, rule := range rules {
+		if g.neverGrantAllows(namespace, rule) {
+			allowed = append(allowed, rule)
+			continue
+		}
+		for _, permission := range helpervalidation.BreakdownRule(rule) {
+			i := g.neverGrantMatch(namespace, permission)
+			if i < 0 {
+				allowed = append(allowed, permission)
+				continue
+			}
+			denied = append(denied, permission)
+			g.warn(fmt.Sprintf("did not grant %s %s, which allows requests matching never-grant rule %s", rbacv1helper.CompactString(permission), describeNamespace(namespace), describeRequestRule(g.Options.NeverGrant[i])))
+		}
+	}
+	descriptions := []string{}
+	for _, rule := range compactRules(denied) {
+		descriptions = append(descriptions, rbacv1helper.CompactString(rule))
+	}
+	return allowed, strings.Join(descriptions, "; ")
+}
+
+func describeNamespace(namespace string) string {
+	if namespace == "" {
+		return "cluster-wide"
+	}
+	return "in namespace " + namespace
+}
+
+func describeRequestRule(rule RequestRule) string {
+	s := rbacv1helper.CompactString(rule.PolicyRule)
+	if rule.ClusterWide {
+		s += " cluster-wide"
+	}
+	return s
+}
@@ -0,0 +1,102 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func TestGenerateNeverGrant(t *testing.T) {
+	bob := &user.DefaultInfo{Name: "bob"}
+	neverGrant := []RequestRule{
+		{PolicyRule: rbacv1helper.NewRule("get").Groups("").Resources("secrets").RuleOrDie()},
+		{PolicyRule: rbacv1helper.NewRule("*").Groups("").Resources("configmaps").RuleOrDie(), ClusterWide: true},
+		{PolicyRule: rbacv1helper.NewRule("create").Groups("").Resources("events").RuleOrDie()},
+		{PolicyRule: rbacv1helper.NewRule("get").URLs("/debug/pprof").RuleOrDie()},
+	}
+	requests := []authorizer.AttributesRecord{
+		// watch is usually expanded to get and list
+		{User: bob, ResourceRequest: true, Verb: "watch", Namespace: "ns1", Resource: "secrets"},
+		// requests in more than one namespace are usually expanded to any namespace
+		{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", Resource: "configmaps", Name: "c1"},
+		{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns2", Resource: "configmaps", Name: "c1"},
+		// the canonical event recording rules include create
+		{User: bob, ResourceRequest: true, Verb: "patch", Namespace: "ns1", Resource: "events", Name: "e1"},
+		// requests for more than three paths under a prefix are usually expanded to the prefix
+		{User: bob, Verb: "get", Path: "/debug/a"},
+		{User: bob, Verb: "get", Path: "/debug/b"},
+		{User: bob, Verb: "get", Path: "/debug/c"},
+		{User: bob, Verb: "get", Path: "/debug/d"},
+		// requests matching a never-grant rule are not granted
+		{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", Resource: "secrets", Name: "s1"},
+	}
+
+	opts := DefaultGenerateOptions()
+	opts.NeverGrant = neverGrant
+	opts.RecognizePatterns = true
+	generator := NewGenerator(RBACObjects{}, append([]authorizer.AttributesRecord{}, requests...), opts)
+	generated := generator.Generate()
+
+	expectedClusterRules := []rbacv1.PolicyRule{
+		rbacv1helper.NewRule("get").URLs("/debug/a", "/debug/b", "/debug/c", "/debug/d").RuleOrDie(),
+	}
+	expectedRoleRules := map[string][]rbacv1.PolicyRule{
+		"ns1": {
+			rbacv1helper.NewRule("get").Groups("").Resources("configmaps").Names("c1").RuleOrDie(),
+			rbacv1helper.NewRule("patch").Groups("").Resources("events").RuleOrDie(),
+			rbacv1helper.NewRule("list", "watch").Groups("").Resources("secrets").RuleOrDie(),
+		},
+		"ns2": {
+			rbacv1helper.NewRule("get").Groups("").Resources("configmaps").Names("c1").RuleOrDie(),
+		},
+	}
+	expectedWarnings := []string{
+		`did not grant {APIGroups:[""], Resources:["secrets"], Verbs:["get"]} in namespace ns1, which allows requests matching never-grant rule {APIGroups:[""], Resources:["secrets"], Verbs:["get"]}`,
+		`did not grant {APIGroups:[""], Resources:["events"], Verbs:["create"]} in namespace ns1, which allows requests matching never-grant rule {APIGroups:[""], Resources:["events"], Verbs:["create"]}`,
+		`did not grant {APIGroups:[""], Resources:["secrets"], ResourceNames:["s1"], Verbs:["get"]} in namespace ns1, which matches never-grant rule {APIGroups:[""], Resources:["secrets"], Verbs:["get"]}`,
+	}
+
+	var clusterRules []rbacv1.PolicyRule
+	for _, role := range generated.ClusterRoles {
+		clusterRules = append(clusterRules, role.Rules...)
+	}
+	roleRules := map[string][]rbacv1.PolicyRule{}
+	for _, role := range generated.Roles {
+		roleRules[role.Namespace] = role.Rules
+	}
+	if !equality.Semantic.DeepEqual(expectedClusterRules, clusterRules) {
+		t.Errorf("unexpected cluster role rules\n%s", diff.ObjectGoPrintSideBySide(expectedClusterRules, clusterRules))
+	}
+	if !equality.Semantic.DeepEqual(expectedRoleRules, roleRules) {
+		t.Errorf("unexpected role rules\n%s", diff.ObjectGoPrintSideBySide(expectedRoleRules, roleRules))
+	}
+	if !reflect.DeepEqual(expectedWarnings, generator.Warnings()) {
+		t.Errorf("unexpected warnings\n%s", diff.ObjectGoPrintSideBySide(expectedWarnings, generator.Warnings()))
+	}
+
+	// nothing the generated objects allow is matched by a never-grant rule
+	for _, role := range generated.ClusterRoles {
+		for i := range neverGrant {
+			for _, rule := range role.Rules {
+				if neverGrant[i].MatchedByRule("", rule) {
+					t.Errorf("cluster role rule %s allows requests matching never-grant rule %s", rbacv1helper.CompactString(rule), describeRequestRule(neverGrant[i]))
+				}
+			}
+		}
+	}
+	for _, role := range generated.Roles {
+		for i := range neverGrant {
+			for _, rule := range role.Rules {
+				if neverGrant[i].MatchedByRule(role.Namespace, rule) {
+					t.Errorf("role rule %s in namespace %s allows requests matching never-grant rule %s", rbacv1helper.CompactString(rule), role.Namespace, describeRequestRule(neverGrant[i]))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package pkg
+
+import (
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+// PatternsAnnotation is set on generated roles to the comma-separated list of well-known access patterns
+// recognized in the requests the role was generated for
+const PatternsAnnotation = "audit2rbac.liggitt.net/patterns"
+
+const (
+	// LeaderElectionPattern is a named lock object read and updated by a controller, and created if it does not exist
+	LeaderElectionPattern = "leader-election"
+	// EventRecordingPattern is creating events, and patching them when they recur
+	EventRecordingPattern = "event-recording"
+)
+
+var lockResources = map[schema.GroupResource]bool{
+	{Group: "coordination.k8s.io", Resource: "leases"}: true,
+	{Group: "", Resource: "configmaps"}:                true,
+	{Group: "", Resource: "endpoints"}:                 true,
+}
+
+var eventResources = map[schema.GroupResource]bool{
+	{Group: "", Resource: "events"}:              true,
+	{Group: "events.k8s.io", Resource: "events"}: true,
+}
+
+var eventRecordingVerbs = sets.NewString("create", "patch")
+
+// patternMatcher recognizes requests that are part of well-known access patterns
+type patternMatcher struct {
+	// locks holds the keys (without verbs) of objects that were both read and updated by name
+	locks map[requestKey]bool
+	// created holds the keys (without verbs and names) of the lock resources created in each namespace
+	created map[requestKey]bool
+}
+
+func newPatternMatcher(requests []authorizer.AttributesRecord) *patternMatcher {
+	verbs := map[requestKey]sets.String{}
+	created := map[requestKey]bool{}
+	for _, request := range requests {
+		if request.ResourceRequest && request.Verb == "create" && request.Subresource == "" &&
+			lockResources[schema.GroupResource{Group: request.APIGroup, Resource: request.Resource}] {
+			created[createKey(request)] = true
+		}
+		if !isLockRequest(request) {
+			continue
+		}
+		key := lockKey(request)
+		if verbs[key] == nil {
+			verbs[key] = sets.NewString()
+		}
+		verbs[key].Insert(request.Verb)
+	}
+
+	m := &patternMatcher{locks: map[requestKey]bool{}, created: created}
+	for key, v := range verbs {
+		if v.HasAll("get", "update") {
+			m.locks[key] = true
+		}
+	}
+	return m
+}
+
+func isLockRequest(request authorizer.AttributesRecord) bool {
+	return request.ResourceRequest &&
+		request.Namespace != "" &&
+		request.Name != "" &&
+		request.Subresource == "" &&
+		(request.Verb == "get" || request.Verb == "update") &&
+		lockResources[schema.GroupResource{Group: request.APIGroup, Resource: request.Resource}]
+}
+
+func lockKey(request authorizer.AttributesRecord) requestKey {
+	request.Verb = ""
+	request.Path = ""
+	return newRequestKey(request)
+}
+
+func createKey(request authorizer.AttributesRecord) requestKey {
+	request.Name = ""
+	return lockKey(request)
+}
+
+// isLock returns true if the request reads or updates an object used as a leader election lock
+func (m *patternMatcher) isLock(request authorizer.AttributesRecord) bool {
+	return isLockRequest(request) && m.locks[lockKey(request)]
+}
+
+// match returns the name of the pattern the request is part of, and the canonical rules for the pattern.
+// The rules for event recording are unnamed, and should be generated in the namespace the request is expanded to.
+func (m *patternMatcher) match(request authorizer.AttributesRecord) (string, []rbacv1.PolicyRule) {
+	switch {
+	case m.isLock(request):
+		rules := []rbacv1.PolicyRule{
+			rbacv1helper.NewRule("get", "update").Groups(request.APIGroup).Resources(request.Resource).Names(request.Name).RuleOrDie(),
+		}
+		// configmaps and endpoints read and updated by name are often not locks, so they are only allowed to be created
+		// if they were, while leases are created by name when they do not exist yet.
+		// The name of create requests is not authorized.
+		if request.Resource == "leases" || m.created[createKey(request)] {
+			rules = append([]rbacv1.PolicyRule{rbacv1helper.NewRule("create").Groups(request.APIGroup).Resources(request.Resource).RuleOrDie()}, rules...)
+		}
+		return LeaderElectionPattern, rules
+
+	case request.ResourceRequest &&
+		request.Subresource == "" &&
+		eventRecordingVerbs.Has(request.Verb) &&
+		eventResources[schema.GroupResource{Group: request.APIGroup, Resource: request.Resource}]:
+		return EventRecordingPattern, []rbacv1.PolicyRule{
+			rbacv1helper.NewRule(eventRecordingVerbs.List()...).Groups(request.APIGroup).Resources(request.Resource).RuleOrDie(),
+		}
+	}
+	return "", nil
+}
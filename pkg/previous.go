@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"sort"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	helpervalidation "k8s.io/component-helpers/auth/rbac/validation"
+)
+
+// seedPrevious starts the generated objects from the objects in g.Previous.
+// Role bindings to shared cluster roles are seeded as roles in the namespace of the binding,
+// and are shared again if Options.ShareIdenticalNamespacedRoles is set.
+func (g *Generator) seedPrevious() {
+	clusterRoles := map[string]*rbacv1.ClusterRole{}
+	for _, role := range g.Previous.ClusterRoles {
+		clusterRoles[role.Name] = role
+	}
+	roles := map[string]*rbacv1.Role{}
+	for _, role := range g.Previous.Roles {
+		roles[role.Namespace+"/"+role.Name] = role
+	}
+
+	// the generated objects are bound to a single subject making the requests
+	subjects := map[rbacv1.Subject]bool{}
+	for _, request := range g.requests {
+		subjects[userToSubject(request.User)] = true
+	}
+
+	for _, binding := range g.Previous.ClusterRoleBindings {
+		role := clusterRoles[binding.RoleRef.Name]
+		if role == nil || binding.RoleRef.Kind != "ClusterRole" || len(binding.Subjects) != 1 || !subjects[binding.Subjects[0]] || g.clusterRole != nil {
+			continue
+		}
+		g.clusterRole = role.DeepCopy()
+		g.clusterRoleBinding = binding.DeepCopy()
+		g.generated.ClusterRoles = append(g.generated.ClusterRoles, g.clusterRole)
+		g.generated.ClusterRoleBindings = append(g.generated.ClusterRoleBindings, g.clusterRoleBinding)
+		g.seedPatterns("", role.Annotations)
+		g.previousRules[""] = len(role.Rules)
+	}
+
+	for _, binding := range g.Previous.RoleBindings {
+		if len(binding.Subjects) != 1 || !subjects[binding.Subjects[0]] || g.namespacedRole[binding.Namespace] != nil {
+			continue
+		}
+		var role *rbacv1.Role
+		switch binding.RoleRef.Kind {
+		case "Role":
+			if previous := roles[binding.Namespace+"/"+binding.RoleRef.Name]; previous != nil {
+				role = previous.DeepCopy()
+			}
+		case "ClusterRole":
+			if shared := clusterRoles[binding.RoleRef.Name]; shared != nil {
+				role = &rbacv1.Role{ObjectMeta: *shared.ObjectMeta.DeepCopy(), Rules: shared.DeepCopy().Rules}
+				role.Name = binding.Name
+				role.Namespace = binding.Namespace
+			}
+		}
+		if role == nil {
+			continue
+		}
+		binding = binding.DeepCopy()
+		binding.RoleRef = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: role.Name}
+		g.namespacedRole[binding.Namespace] = role
+		g.namespacedRoleBinding[binding.Namespace] = binding
+		g.generated.Roles = append(g.generated.Roles, role)
+		g.generated.RoleBindings = append(g.generated.RoleBindings, binding)
+		g.seedPatterns(binding.Namespace, role.Annotations)
+		g.previousRules[binding.Namespace] = len(role.Rules)
+	}
+}
+
+func (g *Generator) seedPatterns(namespace string, annotations map[string]string) {
+	for _, pattern := range strings.Split(annotations[PatternsAnnotation], ",") {
+		if len(pattern) == 0 {
+			continue
+		}
+		if g.patterns[namespace] == nil {
+			g.patterns[namespace] = sets.NewString()
+		}
+		g.patterns[namespace].Insert(pattern)
+	}
+}
+
+// RulesDiff lists rules granted in a namespace, or cluster-wide if Namespace is empty
+type RulesDiff struct {
+	Namespace string
+	Rules     []rbacv1.PolicyRule
+}
+
+// DiffRules returns the rules granted by the bindings in objects that are not allowed by the bindings in base,
+// compacted and sorted by namespace, with cluster-wide rules first.
+// Rules granted cluster-wide in base allow rules granted in any namespace.
+func DiffRules(base, objects *RBACObjects) []RulesDiff {
+	baseRules := boundRules(base)
+	rules := boundRules(objects)
+	namespaces := []string{}
+	for namespace := range rules {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	diffs := []RulesDiff{}
+	for _, namespace := range namespaces {
+		allowed := baseRules[""]
+		if namespace != "" {
+			allowed = append(append([]rbacv1.PolicyRule{}, allowed...), baseRules[namespace]...)
+		}
+		if _, missing := helpervalidation.Covers(allowed, rules[namespace]); len(missing) > 0 {
+			diffs = append(diffs, RulesDiff{Namespace: namespace, Rules: compactRules(missing)})
+		}
+	}
+	return diffs
+}
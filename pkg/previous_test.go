@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func TestGeneratePrevious(t *testing.T) {
+	bob := &user.DefaultInfo{Name: "bob"}
+	subjects := []rbacv1.Subject{userToSubject(bob)}
+	previousMeta := func(namespace string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: "audit2rbac:bob", Namespace: namespace, Labels: map[string]string{"date": "20200101"}}
+	}
+	// previous rules are kept as they were, even if they could be compacted
+	getNode1 := rbacv1helper.NewRule("get").Groups("").Resources("nodes").Names("node1").RuleOrDie()
+	getNode2 := rbacv1helper.NewRule("get").Groups("").Resources("nodes").Names("node2").RuleOrDie()
+	getPods := rbacv1helper.NewRule("get").Groups("").Resources("pods").RuleOrDie()
+	listSecrets := rbacv1helper.NewRule("list").Groups("").Resources("secrets").RuleOrDie()
+
+	ns1Role := &rbacv1.Role{ObjectMeta: previousMeta("ns1"), Rules: []rbacv1.PolicyRule{getPods}}
+	ns1Role.Annotations = map[string]string{PatternsAnnotation: EventRecordingPattern}
+	previous := RBACObjects{
+		ClusterRoles: []*rbacv1.ClusterRole{
+			{ObjectMeta: previousMeta(""), Rules: []rbacv1.PolicyRule{getNode1, getNode2}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac:shared", Labels: map[string]string{"date": "20200101"}}, Rules: []rbacv1.PolicyRule{listSecrets}},
+		},
+		ClusterRoleBindings: []*rbacv1.ClusterRoleBinding{
+			{ObjectMeta: previousMeta(""), Subjects: subjects, RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "audit2rbac:bob"}},
+		},
+		Roles: []*rbacv1.Role{ns1Role},
+		RoleBindings: []*rbacv1.RoleBinding{
+			{ObjectMeta: previousMeta("ns1"), Subjects: subjects, RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "audit2rbac:bob"}},
+			{ObjectMeta: previousMeta("ns2"), Subjects: subjects, RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "audit2rbac:shared"}},
+			// bindings for other subjects are ignored
+			{ObjectMeta: previousMeta("ns4"), Subjects: []rbacv1.Subject{{Kind: "User", APIGroup: rbacv1.GroupName, Name: "alice"}}, RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "audit2rbac:shared"}},
+		},
+	}
+	requests := []authorizer.AttributesRecord{
+		{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", Resource: "pods", Name: "pod1"},
+		{User: bob, ResourceRequest: true, Verb: "get", Resource: "nodes", Name: "node1"},
+		{User: bob, ResourceRequest: true, Verb: "list", Namespace: "ns2", Resource: "secrets"},
+		{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", Resource: "configmaps", Name: "c1"},
+		{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns3", Resource: "services", Name: "s1"},
+	}
+
+	opts := DefaultGenerateOptions()
+	opts.Name = "audit2rbac:bob"
+	generator := NewGenerator(RBACObjects{}, requests, opts)
+	generator.Previous = previous
+	generated := generator.Generate()
+
+	getConfigMap := rbacv1helper.NewRule("get").Groups("").Resources("configmaps").Names("c1").RuleOrDie()
+	getService := rbacv1helper.NewRule("get").Groups("").Resources("services").Names("s1").RuleOrDie()
+	expectedRoles := []*rbacv1.Role{
+		{ObjectMeta: ns1Role.ObjectMeta, Rules: []rbacv1.PolicyRule{getPods, getConfigMap}},
+		{ObjectMeta: previousMeta("ns2"), Rules: []rbacv1.PolicyRule{listSecrets}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac:bob", Namespace: "ns3"}, Rules: []rbacv1.PolicyRule{getService}},
+	}
+	if !equality.Semantic.DeepEqual(expectedRoles, generated.Roles) {
+		t.Errorf("unexpected roles\n%s", diff.ObjectGoPrintSideBySide(expectedRoles, generated.Roles))
+	}
+	expectedClusterRoles := []*rbacv1.ClusterRole{
+		{ObjectMeta: previousMeta(""), Rules: []rbacv1.PolicyRule{getNode1, getNode2}},
+	}
+	if !equality.Semantic.DeepEqual(expectedClusterRoles, generated.ClusterRoles) {
+		t.Errorf("unexpected cluster roles\n%s", diff.ObjectGoPrintSideBySide(expectedClusterRoles, generated.ClusterRoles))
+	}
+	if len(generated.RoleBindings) != 3 || generated.RoleBindings[1].RoleRef.Kind != "Role" || len(generated.ClusterRoleBindings) != 1 {
+		t.Errorf("unexpected bindings %#v, %#v", generated.RoleBindings, generated.ClusterRoleBindings)
+	}
+
+	expectedDiff := []RulesDiff{
+		{Namespace: "ns1", Rules: []rbacv1.PolicyRule{getConfigMap}},
+		{Namespace: "ns3", Rules: []rbacv1.PolicyRule{getService}},
+	}
+	if diffs := DiffRules(&previous, generated); !equality.Semantic.DeepEqual(expectedDiff, diffs) {
+		t.Errorf("unexpected diff\n%s", diff.ObjectGoPrintSideBySide(expectedDiff, diffs))
+	}
+}
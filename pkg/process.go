@@ -2,10 +2,13 @@ package pkg
 
 import (
 	"context"
-	"reflect"
+	"fmt"
+	"strings"
 
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apiserver/pkg/authorization/authorizer"
 	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
 	"k8s.io/kubernetes/pkg/registry/rbac/validation"
@@ -25,6 +28,38 @@ type GenerateOptions struct {
 	VerbExpansions                          map[string][]string
 	ExpandMultipleNamesToUnnamed            bool
 	ExpandMultipleNamespacesToClusterScoped bool
+	// DeprecatedGroups maps resources in deprecated API groups to the API group that replaces them.
+	// Requests for the resource in either group are treated as the same operation when expanding names and namespaces,
+	// and a warning is reported when rules are generated for the deprecated group.
+	DeprecatedGroups map[schema.GroupResource]string
+	// ShareIdenticalNamespacedRoles replaces roles with identical rules in more than one namespace
+	// with a single cluster role, bound in each of those namespaces with role bindings
+	ShareIdenticalNamespacedRoles bool
+	// Discovery, if set, is used to generate rules for cluster-scoped resources in cluster roles,
+	// and to report requests for resources and subresources that were not discovered
+	Discovery *Discovery
+	// ExpandCompleteGroupsToWildcard replaces the resources of a rule with "*" when discovery shows the rule
+	// already includes every resource and subresource in its API groups. Requires Discovery.
+	ExpandCompleteGroupsToWildcard bool
+	// KeepExactNonResourceURLs generates rules for the exact paths of non-resource requests,
+	// instead of using NonResourceURLWildcards and NonResourceURLPrefixThreshold
+	KeepExactNonResourceURLs bool
+	// NonResourceURLWildcards are known-safe paths ending in "*" used in place of any path they match
+	NonResourceURLWildcards []string
+	// NonResourceURLPrefixThreshold is the number of distinct paths under the same prefix that can be requested
+	// with the same verb before rules are generated for <prefix>/* instead. Zero disables collapsing paths.
+	NonResourceURLPrefixThreshold int
+	// RecognizePatterns generates the canonical rules for well-known access patterns, like leader election and
+	// event recording, instead of expanding them, and lists the recognized patterns in the PatternsAnnotation of roles
+	RecognizePatterns bool
+	// EscalationMode determines how permissions required to write the roles and bindings in Generator.Grants are handled.
+	// One of EscalationReport, EscalationAdd, or EscalationBindEscalate.
+	EscalationMode string
+	// Trace records how each request contributed to the generated rules, returned by Generator.Traces
+	Trace bool
+	// NeverGrant are rules matching requests that must never be granted. Requests they match are not granted,
+	// and the parts of generated rules that would allow requests they match are removed and reported as warnings.
+	NeverGrant []RequestRule
 
 	Name        string
 	Labels      map[string]string
@@ -42,6 +77,23 @@ func DefaultGenerateOptions() GenerateOptions {
 		},
 		ExpandMultipleNamesToUnnamed:            true,
 		ExpandMultipleNamespacesToClusterScoped: true,
+		DeprecatedGroups: map[schema.GroupResource]string{
+			{Group: "extensions", Resource: "daemonsets"}:          "apps",
+			{Group: "extensions", Resource: "deployments"}:         "apps",
+			{Group: "extensions", Resource: "replicasets"}:         "apps",
+			{Group: "extensions", Resource: "ingresses"}:           "networking.k8s.io",
+			{Group: "extensions", Resource: "networkpolicies"}:     "networking.k8s.io",
+			{Group: "extensions", Resource: "podsecuritypolicies"}: "policy",
+		},
+		ShareIdenticalNamespacedRoles:  false,
+		Discovery:                      nil,
+		ExpandCompleteGroupsToWildcard: false,
+		KeepExactNonResourceURLs:       false,
+		NonResourceURLWildcards:        []string{"/healthz*", "/livez*", "/readyz*"},
+		NonResourceURLPrefixThreshold:  3,
+		RecognizePatterns:              false,
+		EscalationMode:                 EscalationReport,
+		Trace:                          false,
 
 		Name:        "audit2rbac",
 		Labels:      nil,
@@ -52,112 +104,498 @@ func DefaultGenerateOptions() GenerateOptions {
 // Generator allows generating a set of covering RBAC roles and bindings
 type Generator struct {
 	Options GenerateOptions
+	// Grants are the roles and bindings written by the requests.
+	// The permissions required to write them are handled according to Options.EscalationMode.
+	Grants []Grant
+	// Previous are objects generated by an earlier run for the same subject.
+	// The generated objects start from them, so the result allows everything they allowed.
+	Previous RBACObjects
+	// Ceiling, if set, is the maximum set of permissions to generate.
+	// Requests it does not allow are not granted, and are returned by Exceeded.
+	Ceiling *Ceiling
 
 	existing RBACObjects
 	requests []authorizer.AttributesRecord
 
-	generated       RBACObjects
-	generatedGetter *validation.StaticRoles
+	generated RBACObjects
+	warnings  []string
+	traces    []Trace
+	exceeded  []authorizer.AttributesRecord
 
 	clusterRole           *rbacv1.ClusterRole
 	clusterRoleBinding    *rbacv1.ClusterRoleBinding
 	namespacedRole        map[string]*rbacv1.Role
 	namespacedRoleBinding map[string]*rbacv1.RoleBinding
+
+	// patterns holds the well-known access patterns recognized for the cluster role ("") and namespaced roles
+	patterns map[string]sets.String
+	// previousRules holds the number of rules seeded from previous objects in the cluster role ("") and namespaced roles
+	previousRules map[string]int
 }
 
 // NewGenerator creates a new Generator
 func NewGenerator(existing RBACObjects, requests []authorizer.AttributesRecord, options GenerateOptions) *Generator {
-	_, getter := validation.NewTestRuleResolver(nil, nil, nil, nil)
-
 	return &Generator{
 		existing:              existing,
 		requests:              requests,
 		Options:               options,
 		namespacedRole:        map[string]*rbacv1.Role{},
 		namespacedRoleBinding: map[string]*rbacv1.RoleBinding{},
-		generatedGetter:       getter,
+		patterns:              map[string]sets.String{},
+		previousRules:         map[string]int{},
 	}
 }
 
 // Generate returns a set of RBAC roles and bindings that cover the specified requests
 func (g *Generator) Generate() *RBACObjects {
-	_, existingGetter := validation.NewTestRuleResolver(g.existing.Roles, g.existing.RoleBindings, g.existing.ClusterRoles, g.existing.ClusterRoleBindings)
+	existingResolver, existingGetter := validation.NewTestRuleResolver(g.existing.Roles, g.existing.RoleBindings, g.existing.ClusterRoles, g.existing.ClusterRoleBindings)
 	existingAuthorizer := rbacauthorizer.New(existingGetter, existingGetter, existingGetter, existingGetter)
 
-	generatedAuthorizer := rbacauthorizer.New(g.generatedGetter, g.generatedGetter, g.generatedGetter, g.generatedGetter)
+	g.seedPrevious()
 
 	// sort requests to put broader ones first
 	sortRequests(g.requests)
 
+	patterns := &patternMatcher{}
+	if g.Options.RecognizePatterns {
+		patterns = newPatternMatcher(g.requests)
+	}
+
+	// index the names and namespaces of requests with the same verb/group/resource/subresource
+	siblings := g.indexSiblings(patterns)
+	nonResourceSiblings := g.indexNonResourceSiblings()
+
+	seen := map[requestKey]bool{}
 	for _, request := range g.requests {
+		// identical requests are covered by the first one
+		key := newRequestKey(request)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if request.ResourceRequest && g.Options.Discovery != nil {
+			g.validateResource(request)
+		}
+
 		if decision, _, _ := existingAuthorizer.Authorize(context.Background(), request); decision == authorizer.DecisionAllow {
 			continue
 		}
-		if decision, _, _ := generatedAuthorizer.Authorize(context.Background(), request); decision == authorizer.DecisionAllow {
+
+		original := request
+		reasons := []string{}
+		// requests for cluster-scoped resources (like namespaces) can include a namespace, but are granted by cluster roles,
+		// so the ceiling and never-grant rules are checked cluster-wide
+		if g.clusterScoped(request) {
+			request.Namespace = ""
+			reasons = append(reasons, "allowed cluster-wide, since the resource is cluster-scoped")
+		}
+
+		if g.generatedAllows(request) {
+			g.trace(Trace{Request: original, Namespace: request.Namespace, Reasons: []string{"allowed by rules generated for other requests"}})
+			continue
+		}
+		if !g.ceilingAllowsRequest(request) {
+			g.exceed(original)
+			continue
+		}
+		if g.neverGrantRequest(request) {
 			continue
 		}
 
 		if !request.ResourceRequest {
+			url := g.nonResourceURL(request, nonResourceSiblings)
+			rule := rbacv1helper.NewRule(request.Verb).URLs(url).RuleOrDie()
+			if !g.ceilingAllows("", rule) || !g.neverGrantAllows("", rule) {
+				url = request.Path
+				rule = rbacv1helper.NewRule(request.Verb).URLs(url).RuleOrDie()
+			}
 			clusterRole := g.ensureClusterRoleAndBinding(userToSubject(request.User))
-			clusterRole.Rules = append(clusterRole.Rules, rbacv1helper.NewRule(request.Verb).URLs(request.Path).RuleOrDie())
+			clusterRole.Rules = append(clusterRole.Rules, rule)
+			if url != request.Path {
+				reasons = append(reasons, fmt.Sprintf("allowed %s in place of the requested path", url))
+			}
+			g.trace(Trace{Request: request, Rules: []rbacv1.PolicyRule{rule}, Reasons: reasons})
 			continue
 		}
 
-		requestCopy := request
-		if g.Options.ExpandMultipleNamesToUnnamed {
-			requestCopy.Name = ""
-		}
-		if g.Options.ExpandMultipleNamespacesToClusterScoped {
-			requestCopy.Namespace = ""
-		}
-		requestCopy.Path = ""
-
-		if (request.Namespace != "" && g.Options.ExpandMultipleNamespacesToClusterScoped) || (request.Name != "" && g.Options.ExpandMultipleNamesToUnnamed) {
-			// search for other requests with the same verb/group/resource/subresource that differ only by name/namespace
-			for _, a := range g.requests {
-				differentNamespace := a.Namespace != "" && a.Namespace != request.Namespace
-				differentName := a.Name != "" && a.Name != request.Name
-				if !a.ResourceRequest {
-					continue
+		pattern, patternRules := patterns.match(request)
+
+		// leader election locks are specific to a name and namespace
+		if pattern != LeaderElectionPattern && ((request.Namespace != "" && g.Options.ExpandMultipleNamespacesToClusterScoped) || (request.Name != "" && g.Options.ExpandMultipleNamesToUnnamed)) {
+			// expand if other requests with the same verb/group/resource/subresource differ only by name/namespace
+			s := siblings[g.siblingKey(request)]
+			if g.Options.ExpandMultipleNamespacesToClusterScoped && hasOther(s.namespaces, request.Namespace) {
+				expanded := request
+				expanded.Namespace = ""
+				if !g.neverGrantAllows("", attributesToResourceRule(expanded, GenerateOptions{})) {
+					reasons = append(reasons, "allowed only in the requested namespace, since a never-grant rule matches it cluster-wide")
+				} else if g.ceilingAllows("", attributesToResourceRule(expanded, GenerateOptions{})) {
+					request = expanded
+					reasons = append(reasons, fmt.Sprintf("allowed in any namespace, since it was also requested in namespaces %s", strings.Join(s.namespaces.Difference(sets.NewString(original.Namespace)).List(), ", ")))
+				} else {
+					reasons = append(reasons, "allowed only in the requested namespace, since the ceiling does not allow it in any namespace")
 				}
-				if g.Options.ExpandMultipleNamesToUnnamed {
-					a.Name = ""
-				}
-				if g.Options.ExpandMultipleNamespacesToClusterScoped {
-					a.Namespace = ""
-				}
-				a.Path = ""
-				if reflect.DeepEqual(requestCopy, a) {
-					if g.Options.ExpandMultipleNamespacesToClusterScoped && differentNamespace {
-						request.Namespace = ""
-					}
-					if g.Options.ExpandMultipleNamesToUnnamed && differentName {
-						request.Name = ""
-					}
+			}
+			if g.Options.ExpandMultipleNamesToUnnamed && hasOther(s.names, request.Name) {
+				expanded := request
+				expanded.Name = ""
+				if !g.neverGrantAllows(request.Namespace, attributesToResourceRule(expanded, GenerateOptions{})) {
+					reasons = append(reasons, "allowed only on the requested name, since a never-grant rule matches it on other names")
+				} else if g.ceilingAllows(request.Namespace, attributesToResourceRule(expanded, GenerateOptions{})) {
+					request = expanded
+					reasons = append(reasons, fmt.Sprintf("allowed on any name, since it was also requested for names %s", strings.Join(s.names.Difference(sets.NewString(original.Name)).List(), ", ")))
+				} else {
+					reasons = append(reasons, "allowed only on the requested name, since the ceiling does not allow it on any name")
 				}
 			}
 		}
 
+		if preferredGroup, deprecated := g.Options.DeprecatedGroups[schema.GroupResource{Group: request.APIGroup, Resource: request.Resource}]; deprecated {
+			g.warn(fmt.Sprintf("generated rule for %s.%s uses a deprecated API group, clients should use %s.%s", request.Resource, request.APIGroup, request.Resource, preferredGroup))
+		}
+
+		rules := []rbacv1.PolicyRule{attributesToResourceRule(request, g.Options)}
+		if expansions := g.Options.VerbExpansions[request.Verb]; len(expansions) > 0 && pattern == "" {
+			reasons = append(reasons, fmt.Sprintf("also allowed %s, which are usually requested with %s", strings.Join(expansions, ", "), request.Verb))
+		}
+		if pattern != "" {
+			rules = patternRules
+			reasons = append(reasons, fmt.Sprintf("generated the canonical rules for the %s pattern", pattern))
+		}
+
+		rules, disallowed := g.clampToCeiling(request.Namespace, rules)
+		if len(disallowed) > 0 {
+			reasons = append(reasons, fmt.Sprintf("did not allow %s, since the ceiling does not allow it", disallowed))
+		}
+		rules, denied := g.clampToNeverGrant(request.Namespace, rules)
+		if len(denied) > 0 {
+			reasons = append(reasons, fmt.Sprintf("did not allow %s, since it matches never-grant rules", denied))
+		}
+		// the ceiling may allow the request only in a namespace it cannot be granted in, like a cluster-scoped resource in a role
+		if !rbacauthorizer.RulesAllow(request, rules...) {
+			g.exceed(original)
+			continue
+		}
+
 		if request.Namespace == "" {
 			clusterRole := g.ensureClusterRoleAndBinding(userToSubject(request.User))
-			clusterRole.Rules = append(clusterRole.Rules, attributesToResourceRule(request, g.Options))
+			clusterRole.Rules = append(clusterRole.Rules, rules...)
 		} else {
 			role := g.ensureNamespacedRoleAndBinding(userToSubject(request.User), request.Namespace)
-			role.Rules = append(role.Rules, attributesToResourceRule(request, g.Options))
+			role.Rules = append(role.Rules, rules...)
 		}
+		// the pattern is only recorded once its rules are granted
+		if pattern != "" {
+			if g.patterns[request.Namespace] == nil {
+				g.patterns[request.Namespace] = sets.NewString()
+			}
+			g.patterns[request.Namespace].Insert(pattern)
+		}
+		g.trace(Trace{Request: original, Namespace: request.Namespace, Rules: rules, Reasons: reasons})
 	}
 
+	g.addGrantRequirements(existingResolver, existingAuthorizer)
+
+	g.annotatePatterns()
+
 	// Compact rules
 	for _, role := range g.generated.ClusterRoles {
-		role.Rules = compactRules(role.Rules)
+		role.Rules = g.compactAddedRules(role.Rules, g.previousRules[""], "")
 	}
 	for _, role := range g.generated.Roles {
-		role.Rules = compactRules(role.Rules)
+		role.Rules = g.compactAddedRules(role.Rules, g.previousRules[role.Namespace], role.Namespace)
+	}
+
+	g.warnRulesAboveCeiling()
+
+	if g.Options.ShareIdenticalNamespacedRoles {
+		g.shareIdenticalNamespacedRoles()
 	}
 
 	return &g.generated
 }
 
+// annotatePatterns lists the recognized access patterns in the annotations of the generated roles
+func (g *Generator) annotatePatterns() {
+	for namespace, patterns := range g.patterns {
+		var meta *metav1.ObjectMeta
+		if namespace == "" && g.clusterRole != nil {
+			meta = &g.clusterRole.ObjectMeta
+		} else if role := g.namespacedRole[namespace]; namespace != "" && role != nil {
+			meta = &role.ObjectMeta
+		} else {
+			continue
+		}
+		// the annotations from options are shared by all generated objects
+		annotations := map[string]string{}
+		for k, v := range meta.Annotations {
+			annotations[k] = v
+		}
+		annotations[PatternsAnnotation] = strings.Join(patterns.List(), ",")
+		meta.Annotations = annotations
+	}
+}
+
+// compactAddedRules compacts the rules added after the rules seeded from previous objects,
+// which are kept as they were to minimize changes to the previous objects.
+// The namespace is the namespace of the role, or empty for the cluster role.
+func (g *Generator) compactAddedRules(rules []rbacv1.PolicyRule, previous int, namespace string) []rbacv1.PolicyRule {
+	added := compactRules(rules[previous:])
+	if g.Options.Discovery != nil && g.Options.ExpandCompleteGroupsToWildcard {
+		expanded := expandCompleteGroupsToWildcard(added, g.Options.Discovery, namespace != "")
+		for i := range expanded {
+			if !g.ceilingAllows(namespace, expanded[i]) || !g.neverGrantAllows(namespace, expanded[i]) {
+				expanded[i] = added[i]
+			}
+		}
+		added = compactRules(expanded)
+	}
+	if previous == 0 {
+		return added
+	}
+	return append(rules[:previous:previous], added...)
+}
+
+// shareIdenticalNamespacedRoles replaces namespaced roles that have identical rules in more than one namespace
+// with a single cluster role, and points the role bindings in those namespaces at the cluster role
+func (g *Generator) shareIdenticalNamespacedRoles() {
+	rolesByRules := map[string][]*rbacv1.Role{}
+	for _, role := range g.generated.Roles {
+		key := rulesKey(role.Rules)
+		rolesByRules[key] = append(rolesByRules[key], role)
+	}
+
+	roles := []*rbacv1.Role{}
+	sharedRoles := map[string]*rbacv1.ClusterRole{}
+	for _, role := range g.generated.Roles {
+		key := rulesKey(role.Rules)
+		if len(rolesByRules[key]) < 2 {
+			roles = append(roles, role)
+			continue
+		}
+
+		sharedRole, ok := sharedRoles[key]
+		if !ok {
+			name := g.Options.Name + ":shared"
+			if len(sharedRoles) > 0 {
+				name = fmt.Sprintf("%s:shared:%d", g.Options.Name, len(sharedRoles)+1)
+			}
+			sharedRole = &rbacv1.ClusterRole{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Labels: g.Options.Labels, Annotations: role.Annotations},
+				Rules:      role.Rules,
+			}
+			sharedRoles[key] = sharedRole
+			g.generated.ClusterRoles = append(g.generated.ClusterRoles, sharedRole)
+		}
+
+		g.namespacedRoleBinding[role.Namespace].RoleRef = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: sharedRole.Name}
+		delete(g.namespacedRole, role.Namespace)
+	}
+	g.generated.Roles = roles
+}
+
+// Warnings returns warnings about the generated roles
+func (g *Generator) Warnings() []string {
+	return g.warnings
+}
+
+// Exceeded returns the requests that were not granted because the ceiling does not allow them
+func (g *Generator) Exceeded() []authorizer.AttributesRecord {
+	return g.exceeded
+}
+
+func (g *Generator) exceed(request authorizer.AttributesRecord) {
+	g.exceeded = append(g.exceeded, request)
+	g.trace(Trace{Request: request, Namespace: request.Namespace, Reasons: []string{"not allowed by the ceiling"}})
+}
+
+func (g *Generator) warn(warning string) {
+	for _, w := range g.warnings {
+		if w == warning {
+			return
+		}
+	}
+	g.warnings = append(g.warnings, warning)
+}
+
+// clusterScoped returns true if the request includes a namespace, but is for a resource discovery found to be cluster-scoped
+func (g *Generator) clusterScoped(request authorizer.AttributesRecord) bool {
+	if !request.ResourceRequest || g.Options.Discovery == nil || request.Namespace == "" {
+		return false
+	}
+	namespaced, found := g.Options.Discovery.Resource(request.APIGroup, request.Resource)
+	return found && !namespaced
+}
+
+// validateResource warns if the resource or subresource of the request was not discovered
+func (g *Generator) validateResource(request authorizer.AttributesRecord) {
+	resource := schema.GroupResource{Group: request.APIGroup, Resource: request.Resource}.String()
+	if _, found := g.Options.Discovery.Resource(request.APIGroup, request.Resource); !found {
+		g.warn(fmt.Sprintf("request for %s does not match a discovered resource", resource))
+		return
+	}
+	if request.Subresource == "" {
+		return
+	}
+	if found, known := g.Options.Discovery.Subresource(request.APIGroup, request.Resource, request.Subresource); known && !found {
+		g.warn(fmt.Sprintf("request for %s/%s does not match a discovered subresource", resource, request.Subresource))
+	}
+}
+
+// expandCompleteGroupsToWildcard replaces the resources of unnamed rules with "*"
+// if they include every discovered resource and subresource in the rule's API groups.
+// If namespacedOnly is true, the rules are for a namespaced role, and only need to include namespaced resources.
+func expandCompleteGroupsToWildcard(rules []rbacv1.PolicyRule, discovery *Discovery, namespacedOnly bool) []rbacv1.PolicyRule {
+	expanded := make([]rbacv1.PolicyRule, 0, len(rules))
+	for _, rule := range rules {
+		if len(rule.Resources) > 0 && len(rule.ResourceNames) == 0 && coversGroups(rule, discovery, namespacedOnly) {
+			rule = *rule.DeepCopy()
+			rule.Resources = []string{rbacv1.ResourceAll}
+		}
+		expanded = append(expanded, rule)
+	}
+	return expanded
+}
+
+func coversGroups(rule rbacv1.PolicyRule, discovery *Discovery, namespacedOnly bool) bool {
+	resources := sets.NewString(rule.Resources...)
+	if resources.Has(rbacv1.ResourceAll) {
+		return false
+	}
+	for _, group := range rule.APIGroups {
+		if group == rbacv1.APIGroupAll {
+			return false
+		}
+		discovered, complete := discovery.Resources(group, namespacedOnly)
+		if !complete || !resources.IsSuperset(discovered) {
+			return false
+		}
+	}
+	return true
+}
+
+// generatedAllows returns true if the roles generated so far allow the request
+func (g *Generator) generatedAllows(request authorizer.AttributesRecord) bool {
+	subject := userToSubject(request.User)
+	if g.clusterRole != nil && g.clusterRoleBinding.Subjects[0] == subject && rbacauthorizer.RulesAllow(request, g.clusterRole.Rules...) {
+		return true
+	}
+	if role := g.namespacedRole[request.Namespace]; role != nil && g.namespacedRoleBinding[request.Namespace].Subjects[0] == subject && rbacauthorizer.RulesAllow(request, role.Rules...) {
+		return true
+	}
+	return false
+}
+
+// requestSiblings holds the distinct non-empty names and namespaces of requests that share a sibling key
+type requestSiblings struct {
+	names      sets.String
+	namespaces sets.String
+}
+
+// siblingKey returns the key of the request with the name and/or namespace cleared,
+// according to whether the generator expands multiple names and namespaces,
+// and with deprecated API groups replaced by their preferred group
+func (g *Generator) siblingKey(request authorizer.AttributesRecord) requestKey {
+	if preferredGroup, deprecated := g.Options.DeprecatedGroups[schema.GroupResource{Group: request.APIGroup, Resource: request.Resource}]; deprecated {
+		request.APIGroup = preferredGroup
+	}
+	if g.Options.ExpandMultipleNamesToUnnamed {
+		request.Name = ""
+	}
+	if g.Options.ExpandMultipleNamespacesToClusterScoped {
+		request.Namespace = ""
+	}
+	request.Path = ""
+	return newRequestKey(request)
+}
+
+// indexSiblings indexes the names and namespaces of resource requests by sibling key.
+// Leader election locks are not expanded, and are not considered siblings of other requests.
+func (g *Generator) indexSiblings(patterns *patternMatcher) map[requestKey]*requestSiblings {
+	siblings := map[requestKey]*requestSiblings{}
+	for _, request := range g.requests {
+		if !request.ResourceRequest || patterns.isLock(request) {
+			continue
+		}
+		if g.clusterScoped(request) {
+			request.Namespace = ""
+		}
+		key := g.siblingKey(request)
+		s, ok := siblings[key]
+		if !ok {
+			s = &requestSiblings{names: sets.NewString(), namespaces: sets.NewString()}
+			siblings[key] = s
+		}
+		if request.Name != "" {
+			s.names.Insert(request.Name)
+		}
+		if request.Namespace != "" {
+			s.namespaces.Insert(request.Namespace)
+		}
+	}
+	return siblings
+}
+
+// nonResourceSiblingKey identifies non-resource requests made by the same user with the same verb under the same path prefix
+type nonResourceSiblingKey struct {
+	user   string
+	verb   string
+	prefix string
+}
+
+func newNonResourceSiblingKey(request authorizer.AttributesRecord) (nonResourceSiblingKey, bool) {
+	i := strings.LastIndex(request.Path, "/")
+	if i <= 0 {
+		// never collapse top-level paths to "/*"
+		return nonResourceSiblingKey{}, false
+	}
+	key := nonResourceSiblingKey{verb: request.Verb, prefix: request.Path[:i]}
+	if request.User != nil {
+		key.user = request.User.GetName()
+	}
+	return key, true
+}
+
+// indexNonResourceSiblings indexes the distinct paths of non-resource requests by sibling key
+func (g *Generator) indexNonResourceSiblings() map[nonResourceSiblingKey]sets.String {
+	siblings := map[nonResourceSiblingKey]sets.String{}
+	if g.Options.KeepExactNonResourceURLs || g.Options.NonResourceURLPrefixThreshold <= 0 {
+		return siblings
+	}
+	for _, request := range g.requests {
+		if request.ResourceRequest {
+			continue
+		}
+		key, ok := newNonResourceSiblingKey(request)
+		if !ok {
+			continue
+		}
+		if siblings[key] == nil {
+			siblings[key] = sets.NewString()
+		}
+		siblings[key].Insert(request.Path)
+	}
+	return siblings
+}
+
+// nonResourceURL returns the path to generate a rule for, using a known-safe wildcard that matches the request path,
+// or <prefix>/* if more than NonResourceURLPrefixThreshold paths under the same prefix were requested with the same verb
+func (g *Generator) nonResourceURL(request authorizer.AttributesRecord, siblings map[nonResourceSiblingKey]sets.String) string {
+	if g.Options.KeepExactNonResourceURLs {
+		return request.Path
+	}
+	for _, wildcard := range g.Options.NonResourceURLWildcards {
+		if strings.HasSuffix(wildcard, "*") && strings.HasPrefix(request.Path, strings.TrimSuffix(wildcard, "*")) {
+			return wildcard
+		}
+	}
+	if key, ok := newNonResourceSiblingKey(request); ok && g.Options.NonResourceURLPrefixThreshold > 0 && siblings[key].Len() > g.Options.NonResourceURLPrefixThreshold {
+		return key.prefix + "/*"
+	}
+	return request.Path
+}
+
 func (g *Generator) ensureClusterRoleAndBinding(subject rbacv1.Subject) *rbacv1.ClusterRole {
 	if g.clusterRole != nil {
 		return g.clusterRole
@@ -175,9 +613,6 @@ func (g *Generator) ensureClusterRoleAndBinding(subject rbacv1.Subject) *rbacv1.
 	g.generated.ClusterRoles = append(g.generated.ClusterRoles, g.clusterRole)
 	g.generated.ClusterRoleBindings = append(g.generated.ClusterRoleBindings, g.clusterRoleBinding)
 
-	_, regeneratedGetter := validation.NewTestRuleResolver(g.generated.Roles, g.generated.RoleBindings, g.generated.ClusterRoles, g.generated.ClusterRoleBindings)
-	*g.generatedGetter = *regeneratedGetter
-
 	return g.clusterRole
 }
 
@@ -198,8 +633,5 @@ func (g *Generator) ensureNamespacedRoleAndBinding(subject rbacv1.Subject, names
 	g.generated.Roles = append(g.generated.Roles, g.namespacedRole[namespace])
 	g.generated.RoleBindings = append(g.generated.RoleBindings, g.namespacedRoleBinding[namespace])
 
-	_, regeneratedGetter := validation.NewTestRuleResolver(g.generated.Roles, g.generated.RoleBindings, g.generated.ClusterRoles, g.generated.ClusterRoleBindings)
-	*g.generatedGetter = *regeneratedGetter
-
 	return g.namespacedRole[namespace]
 }
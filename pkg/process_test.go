@@ -1,17 +1,26 @@
 package pkg
 
 import (
+	"context"
 	"fmt"
+	"math/rand"
 	"os"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/apiserver/pkg/authorization/authorizer"
+	helpervalidation "k8s.io/component-helpers/auth/rbac/validation"
 	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+	"k8s.io/kubernetes/pkg/registry/rbac/validation"
+	rbacauthorizer "k8s.io/kubernetes/plugin/pkg/auth/authorizer/rbac"
 )
 
 func TestProcessOptions(t *testing.T) {
@@ -86,6 +95,70 @@ func TestProcessOptions(t *testing.T) {
 			},
 		},
 
+		{
+			name: "nonresource paths",
+			opts: DefaultGenerateOptions(),
+			requests: []authorizer.AttributesRecord{
+				authorizer.AttributesRecord{User: bob, ResourceRequest: false, Verb: "get", Path: "/foo"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: false, Verb: "get", Path: "/livez/ping"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: false, Verb: "get", Path: "/logs/a.log"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: false, Verb: "get", Path: "/logs/b.log"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: false, Verb: "get", Path: "/metrics/cadvisor"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: false, Verb: "get", Path: "/metrics/probes"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: false, Verb: "get", Path: "/metrics/resource"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: false, Verb: "get", Path: "/metrics/slis"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: false, Verb: "post", Path: "/metrics/slis"},
+			},
+			expected: RBACObjects{
+				ClusterRoles: []*rbacv1.ClusterRole{&rbacv1.ClusterRole{
+					ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac"},
+					Rules: []rbacv1.PolicyRule{
+						rbacv1helper.NewRule("get").URLs("/foo", "/livez*", "/logs/a.log", "/logs/b.log", "/metrics/*").RuleOrDie(),
+						rbacv1helper.NewRule("post").URLs("/metrics/slis").RuleOrDie(),
+					},
+				}},
+				ClusterRoleBindings: []*rbacv1.ClusterRoleBinding{&rbacv1.ClusterRoleBinding{
+					ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac"},
+					RoleRef:    rbacv1.RoleRef{Name: "audit2rbac", Kind: "ClusterRole", APIGroup: "rbac.authorization.k8s.io"},
+					Subjects:   []rbacv1.Subject{{Name: "bob", Kind: "User", APIGroup: "rbac.authorization.k8s.io"}},
+				}},
+			},
+		},
+
+		{
+			name: "nonresource exact paths",
+			opts: func() GenerateOptions {
+				opts := DefaultGenerateOptions()
+				opts.KeepExactNonResourceURLs = true
+				return opts
+			}(),
+			requests: []authorizer.AttributesRecord{
+				authorizer.AttributesRecord{User: bob, ResourceRequest: false, Verb: "get", Path: "/foo"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: false, Verb: "get", Path: "/livez/ping"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: false, Verb: "get", Path: "/logs/a.log"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: false, Verb: "get", Path: "/logs/b.log"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: false, Verb: "get", Path: "/metrics/cadvisor"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: false, Verb: "get", Path: "/metrics/probes"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: false, Verb: "get", Path: "/metrics/resource"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: false, Verb: "get", Path: "/metrics/slis"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: false, Verb: "post", Path: "/metrics/slis"},
+			},
+			expected: RBACObjects{
+				ClusterRoles: []*rbacv1.ClusterRole{&rbacv1.ClusterRole{
+					ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac"},
+					Rules: []rbacv1.PolicyRule{
+						rbacv1helper.NewRule("get").URLs("/foo", "/livez/ping", "/logs/a.log", "/logs/b.log", "/metrics/cadvisor", "/metrics/probes", "/metrics/resource").RuleOrDie(),
+						rbacv1helper.NewRule("get", "post").URLs("/metrics/slis").RuleOrDie(),
+					},
+				}},
+				ClusterRoleBindings: []*rbacv1.ClusterRoleBinding{&rbacv1.ClusterRoleBinding{
+					ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac"},
+					RoleRef:    rbacv1.RoleRef{Name: "audit2rbac", Kind: "ClusterRole", APIGroup: "rbac.authorization.k8s.io"},
+					Subjects:   []rbacv1.Subject{{Name: "bob", Kind: "User", APIGroup: "rbac.authorization.k8s.io"}},
+				}},
+			},
+		},
+
 		{
 			name: "cluster-scoped named resource",
 			opts: DefaultGenerateOptions(),
@@ -468,6 +541,86 @@ func TestProcessOptions(t *testing.T) {
 			},
 		},
 
+		{
+			name: "versions and deprecated groups",
+			opts: DefaultGenerateOptions(),
+			requests: []authorizer.AttributesRecord{
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "apps", APIVersion: "v1", Resource: "deployments", Name: "dep1"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "apps", APIVersion: "v1beta2", Resource: "deployments", Name: "dep1"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "extensions", APIVersion: "v1beta1", Resource: "deployments", Name: "dep2"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "", APIVersion: "v1", Resource: "configmaps", Name: "cm1"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "", APIVersion: "v2", Resource: "configmaps", Name: "cm1"},
+			},
+			expected: RBACObjects{
+				Roles: []*rbacv1.Role{
+					&rbacv1.Role{
+						ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac", Namespace: "ns1"},
+						Rules: []rbacv1.PolicyRule{
+							rbacv1helper.NewRule("get").Groups("").Resources("configmaps").Names("cm1").RuleOrDie(),
+							rbacv1helper.NewRule("get").Groups("apps", "extensions").Resources("deployments").RuleOrDie(),
+						},
+					},
+				},
+				RoleBindings: []*rbacv1.RoleBinding{
+					&rbacv1.RoleBinding{
+						ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac", Namespace: "ns1"},
+						RoleRef:    rbacv1.RoleRef{Name: "audit2rbac", Kind: "Role", APIGroup: "rbac.authorization.k8s.io"},
+						Subjects:   []rbacv1.Subject{{Name: "bob", Kind: "User", APIGroup: "rbac.authorization.k8s.io"}},
+					},
+				},
+			},
+		},
+
+		{
+			name: "identical namespaced roles shared with cluster role",
+			opts: func() GenerateOptions {
+				opts := DefaultGenerateOptions()
+				opts.ExpandMultipleNamespacesToClusterScoped = false
+				opts.ShareIdenticalNamespacedRoles = true
+				return opts
+			}(),
+			requests: []authorizer.AttributesRecord{
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "", Resource: "configmaps", Name: "cm1"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "list", Namespace: "ns2", APIGroup: "", Resource: "pods"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "list", Namespace: "ns3", APIGroup: "", Resource: "pods"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "list", Namespace: "ns4", APIGroup: "", Resource: "pods"},
+			},
+			expected: RBACObjects{
+				ClusterRoles: []*rbacv1.ClusterRole{&rbacv1.ClusterRole{
+					ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac:shared"},
+					Rules:      []rbacv1.PolicyRule{rbacv1helper.NewRule("get", "list", "watch").Groups("").Resources("pods").RuleOrDie()},
+				}},
+				Roles: []*rbacv1.Role{
+					&rbacv1.Role{
+						ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac", Namespace: "ns1"},
+						Rules:      []rbacv1.PolicyRule{rbacv1helper.NewRule("get").Groups("").Resources("configmaps").Names("cm1").RuleOrDie()},
+					},
+				},
+				RoleBindings: []*rbacv1.RoleBinding{
+					&rbacv1.RoleBinding{
+						ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac", Namespace: "ns2"},
+						RoleRef:    rbacv1.RoleRef{Name: "audit2rbac:shared", Kind: "ClusterRole", APIGroup: "rbac.authorization.k8s.io"},
+						Subjects:   []rbacv1.Subject{{Name: "bob", Kind: "User", APIGroup: "rbac.authorization.k8s.io"}},
+					},
+					&rbacv1.RoleBinding{
+						ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac", Namespace: "ns3"},
+						RoleRef:    rbacv1.RoleRef{Name: "audit2rbac:shared", Kind: "ClusterRole", APIGroup: "rbac.authorization.k8s.io"},
+						Subjects:   []rbacv1.Subject{{Name: "bob", Kind: "User", APIGroup: "rbac.authorization.k8s.io"}},
+					},
+					&rbacv1.RoleBinding{
+						ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac", Namespace: "ns4"},
+						RoleRef:    rbacv1.RoleRef{Name: "audit2rbac:shared", Kind: "ClusterRole", APIGroup: "rbac.authorization.k8s.io"},
+						Subjects:   []rbacv1.Subject{{Name: "bob", Kind: "User", APIGroup: "rbac.authorization.k8s.io"}},
+					},
+					&rbacv1.RoleBinding{
+						ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac", Namespace: "ns1"},
+						RoleRef:    rbacv1.RoleRef{Name: "audit2rbac", Kind: "Role", APIGroup: "rbac.authorization.k8s.io"},
+						Subjects:   []rbacv1.Subject{{Name: "bob", Kind: "User", APIGroup: "rbac.authorization.k8s.io"}},
+					},
+				},
+			},
+		},
+
 		{
 			name: "namespaced named resources without name or namespace expansion with covering cluster operation",
 			opts: func() GenerateOptions {
@@ -531,6 +684,291 @@ func TestProcessOptions(t *testing.T) {
 	}
 }
 
+func TestGenerateWarnings(t *testing.T) {
+	bob := &user.DefaultInfo{Name: "bob", Groups: []string{"system:authenticated"}}
+	requests := []authorizer.AttributesRecord{
+		authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "extensions", Resource: "deployments", Name: "dep1"},
+		authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "extensions", Resource: "deployments", Name: "dep2"},
+		authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "list", Namespace: "ns1", APIGroup: "apps", Resource: "deployments"},
+	}
+	generator := NewGenerator(RBACObjects{}, requests, DefaultGenerateOptions())
+	generator.Generate()
+
+	expected := []string{"generated rule for deployments.extensions uses a deprecated API group, clients should use deployments.apps"}
+	if !reflect.DeepEqual(expected, generator.Warnings()) {
+		t.Errorf("unexpected warnings\n%s", diff.ObjectGoPrintSideBySide(expected, generator.Warnings()))
+	}
+}
+
+func TestGenerateWithDiscovery(t *testing.T) {
+	bob := &user.DefaultInfo{Name: "bob", Groups: []string{"system:authenticated"}}
+	discovery := NewDiscovery()
+	if err := discovery.Load(strings.NewReader(testDiscoveryDocuments)); err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		name         string
+		expand       bool
+		neverGrant   []RequestRule
+		requests     []authorizer.AttributesRecord
+		clusterRules []rbacv1.PolicyRule
+		roleRules    map[string][]rbacv1.PolicyRule
+		warnings     []string
+	}{
+		{
+			name: "cluster-scoped resources",
+			requests: []authorizer.AttributesRecord{
+				// requests for a namespace include the namespace
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "", Resource: "namespaces", Name: "ns1"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "", Resource: "pods", Name: "pod1"},
+			},
+			clusterRules: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("get").Groups("").Resources("namespaces").Names("ns1").RuleOrDie(),
+			},
+			roleRules: map[string][]rbacv1.PolicyRule{
+				"ns1": {rbacv1helper.NewRule("get").Groups("").Resources("pods").Names("pod1").RuleOrDie()},
+			},
+		},
+		{
+			name:       "cluster-scoped resources in a namespace match cluster-wide never-grant rules",
+			neverGrant: []RequestRule{{PolicyRule: rbacv1helper.NewRule("get").Groups("").Resources("namespaces").RuleOrDie(), ClusterWide: true}},
+			requests: []authorizer.AttributesRecord{
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "", Resource: "namespaces", Name: "ns1"},
+			},
+			warnings: []string{`did not grant {APIGroups:[""], Resources:["namespaces"], ResourceNames:["ns1"], Verbs:["get"]} cluster-wide, which matches never-grant rule {APIGroups:[""], Resources:["namespaces"], Verbs:["get"]} cluster-wide`},
+		},
+		{
+			name: "unknown resources",
+			requests: []authorizer.AttributesRecord{
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "", Resource: "pods", Subresource: "status", Name: "pod1"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "example.com", Resource: "widgets", Name: "w1"},
+			},
+			roleRules: map[string][]rbacv1.PolicyRule{
+				"ns1": {
+					rbacv1helper.NewRule("get").Groups("").Resources("pods/status").Names("pod1").RuleOrDie(),
+					rbacv1helper.NewRule("get").Groups("example.com").Resources("widgets").Names("w1").RuleOrDie(),
+				},
+			},
+			warnings: []string{
+				"request for pods/status does not match a discovered subresource",
+				"request for widgets.example.com does not match a discovered resource",
+			},
+		},
+		{
+			name:   "incomplete resources are not expanded to wildcard",
+			expand: true,
+			requests: []authorizer.AttributesRecord{
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "list", Namespace: "ns1", APIGroup: "", Resource: "configmaps"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "list", Namespace: "ns1", APIGroup: "", Resource: "pods"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "list", Namespace: "ns1", APIGroup: "", Resource: "pods", Subresource: "log"},
+			},
+			roleRules: map[string][]rbacv1.PolicyRule{
+				// namespaces can be read within the namespace they name, so "*" would grant more than was requested
+				"ns1": {rbacv1helper.NewRule("get", "list", "watch").Groups("").Resources("configmaps", "pods", "pods/log").RuleOrDie()},
+			},
+		},
+		{
+			name:   "complete resources expanded to wildcard",
+			expand: true,
+			requests: []authorizer.AttributesRecord{
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "list", Namespace: "ns1", APIGroup: "apps", Resource: "deployments"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "list", APIGroup: "", Resource: "configmaps"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "list", APIGroup: "", Resource: "pods"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "list", APIGroup: "", Resource: "pods", Subresource: "log"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "list", APIGroup: "", Resource: "namespaces"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "list", APIGroup: "", Resource: "namespaces", Subresource: "status"},
+			},
+			clusterRules: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("get", "list", "watch").Groups("").Resources("*").RuleOrDie(),
+			},
+			roleRules: map[string][]rbacv1.PolicyRule{
+				"ns1": {rbacv1helper.NewRule("get", "list", "watch").Groups("apps").Resources("*").RuleOrDie()},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultGenerateOptions()
+			opts.Discovery = discovery
+			opts.ExpandCompleteGroupsToWildcard = tc.expand
+			opts.NeverGrant = tc.neverGrant
+			generator := NewGenerator(RBACObjects{}, tc.requests, opts)
+			generated := generator.Generate()
+
+			var clusterRules []rbacv1.PolicyRule
+			for _, role := range generated.ClusterRoles {
+				clusterRules = append(clusterRules, role.Rules...)
+			}
+			if !equality.Semantic.DeepEqual(tc.clusterRules, clusterRules) {
+				t.Errorf("unexpected cluster role rules\n%s", diff.ObjectGoPrintSideBySide(tc.clusterRules, clusterRules))
+			}
+			roleRules := map[string][]rbacv1.PolicyRule{}
+			for _, role := range generated.Roles {
+				roleRules[role.Namespace] = role.Rules
+			}
+			if tc.roleRules == nil {
+				tc.roleRules = map[string][]rbacv1.PolicyRule{}
+			}
+			if !equality.Semantic.DeepEqual(tc.roleRules, roleRules) {
+				t.Errorf("unexpected role rules\n%s", diff.ObjectGoPrintSideBySide(tc.roleRules, roleRules))
+			}
+			if !reflect.DeepEqual(tc.warnings, generator.Warnings()) {
+				t.Errorf("unexpected warnings\n%s", diff.ObjectGoPrintSideBySide(tc.warnings, generator.Warnings()))
+			}
+		})
+	}
+}
+
+func TestGeneratePatterns(t *testing.T) {
+	bob := &user.DefaultInfo{Name: "bob", Groups: []string{"system:authenticated"}}
+
+	testcases := []struct {
+		name         string
+		disabled     bool
+		ceiling      *Ceiling
+		discovery    string
+		requests     []authorizer.AttributesRecord
+		clusterRules []rbacv1.PolicyRule
+		roleRules    map[string][]rbacv1.PolicyRule
+		annotations  map[string]string
+	}{
+		{
+			name: "leader election",
+			requests: []authorizer.AttributesRecord{
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "coordination.k8s.io", Resource: "leases", Name: "lock"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "update", Namespace: "ns1", APIGroup: "coordination.k8s.io", Resource: "leases", Name: "lock"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns2", APIGroup: "coordination.k8s.io", Resource: "leases", Name: "lock"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "update", Namespace: "ns2", APIGroup: "coordination.k8s.io", Resource: "leases", Name: "lock"},
+				// the lock is not a sibling of other configmaps
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "", Resource: "configmaps", Name: "lock"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "update", Namespace: "ns1", APIGroup: "", Resource: "configmaps", Name: "lock"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "update", Namespace: "ns1", APIGroup: "", Resource: "configmaps", Name: "cm1"},
+			},
+			roleRules: map[string][]rbacv1.PolicyRule{
+				"ns1": {
+					rbacv1helper.NewRule("get", "patch", "update").Groups("").Resources("configmaps").Names("cm1").RuleOrDie(),
+					// configmaps are only allowed to be created if they were
+					rbacv1helper.NewRule("get", "update").Groups("").Resources("configmaps").Names("lock").RuleOrDie(),
+					rbacv1helper.NewRule("get", "update").Groups("coordination.k8s.io").Resources("leases").Names("lock").RuleOrDie(),
+					rbacv1helper.NewRule("create").Groups("coordination.k8s.io").Resources("leases").RuleOrDie(),
+				},
+				"ns2": {
+					rbacv1helper.NewRule("get", "update").Groups("coordination.k8s.io").Resources("leases").Names("lock").RuleOrDie(),
+					rbacv1helper.NewRule("create").Groups("coordination.k8s.io").Resources("leases").RuleOrDie(),
+				},
+			},
+			annotations: map[string]string{"ns1": LeaderElectionPattern, "ns2": LeaderElectionPattern},
+		},
+		{
+			name: "leader election creating a configmap",
+			requests: []authorizer.AttributesRecord{
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "create", Namespace: "ns1", APIGroup: "", Resource: "configmaps"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "", Resource: "configmaps", Name: "lock"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "update", Namespace: "ns1", APIGroup: "", Resource: "configmaps", Name: "lock"},
+			},
+			roleRules: map[string][]rbacv1.PolicyRule{
+				"ns1": {
+					rbacv1helper.NewRule("get", "update").Groups("").Resources("configmaps").Names("lock").RuleOrDie(),
+					rbacv1helper.NewRule("create").Groups("").Resources("configmaps").RuleOrDie(),
+				},
+			},
+			annotations: map[string]string{"ns1": LeaderElectionPattern},
+		},
+		{
+			name:     "leader election without recognizing patterns",
+			disabled: true,
+			requests: []authorizer.AttributesRecord{
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", APIGroup: "coordination.k8s.io", Resource: "leases", Name: "lock"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "update", Namespace: "ns1", APIGroup: "coordination.k8s.io", Resource: "leases", Name: "lock"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns2", APIGroup: "coordination.k8s.io", Resource: "leases", Name: "lock"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "update", Namespace: "ns2", APIGroup: "coordination.k8s.io", Resource: "leases", Name: "lock"},
+			},
+			clusterRules: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("get", "patch", "update").Groups("coordination.k8s.io").Resources("leases").Names("lock").RuleOrDie(),
+			},
+			roleRules:   map[string][]rbacv1.PolicyRule{},
+			annotations: map[string]string{},
+		},
+		{
+			name: "event recording",
+			requests: []authorizer.AttributesRecord{
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "create", Namespace: "ns1", APIGroup: "", Resource: "events"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "patch", Namespace: "ns1", APIGroup: "", Resource: "events", Name: "pod1.1234"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "create", Namespace: "ns2", APIGroup: "events.k8s.io", Resource: "events"},
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "create", Namespace: "ns3", APIGroup: "events.k8s.io", Resource: "events"},
+			},
+			clusterRules: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("create", "patch").Groups("events.k8s.io").Resources("events").RuleOrDie(),
+			},
+			roleRules: map[string][]rbacv1.PolicyRule{
+				"ns1": {rbacv1helper.NewRule("create", "patch").Groups("").Resources("events").RuleOrDie()},
+			},
+			annotations: map[string]string{"": EventRecordingPattern, "ns1": EventRecordingPattern},
+		},
+		{
+			// the ceiling allows the pattern in the namespace, but it can only be granted cluster-wide
+			name: "event recording exceeding the ceiling",
+			ceiling: NewCeiling(&RBACObjects{Roles: []*rbacv1.Role{{
+				ObjectMeta: metav1.ObjectMeta{Name: "ceiling", Namespace: "ns1"},
+				Rules:      []rbacv1.PolicyRule{rbacv1helper.NewRule("create", "patch", "update").Groups("").Resources("events").RuleOrDie()},
+			}}}),
+			discovery: `{"kind":"APIResourceList","groupVersion":"v1","resources":[{"name":"events","namespaced":false,"kind":"Event","verbs":["create"]}]}`,
+			requests: []authorizer.AttributesRecord{
+				authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "create", Namespace: "ns1", APIGroup: "", Resource: "events"},
+			},
+			roleRules:   map[string][]rbacv1.PolicyRule{},
+			annotations: map[string]string{},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultGenerateOptions()
+			opts.RecognizePatterns = !tc.disabled
+			opts.Annotations = map[string]string{"a": "b"}
+			if len(tc.discovery) > 0 {
+				opts.Discovery = NewDiscovery()
+				if err := opts.Discovery.Load(strings.NewReader(tc.discovery)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			generator := NewGenerator(RBACObjects{}, tc.requests, opts)
+			generator.Ceiling = tc.ceiling
+			generated := generator.Generate()
+
+			var clusterRules []rbacv1.PolicyRule
+			annotations := map[string]string{}
+			for _, role := range generated.ClusterRoles {
+				clusterRules = append(clusterRules, role.Rules...)
+				if patterns, ok := role.Annotations[PatternsAnnotation]; ok {
+					annotations[""] = patterns
+				}
+			}
+			roleRules := map[string][]rbacv1.PolicyRule{}
+			for _, role := range generated.Roles {
+				roleRules[role.Namespace] = role.Rules
+				if patterns, ok := role.Annotations[PatternsAnnotation]; ok {
+					annotations[role.Namespace] = patterns
+				}
+			}
+			if !equality.Semantic.DeepEqual(tc.clusterRules, clusterRules) {
+				t.Errorf("unexpected cluster role rules\n%s", diff.ObjectGoPrintSideBySide(tc.clusterRules, clusterRules))
+			}
+			if !equality.Semantic.DeepEqual(tc.roleRules, roleRules) {
+				t.Errorf("unexpected role rules\n%s", diff.ObjectGoPrintSideBySide(tc.roleRules, roleRules))
+			}
+			if !reflect.DeepEqual(tc.annotations, annotations) {
+				t.Errorf("unexpected pattern annotations\n%s", diff.ObjectGoPrintSideBySide(tc.annotations, annotations))
+			}
+			if !reflect.DeepEqual(opts.Annotations, map[string]string{"a": "b"}) {
+				t.Errorf("annotations from options were modified: %v", opts.Annotations)
+			}
+		})
+	}
+}
+
 func TestProcess(t *testing.T) {
 	bob := &user.DefaultInfo{Name: "bob", Groups: []string{"system:authenticated"}}
 	existing := RBACObjects{
@@ -619,3 +1057,341 @@ func TestProcess(t *testing.T) {
 		fmt.Println()
 	}
 }
+
+// pairwiseGenerator is the original generation algorithm, which compares every uncovered request to every other request,
+// and compacts rules by accumulating them along names or resources. It is used to verify the indexed implementation.
+type pairwiseGenerator struct {
+	Options GenerateOptions
+
+	// compactRules compacts the rules of each generated role, pairwiseCompactRules by default
+	compactRules func([]rbacv1.PolicyRule) []rbacv1.PolicyRule
+
+	existing RBACObjects
+	requests []authorizer.AttributesRecord
+
+	generated       RBACObjects
+	generatedGetter *validation.StaticRoles
+
+	clusterRole           *rbacv1.ClusterRole
+	clusterRoleBinding    *rbacv1.ClusterRoleBinding
+	namespacedRole        map[string]*rbacv1.Role
+	namespacedRoleBinding map[string]*rbacv1.RoleBinding
+}
+
+func newPairwiseGenerator(existing RBACObjects, requests []authorizer.AttributesRecord, options GenerateOptions) *pairwiseGenerator {
+	_, getter := validation.NewTestRuleResolver(nil, nil, nil, nil)
+
+	return &pairwiseGenerator{
+		existing:              existing,
+		requests:              requests,
+		Options:               options,
+		compactRules:          pairwiseCompactRules,
+		namespacedRole:        map[string]*rbacv1.Role{},
+		namespacedRoleBinding: map[string]*rbacv1.RoleBinding{},
+		generatedGetter:       getter,
+	}
+}
+
+func (g *pairwiseGenerator) Generate() *RBACObjects {
+	_, existingGetter := validation.NewTestRuleResolver(g.existing.Roles, g.existing.RoleBindings, g.existing.ClusterRoles, g.existing.ClusterRoleBindings)
+	existingAuthorizer := rbacauthorizer.New(existingGetter, existingGetter, existingGetter, existingGetter)
+
+	generatedAuthorizer := rbacauthorizer.New(g.generatedGetter, g.generatedGetter, g.generatedGetter, g.generatedGetter)
+
+	// sort requests to put broader ones first
+	sortRequests(g.requests)
+
+	for _, request := range g.requests {
+		if decision, _, _ := existingAuthorizer.Authorize(context.Background(), request); decision == authorizer.DecisionAllow {
+			continue
+		}
+		if decision, _, _ := generatedAuthorizer.Authorize(context.Background(), request); decision == authorizer.DecisionAllow {
+			continue
+		}
+
+		if !request.ResourceRequest {
+			clusterRole := g.ensureClusterRoleAndBinding(userToSubject(request.User))
+			clusterRole.Rules = append(clusterRole.Rules, rbacv1helper.NewRule(request.Verb).URLs(request.Path).RuleOrDie())
+			continue
+		}
+
+		requestCopy := request
+		if g.Options.ExpandMultipleNamesToUnnamed {
+			requestCopy.Name = ""
+		}
+		if g.Options.ExpandMultipleNamespacesToClusterScoped {
+			requestCopy.Namespace = ""
+		}
+		requestCopy.Path = ""
+
+		if (request.Namespace != "" && g.Options.ExpandMultipleNamespacesToClusterScoped) || (request.Name != "" && g.Options.ExpandMultipleNamesToUnnamed) {
+			// search for other requests with the same verb/group/resource/subresource that differ only by name/namespace
+			for _, a := range g.requests {
+				differentNamespace := a.Namespace != "" && a.Namespace != request.Namespace
+				differentName := a.Name != "" && a.Name != request.Name
+				if !a.ResourceRequest {
+					continue
+				}
+				if g.Options.ExpandMultipleNamesToUnnamed {
+					a.Name = ""
+				}
+				if g.Options.ExpandMultipleNamespacesToClusterScoped {
+					a.Namespace = ""
+				}
+				a.Path = ""
+				if reflect.DeepEqual(requestCopy, a) {
+					if g.Options.ExpandMultipleNamespacesToClusterScoped && differentNamespace {
+						request.Namespace = ""
+					}
+					if g.Options.ExpandMultipleNamesToUnnamed && differentName {
+						request.Name = ""
+					}
+				}
+			}
+		}
+
+		if request.Namespace == "" {
+			clusterRole := g.ensureClusterRoleAndBinding(userToSubject(request.User))
+			clusterRole.Rules = append(clusterRole.Rules, attributesToResourceRule(request, g.Options))
+		} else {
+			role := g.ensureNamespacedRoleAndBinding(userToSubject(request.User), request.Namespace)
+			role.Rules = append(role.Rules, attributesToResourceRule(request, g.Options))
+		}
+	}
+
+	// Compact rules
+	for _, role := range g.generated.ClusterRoles {
+		role.Rules = g.compactRules(role.Rules)
+	}
+	for _, role := range g.generated.Roles {
+		role.Rules = g.compactRules(role.Rules)
+	}
+
+	return &g.generated
+}
+
+func (g *pairwiseGenerator) ensureClusterRoleAndBinding(subject rbacv1.Subject) *rbacv1.ClusterRole {
+	if g.clusterRole != nil {
+		return g.clusterRole
+	}
+
+	g.clusterRole = &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: g.Options.Name, Labels: g.Options.Labels, Annotations: g.Options.Annotations},
+	}
+	g.clusterRoleBinding = &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: g.Options.Name, Labels: g.Options.Labels, Annotations: g.Options.Annotations},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: g.clusterRole.Name},
+		Subjects:   []rbacv1.Subject{subject},
+	}
+
+	g.generated.ClusterRoles = append(g.generated.ClusterRoles, g.clusterRole)
+	g.generated.ClusterRoleBindings = append(g.generated.ClusterRoleBindings, g.clusterRoleBinding)
+
+	_, regeneratedGetter := validation.NewTestRuleResolver(g.generated.Roles, g.generated.RoleBindings, g.generated.ClusterRoles, g.generated.ClusterRoleBindings)
+	*g.generatedGetter = *regeneratedGetter
+
+	return g.clusterRole
+}
+
+func (g *pairwiseGenerator) ensureNamespacedRoleAndBinding(subject rbacv1.Subject, namespace string) *rbacv1.Role {
+	if g.namespacedRole[namespace] != nil {
+		return g.namespacedRole[namespace]
+	}
+
+	g.namespacedRole[namespace] = &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: g.Options.Name, Namespace: namespace, Labels: g.Options.Labels, Annotations: g.Options.Annotations},
+	}
+	g.namespacedRoleBinding[namespace] = &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: g.Options.Name, Namespace: namespace, Labels: g.Options.Labels, Annotations: g.Options.Annotations},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: g.namespacedRole[namespace].Name},
+		Subjects:   []rbacv1.Subject{subject},
+	}
+
+	g.generated.Roles = append(g.generated.Roles, g.namespacedRole[namespace])
+	g.generated.RoleBindings = append(g.generated.RoleBindings, g.namespacedRoleBinding[namespace])
+
+	_, regeneratedGetter := validation.NewTestRuleResolver(g.generated.Roles, g.generated.RoleBindings, g.generated.ClusterRoles, g.generated.ClusterRoleBindings)
+	*g.generatedGetter = *regeneratedGetter
+
+	return g.namespacedRole[namespace]
+}
+
+func pairwiseCompactRules(rules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	breakdownRules := []rbacv1.PolicyRule{}
+	for _, rule := range rules {
+		breakdownRules = append(breakdownRules, helpervalidation.BreakdownRule(rule)...)
+	}
+	compactRules, err := validation.CompactRules(breakdownRules)
+	if err != nil {
+		return rules
+	}
+	// TODO: fix CompactRules to dedupe verbs
+	for i := range compactRules {
+		compactRules[i].Verbs = sets.NewString(compactRules[i].Verbs...).List()
+	}
+
+	accumulatingRules := []rbacv1.PolicyRule{}
+	for _, rule := range compactRules {
+		// Non-resource rules just accumulate
+		if len(rule.Resources) == 0 {
+			accumulatingRules = append(accumulatingRules, rule)
+			continue
+		}
+
+		accumulated := false
+		// strip resource
+		resourcelessRule := rule
+		resourcelessRule.Resources = nil
+		// strip name
+		namelessRule := rule
+		namelessRule.ResourceNames = nil
+		for j, accumulatingRule := range accumulatingRules {
+			// strip name
+			namelessAccumulatingRule := accumulatingRule
+			namelessAccumulatingRule.ResourceNames = nil
+			if reflect.DeepEqual(namelessRule, namelessAccumulatingRule) {
+				combinedNames := sets.NewString(accumulatingRule.ResourceNames...)
+				combinedNames.Insert(rule.ResourceNames...)
+				accumulatingRule.ResourceNames = combinedNames.List()
+				accumulatingRules[j] = accumulatingRule
+				accumulated = true
+				break
+			}
+
+			// strip resource
+			resourcelessAccumulatingRule := accumulatingRule
+			resourcelessAccumulatingRule.Resources = nil
+			if reflect.DeepEqual(resourcelessRule, resourcelessAccumulatingRule) {
+				combinedResources := sets.NewString(accumulatingRule.Resources...)
+				combinedResources.Insert(rule.Resources...)
+				accumulatingRule.Resources = combinedResources.List()
+				accumulatingRules[j] = accumulatingRule
+				accumulated = true
+				break
+			}
+		}
+		if !accumulated {
+			accumulatingRules = append(accumulatingRules, rule)
+		}
+	}
+
+	sort.SliceStable(accumulatingRules, func(i, j int) bool {
+		// TODO: fix upstream sorting to prioritize API group
+		if c := strings.Compare(strings.Join(accumulatingRules[i].APIGroups, ","), strings.Join(accumulatingRules[j].APIGroups, ",")); c != 0 {
+			return c < 0
+		}
+		return strings.Compare(rbacv1helper.CompactString(accumulatingRules[i]), rbacv1helper.CompactString(accumulatingRules[j])) < 0
+	})
+	return accumulatingRules
+}
+
+// equivalentObjects returns true if the objects are identical, other than how the rules of each role are written
+func equivalentObjects(a, b *RBACObjects) bool {
+	if len(a.ClusterRoles) != len(b.ClusterRoles) || len(a.Roles) != len(b.Roles) {
+		return false
+	}
+	if !equality.Semantic.DeepEqual(a.ClusterRoleBindings, b.ClusterRoleBindings) || !equality.Semantic.DeepEqual(a.RoleBindings, b.RoleBindings) {
+		return false
+	}
+	sameRules := func(a, b []rbacv1.PolicyRule) bool {
+		aCovers, _ := helpervalidation.Covers(a, b)
+		bCovers, _ := helpervalidation.Covers(b, a)
+		return aCovers && bCovers
+	}
+	for i := range a.ClusterRoles {
+		if !equality.Semantic.DeepEqual(a.ClusterRoles[i].ObjectMeta, b.ClusterRoles[i].ObjectMeta) || !sameRules(a.ClusterRoles[i].Rules, b.ClusterRoles[i].Rules) {
+			return false
+		}
+	}
+	for i := range a.Roles {
+		if !equality.Semantic.DeepEqual(a.Roles[i].ObjectMeta, b.Roles[i].ObjectMeta) || !sameRules(a.Roles[i].Rules, b.Roles[i].Rules) {
+			return false
+		}
+	}
+	return true
+}
+
+// randomRequests returns n requests drawn from the specified number of namespaces and names
+func randomRequests(r *rand.Rand, n, namespaces, names int) []authorizer.AttributesRecord {
+	bob := &user.DefaultInfo{Name: "bob", Groups: []string{"system:authenticated"}}
+	verbs := []string{"get", "list", "watch", "create", "update", "patch", "delete"}
+	resources := []struct{ group, resource, subresource string }{
+		{"", "pods", ""},
+		{"", "pods", "status"},
+		{"", "configmaps", ""},
+		{"", "secrets", ""},
+		{"", "nodes", ""},
+		{"apps", "deployments", ""},
+		{"apps", "deployments", "scale"},
+		{"batch", "jobs", ""},
+	}
+
+	requests := make([]authorizer.AttributesRecord, 0, n)
+	for i := 0; i < n; i++ {
+		if r.Intn(20) == 0 {
+			requests = append(requests, authorizer.AttributesRecord{User: bob, Verb: "get", Path: fmt.Sprintf("/path%d", r.Intn(5))})
+			continue
+		}
+		resource := resources[r.Intn(len(resources))]
+		request := authorizer.AttributesRecord{
+			User:            bob,
+			ResourceRequest: true,
+			Verb:            verbs[r.Intn(len(verbs))],
+			APIGroup:        resource.group,
+			Resource:        resource.resource,
+			Subresource:     resource.subresource,
+		}
+		if resource.resource != "nodes" && r.Intn(4) != 0 {
+			request.Namespace = fmt.Sprintf("ns%d", r.Intn(namespaces))
+		}
+		if request.Verb != "list" && request.Verb != "create" && r.Intn(3) != 0 {
+			request.Name = fmt.Sprintf("name%d", r.Intn(names))
+		}
+		requests = append(requests, request)
+	}
+	return requests
+}
+
+func TestGenerateMatchesPairwiseGenerate(t *testing.T) {
+	optionCombinations := map[string]GenerateOptions{}
+	for _, expandNames := range []bool{true, false} {
+		for _, expandNamespaces := range []bool{true, false} {
+			opts := DefaultGenerateOptions()
+			opts.ExpandMultipleNamesToUnnamed = expandNames
+			opts.ExpandMultipleNamespacesToClusterScoped = expandNamespaces
+			// the pairwise algorithm predates recognizing access patterns
+			opts.RecognizePatterns = false
+			optionCombinations[fmt.Sprintf("names=%v,namespaces=%v", expandNames, expandNamespaces)] = opts
+		}
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		requests := randomRequests(r, 1+r.Intn(40), 1+r.Intn(3), 1+r.Intn(3))
+		for name, opts := range optionCombinations {
+			// the original compaction could drop permissions when combining rules for any name with rules for specific
+			// names, so the original rules are compared before compaction
+			pairwise := newPairwiseGenerator(RBACObjects{}, append([]authorizer.AttributesRecord{}, requests...), opts)
+			pairwise.compactRules = func(rules []rbacv1.PolicyRule) []rbacv1.PolicyRule { return rules }
+			expected := pairwise.Generate()
+			actual := NewGenerator(RBACObjects{}, append([]authorizer.AttributesRecord{}, requests...), opts).Generate()
+			if !equivalentObjects(expected, actual) {
+				t.Fatalf("%s: unexpected output for %#v\n%s", name, requests, diff.ObjectGoPrintSideBySide(expected, actual))
+			}
+		}
+	}
+}
+
+func BenchmarkGenerate(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000, 1000000} {
+		requests := randomRequests(rand.New(rand.NewSource(1)), n, 100, 1000)
+		b.Run(fmt.Sprintf("%d requests", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				copied := append([]authorizer.AttributesRecord{}, requests...)
+				b.StartTimer()
+				NewGenerator(RBACObjects{}, copied, DefaultGenerateOptions()).Generate()
+			}
+		})
+	}
+}
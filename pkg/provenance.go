@@ -0,0 +1,195 @@
+package pkg
+
+import (
+	"strconv"
+	"time"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	rbacauthorizer "k8s.io/kubernetes/plugin/pkg/auth/authorizer/rbac"
+)
+
+const (
+	// SourceDigestAnnotation is set on generated objects to a digest of the audit logs they were generated from
+	SourceDigestAnnotation = "audit2rbac.liggitt.net/source-digest"
+	// EventTimeRangeAnnotation is set on generated objects to the time of the first and last supporting events, as an RFC 3339 interval
+	EventTimeRangeAnnotation = "audit2rbac.liggitt.net/event-time-range"
+	// EventCountAnnotation is set on generated objects to the number of supporting events
+	EventCountAnnotation = "audit2rbac.liggitt.net/event-count"
+)
+
+// Evidence summarizes the audit events supporting a generated object or rule
+type Evidence struct {
+	Events     int          `json:"events"`
+	FirstSeen  *metav1.Time `json:"firstSeen,omitempty"`
+	LastSeen   *metav1.Time `json:"lastSeen,omitempty"`
+	UserAgents []string     `json:"userAgents,omitempty"`
+	// AuditIDs is a sample of the supporting events, up to MaxSampleAuditIDs
+	AuditIDs []string `json:"auditIDs,omitempty"`
+}
+
+func (e *Evidence) add(request *AggregatedRequest) {
+	e.Events += request.Count
+	if !request.FirstSeen.IsZero() && (e.FirstSeen == nil || request.FirstSeen.Before(e.FirstSeen.Time)) {
+		firstSeen := metav1.NewTime(request.FirstSeen)
+		e.FirstSeen = &firstSeen
+	}
+	if !request.LastSeen.IsZero() && (e.LastSeen == nil || request.LastSeen.After(e.LastSeen.Time)) {
+		lastSeen := metav1.NewTime(request.LastSeen)
+		e.LastSeen = &lastSeen
+	}
+	if request.UserAgents.Len() > 0 {
+		e.UserAgents = sets.NewString(e.UserAgents...).Union(request.UserAgents).List()
+	}
+	for _, id := range request.AuditIDs {
+		if len(e.AuditIDs) < MaxSampleAuditIDs && !sets.NewString(e.AuditIDs...).Has(id) {
+			e.AuditIDs = append(e.AuditIDs, id)
+		}
+	}
+}
+
+// RuleProvenance describes the audit events supporting a rule of a generated role
+type RuleProvenance struct {
+	Rule     rbacv1.PolicyRule `json:"rule"`
+	Evidence `json:",inline"`
+}
+
+// ObjectProvenance describes the audit events supporting a generated object.
+// Bindings are supported by the events supporting the role they reference.
+type ObjectProvenance struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Evidence  `json:",inline"`
+	Rules     []RuleProvenance `json:"rules,omitempty"`
+}
+
+// roleBinding is the scope a role is bound in
+type roleBinding struct {
+	// namespace is the namespace of a role binding, or empty for a cluster role binding
+	namespace string
+	subjects  []rbacv1.Subject
+}
+
+// NewProvenance returns the audit events supporting each generated object and rule,
+// in the order roles, cluster roles, role bindings, and cluster role bindings are output.
+// Requests count as support for every rule that allows them in a namespace the role is bound in for the requesting user.
+func NewProvenance(generated *RBACObjects, requests []*AggregatedRequest) []ObjectProvenance {
+	bindings := map[string][]roleBinding{}
+	for _, binding := range generated.RoleBindings {
+		refNamespace := ""
+		if binding.RoleRef.Kind == "Role" {
+			refNamespace = binding.Namespace
+		}
+		key := objectKey(binding.RoleRef.Kind, refNamespace, binding.RoleRef.Name)
+		bindings[key] = append(bindings[key], roleBinding{namespace: binding.Namespace, subjects: binding.Subjects})
+	}
+	for _, binding := range generated.ClusterRoleBindings {
+		key := objectKey(binding.RoleRef.Kind, "", binding.RoleRef.Name)
+		bindings[key] = append(bindings[key], roleBinding{subjects: binding.Subjects})
+	}
+
+	roles := map[string]ObjectProvenance{}
+	provenance := []ObjectProvenance{}
+	addRole := func(kind, namespace, name string, rules []rbacv1.PolicyRule) {
+		key := objectKey(kind, namespace, name)
+		role := ObjectProvenance{Kind: kind, Namespace: namespace, Name: name}
+		for _, rule := range rules {
+			role.Rules = append(role.Rules, RuleProvenance{Rule: rule})
+		}
+		for _, request := range requests {
+			if !isBoundFor(bindings[key], request) {
+				continue
+			}
+			allowed := false
+			for i := range role.Rules {
+				if rbacauthorizer.RuleAllows(request.Attributes, &role.Rules[i].Rule) {
+					role.Rules[i].add(request)
+					allowed = true
+				}
+			}
+			if allowed {
+				role.add(request)
+			}
+		}
+		roles[key] = role
+		provenance = append(provenance, role)
+	}
+	addBinding := func(kind, namespace, name string, roleRef rbacv1.RoleRef) {
+		refNamespace := ""
+		if roleRef.Kind == "Role" {
+			refNamespace = namespace
+		}
+		binding := ObjectProvenance{Kind: kind, Namespace: namespace, Name: name}
+		binding.Evidence = roles[objectKey(roleRef.Kind, refNamespace, roleRef.Name)].Evidence
+		provenance = append(provenance, binding)
+	}
+
+	for _, role := range generated.Roles {
+		addRole("Role", role.Namespace, role.Name, role.Rules)
+	}
+	for _, role := range generated.ClusterRoles {
+		addRole("ClusterRole", "", role.Name, role.Rules)
+	}
+	for _, binding := range generated.RoleBindings {
+		addBinding("RoleBinding", binding.Namespace, binding.Name, binding.RoleRef)
+	}
+	for _, binding := range generated.ClusterRoleBindings {
+		addBinding("ClusterRoleBinding", "", binding.Name, binding.RoleRef)
+	}
+	return provenance
+}
+
+func isBoundFor(bindings []roleBinding, request *AggregatedRequest) bool {
+	subject := userToSubject(request.Attributes.User)
+	for _, binding := range bindings {
+		if binding.namespace != "" && binding.namespace != request.Attributes.Namespace {
+			continue
+		}
+		for _, s := range binding.subjects {
+			if s == subject {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AnnotateProvenance sets the source digest, event time range, and event count annotations on the generated objects.
+// The source digest annotation is omitted if sourceDigest is empty.
+func AnnotateProvenance(generated *RBACObjects, provenance []ObjectProvenance, sourceDigest string) {
+	evidence := map[string]Evidence{}
+	for _, p := range provenance {
+		evidence[objectKey(p.Kind, p.Namespace, p.Name)] = p.Evidence
+	}
+	annotate := func(kind string, meta *metav1.ObjectMeta) {
+		e := evidence[objectKey(kind, meta.Namespace, meta.Name)]
+		// generated objects can share an annotations map
+		annotations := map[string]string{}
+		for k, v := range meta.Annotations {
+			annotations[k] = v
+		}
+		if len(sourceDigest) > 0 {
+			annotations[SourceDigestAnnotation] = sourceDigest
+		}
+		if e.FirstSeen != nil && e.LastSeen != nil {
+			annotations[EventTimeRangeAnnotation] = e.FirstSeen.UTC().Format(time.RFC3339) + "/" + e.LastSeen.UTC().Format(time.RFC3339)
+		}
+		annotations[EventCountAnnotation] = strconv.Itoa(e.Events)
+		meta.Annotations = annotations
+	}
+
+	for _, obj := range generated.Roles {
+		annotate("Role", &obj.ObjectMeta)
+	}
+	for _, obj := range generated.ClusterRoles {
+		annotate("ClusterRole", &obj.ObjectMeta)
+	}
+	for _, obj := range generated.RoleBindings {
+		annotate("RoleBinding", &obj.ObjectMeta)
+	}
+	for _, obj := range generated.ClusterRoleBindings {
+		annotate("ClusterRoleBinding", &obj.ObjectMeta)
+	}
+}
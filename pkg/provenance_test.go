@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func TestProvenance(t *testing.T) {
+	bob := &user.DefaultInfo{Name: "bob"}
+	t1 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	t3 := t2.Add(time.Hour)
+	timePtr := func(t time.Time) *metav1.Time {
+		m := metav1.NewTime(t)
+		return &m
+	}
+
+	aggregator := NewRequestAggregator()
+	aggregator.Add(authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", Resource: "pods", Name: "pod1"}, t2).AddSource("kubectl", "1")
+	aggregator.Add(authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", Resource: "pods", Name: "pod2"}, t1).AddSource("kubectl", "2")
+	aggregator.Add(authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "delete", Namespace: "ns1", Resource: "pods", Name: "pod1"}, t3).AddSource("controller", "3")
+	aggregator.Add(authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Resource: "nodes", Name: "node1"}, t3).AddSource("kubectl", "4")
+	// requests in other namespaces or by other users do not support the generated role
+	aggregator.Add(authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns2", Resource: "pods", Name: "pod1"}, t3)
+	aggregator.Add(authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "alice"}, ResourceRequest: true, Verb: "get", Namespace: "ns1", Resource: "pods"}, t3)
+
+	generated := &RBACObjects{
+		Roles: []*rbacv1.Role{{
+			ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac:bob", Namespace: "ns1"},
+			Rules: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("get").Groups("").Resources("pods").RuleOrDie(),
+				rbacv1helper.NewRule("delete", "get").Groups("").Resources("pods").Names("pod1").RuleOrDie(),
+				rbacv1helper.NewRule("list").Groups("").Resources("pods").RuleOrDie(),
+			},
+		}},
+		ClusterRoles: []*rbacv1.ClusterRole{{
+			ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac:bob"},
+			Rules:      []rbacv1.PolicyRule{rbacv1helper.NewRule("get").Groups("").Resources("nodes").RuleOrDie()},
+		}},
+		RoleBindings: []*rbacv1.RoleBinding{{
+			ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac:bob", Namespace: "ns1"},
+			Subjects:   []rbacv1.Subject{userToSubject(bob)},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "audit2rbac:bob"},
+		}},
+		ClusterRoleBindings: []*rbacv1.ClusterRoleBinding{{
+			ObjectMeta: metav1.ObjectMeta{Name: "audit2rbac:bob"},
+			Subjects:   []rbacv1.Subject{userToSubject(bob)},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "audit2rbac:bob"},
+		}},
+	}
+
+	roleEvidence := Evidence{Events: 3, FirstSeen: timePtr(t1), LastSeen: timePtr(t3), UserAgents: []string{"controller", "kubectl"}, AuditIDs: []string{"1", "2", "3"}}
+	clusterRoleEvidence := Evidence{Events: 1, FirstSeen: timePtr(t3), LastSeen: timePtr(t3), UserAgents: []string{"kubectl"}, AuditIDs: []string{"4"}}
+	expected := []ObjectProvenance{
+		{
+			Kind: "Role", Namespace: "ns1", Name: "audit2rbac:bob",
+			Evidence: roleEvidence,
+			Rules: []RuleProvenance{
+				{Rule: generated.Roles[0].Rules[0], Evidence: Evidence{Events: 2, FirstSeen: timePtr(t1), LastSeen: timePtr(t2), UserAgents: []string{"kubectl"}, AuditIDs: []string{"1", "2"}}},
+				{Rule: generated.Roles[0].Rules[1], Evidence: Evidence{Events: 2, FirstSeen: timePtr(t2), LastSeen: timePtr(t3), UserAgents: []string{"controller", "kubectl"}, AuditIDs: []string{"1", "3"}}},
+				{Rule: generated.Roles[0].Rules[2]},
+			},
+		},
+		{
+			Kind: "ClusterRole", Name: "audit2rbac:bob",
+			Evidence: clusterRoleEvidence,
+			Rules:    []RuleProvenance{{Rule: generated.ClusterRoles[0].Rules[0], Evidence: clusterRoleEvidence}},
+		},
+		{Kind: "RoleBinding", Namespace: "ns1", Name: "audit2rbac:bob", Evidence: roleEvidence},
+		{Kind: "ClusterRoleBinding", Name: "audit2rbac:bob", Evidence: clusterRoleEvidence},
+	}
+
+	provenance := NewProvenance(generated, aggregator.Requests())
+	if !equality.Semantic.DeepEqual(expected, provenance) {
+		t.Errorf("unexpected provenance\n%s", diff.ObjectGoPrintSideBySide(expected, provenance))
+	}
+
+	AnnotateProvenance(generated, provenance, "sha256:abc")
+	expectedAnnotations := map[string]string{
+		SourceDigestAnnotation:   "sha256:abc",
+		EventTimeRangeAnnotation: "2020-01-01T00:00:00Z/2020-01-01T02:00:00Z",
+		EventCountAnnotation:     "3",
+	}
+	if !reflect.DeepEqual(expectedAnnotations, generated.RoleBindings[0].Annotations) {
+		t.Errorf("unexpected annotations\n%s", diff.ObjectGoPrintSideBySide(expectedAnnotations, generated.RoleBindings[0].Annotations))
+	}
+	if generated.ClusterRoles[0].Annotations[EventCountAnnotation] != "1" {
+		t.Errorf("unexpected annotations: %v", generated.ClusterRoles[0].Annotations)
+	}
+}
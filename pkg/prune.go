@@ -0,0 +1,165 @@
+package pkg
+
+import (
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+	helpervalidation "k8s.io/component-helpers/auth/rbac/validation"
+	rbacauthorizer "k8s.io/kubernetes/plugin/pkg/auth/authorizer/rbac"
+)
+
+// PruneRoles returns a copy of objects with the rules of roles and cluster roles reduced to the permissions that allowed requests by the user.
+// Roles are considered granted where they are bound to the user. Roles not bound to the user by any binding in objects
+// are considered granted in their namespace, and cluster roles cluster-wide. Bindings are returned unchanged.
+// Wildcard verbs, API groups, resources, and non-resource URLs are replaced by the values the requests used.
+func PruneRoles(objects *RBACObjects, u user.Info, requests []*AggregatedRequest) *RBACObjects {
+	bound := &RBACObjects{
+		Roles:               objects.Roles,
+		ClusterRoles:        objects.ClusterRoles,
+		RoleBindings:        append([]*rbacv1.RoleBinding{}, objects.RoleBindings...),
+		ClusterRoleBindings: append([]*rbacv1.ClusterRoleBinding{}, objects.ClusterRoleBindings...),
+	}
+	boundRoles := map[string]bool{}
+	for _, binding := range objects.RoleBindings {
+		if appliesTo(u, binding.Subjects, binding.Namespace) {
+			namespace := ""
+			if binding.RoleRef.Kind == "Role" {
+				namespace = binding.Namespace
+			}
+			boundRoles[objectKey(binding.RoleRef.Kind, namespace, binding.RoleRef.Name)] = true
+		}
+	}
+	for _, binding := range objects.ClusterRoleBindings {
+		if appliesTo(u, binding.Subjects, "") {
+			boundRoles[objectKey(binding.RoleRef.Kind, "", binding.RoleRef.Name)] = true
+		}
+	}
+	subjects := []rbacv1.Subject{userToSubject(u)}
+	for _, role := range objects.Roles {
+		if !boundRoles[objectKey("Role", role.Namespace, role.Name)] {
+			bound.RoleBindings = append(bound.RoleBindings, &rbacv1.RoleBinding{
+				ObjectMeta: *role.ObjectMeta.DeepCopy(),
+				Subjects:   subjects,
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: role.Name},
+			})
+		}
+	}
+	for _, role := range objects.ClusterRoles {
+		if !boundRoles[objectKey("ClusterRole", "", role.Name)] {
+			bound.ClusterRoleBindings = append(bound.ClusterRoleBindings, &rbacv1.ClusterRoleBinding{
+				ObjectMeta: *role.ObjectMeta.DeepCopy(),
+				Subjects:   subjects,
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: role.Name},
+			})
+		}
+	}
+
+	// a permission of a cluster role is kept if it was used in any namespace the role is bound in
+	used := map[string][]rbacv1.PolicyRule{}
+	for _, usage := range PermissionUsages(bound, u, requests) {
+		if usage.Requests == 0 {
+			continue
+		}
+		namespace := ""
+		if usage.RoleRef.Kind == "Role" {
+			namespace = usage.Namespace
+		}
+		key := objectKey(usage.RoleRef.Kind, namespace, usage.RoleRef.Name)
+		used[key] = append(used[key], usedPermissions(usage, requests)...)
+	}
+
+	pruned := &RBACObjects{RoleBindings: objects.RoleBindings, ClusterRoleBindings: objects.ClusterRoleBindings}
+	for _, role := range objects.Roles {
+		role = role.DeepCopy()
+		role.Rules = compactRules(used[objectKey("Role", role.Namespace, role.Name)])
+		pruned.Roles = append(pruned.Roles, role)
+	}
+	for _, role := range objects.ClusterRoles {
+		role = role.DeepCopy()
+		role.Rules = compactRules(used[objectKey("ClusterRole", "", role.Name)])
+		pruned.ClusterRoles = append(pruned.ClusterRoles, role)
+	}
+	return pruned
+}
+
+// usedPermissions returns the permission of the usage, with wildcard verbs, API groups, resources, and non-resource URLs
+// replaced by the values of the requests it allowed, so pruning does not keep wildcards the requests did not need
+func usedPermissions(usage PermissionUsage, requests []*AggregatedRequest) []rbacv1.PolicyRule {
+	permission := usage.Permission
+	if !hasWildcard(permission.Verbs) && !hasWildcard(permission.APIGroups) && !hasWildcard(permission.Resources) && !hasWildcard(permission.NonResourceURLs) {
+		return []rbacv1.PolicyRule{permission}
+	}
+
+	permissions := []rbacv1.PolicyRule{}
+	for _, request := range requests {
+		if usage.Namespace != "" && usage.Namespace != request.Attributes.Namespace {
+			continue
+		}
+		if !rbacauthorizer.RuleAllows(request.Attributes, &permission) {
+			continue
+		}
+		used := *permission.DeepCopy()
+		if hasWildcard(used.Verbs) {
+			used.Verbs = []string{request.Attributes.Verb}
+		}
+		if hasWildcard(used.APIGroups) {
+			used.APIGroups = []string{request.Attributes.APIGroup}
+		}
+		if hasWildcard(used.Resources) {
+			used.Resources = []string{request.Attributes.Resource}
+			if request.Attributes.Subresource != "" {
+				used.Resources[0] = request.Attributes.Resource + "/" + request.Attributes.Subresource
+			}
+		}
+		if hasWildcard(used.NonResourceURLs) {
+			used.NonResourceURLs = []string{request.Attributes.Path}
+		}
+		permissions = append(permissions, used)
+	}
+	return permissions
+}
+
+// hasWildcard returns true if any of the values of a rule contain a wildcard
+func hasWildcard(values []string) bool {
+	for _, value := range values {
+		if strings.Contains(value, "*") {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleRulesDiff lists rules of a role that are not allowed by the same role in another set of objects
+type RoleRulesDiff struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Rules     []rbacv1.PolicyRule
+}
+
+// DiffRoleRules returns the rules of the roles and cluster roles in objects that are not allowed by the role
+// of the same kind, namespace, and name in base, compacted. Roles that are allowed by base are omitted.
+func DiffRoleRules(base, objects *RBACObjects) []RoleRulesDiff {
+	baseRules := map[string][]rbacv1.PolicyRule{}
+	for _, role := range base.Roles {
+		baseRules[objectKey("Role", role.Namespace, role.Name)] = role.Rules
+	}
+	for _, role := range base.ClusterRoles {
+		baseRules[objectKey("ClusterRole", "", role.Name)] = role.Rules
+	}
+
+	diffs := []RoleRulesDiff{}
+	diff := func(kind, namespace, name string, rules []rbacv1.PolicyRule) {
+		if _, missing := helpervalidation.Covers(baseRules[objectKey(kind, namespace, name)], rules); len(missing) > 0 {
+			diffs = append(diffs, RoleRulesDiff{Kind: kind, Namespace: namespace, Name: name, Rules: compactRules(missing)})
+		}
+	}
+	for _, role := range objects.Roles {
+		diff("Role", role.Namespace, role.Name, role.Rules)
+	}
+	for _, role := range objects.ClusterRoles {
+		diff("ClusterRole", "", role.Name, role.Rules)
+	}
+	return diffs
+}
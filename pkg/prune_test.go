@@ -0,0 +1,126 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func TestPruneRoles(t *testing.T) {
+	bob := &user.DefaultInfo{Name: "bob"}
+	meta := metav1.ObjectMeta{Name: "legacy", Labels: map[string]string{"team": "a"}}
+	objects := &RBACObjects{
+		ClusterRoles: []*rbacv1.ClusterRole{{
+			ObjectMeta: meta,
+			Rules: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("get", "list", "delete").Groups("", "apps").Resources("pods", "deployments").RuleOrDie(),
+				rbacv1helper.NewRule("get").URLs("/metrics").RuleOrDie(),
+			},
+		}},
+		RoleBindings: []*rbacv1.RoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "legacy", Namespace: "ns1"},
+				Subjects:   []rbacv1.Subject{userToSubject(bob)},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "legacy"},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "legacy", Namespace: "ns2"},
+				Subjects:   []rbacv1.Subject{userToSubject(bob)},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "legacy"},
+			},
+		},
+		// not bound, considered granted in its namespace
+		Roles: []*rbacv1.Role{{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "ns1"},
+			Rules:      []rbacv1.PolicyRule{rbacv1helper.NewRule("get", "update").Groups("").Resources("configmaps").Names("a", "b").RuleOrDie()},
+		}},
+	}
+
+	requests := NewRequestAggregator()
+	now := time.Now()
+	requests.Add(authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", Resource: "pods", Name: "p1"}, now)
+	requests.Add(authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "list", Namespace: "ns2", APIGroup: "apps", Resource: "deployments"}, now)
+	requests.Add(authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "update", Namespace: "ns1", Resource: "configmaps", Name: "a"}, now)
+	// not granted by the role bindings
+	requests.Add(authorizer.AttributesRecord{User: bob, Verb: "get", Path: "/metrics"}, now)
+
+	pruned := PruneRoles(objects, bob, requests.Requests())
+
+	expectedClusterRoles := []*rbacv1.ClusterRole{{
+		ObjectMeta: meta,
+		Rules: []rbacv1.PolicyRule{
+			rbacv1helper.NewRule("get").Groups("").Resources("pods").RuleOrDie(),
+			rbacv1helper.NewRule("list").Groups("apps").Resources("deployments").RuleOrDie(),
+		},
+	}}
+	if !equality.Semantic.DeepEqual(expectedClusterRoles, pruned.ClusterRoles) {
+		t.Errorf("unexpected cluster roles\n%s", diff.ObjectGoPrintSideBySide(expectedClusterRoles, pruned.ClusterRoles))
+	}
+	expectedRoles := []*rbacv1.Role{{
+		ObjectMeta: objects.Roles[0].ObjectMeta,
+		Rules:      []rbacv1.PolicyRule{rbacv1helper.NewRule("update").Groups("").Resources("configmaps").Names("a").RuleOrDie()},
+	}}
+	if !equality.Semantic.DeepEqual(expectedRoles, pruned.Roles) {
+		t.Errorf("unexpected roles\n%s", diff.ObjectGoPrintSideBySide(expectedRoles, pruned.Roles))
+	}
+	if !equality.Semantic.DeepEqual(objects.RoleBindings, pruned.RoleBindings) || len(pruned.ClusterRoleBindings) != 0 {
+		t.Errorf("expected bindings to be unchanged, got %#v, %#v", pruned.RoleBindings, pruned.ClusterRoleBindings)
+	}
+	if len(objects.ClusterRoles[0].Rules) != 2 {
+		t.Errorf("expected original objects to be unchanged")
+	}
+
+	expectedRemoved := []RoleRulesDiff{
+		{Kind: "Role", Namespace: "ns1", Name: "app", Rules: []rbacv1.PolicyRule{
+			rbacv1helper.NewRule("get").Groups("").Resources("configmaps").Names("a").RuleOrDie(),
+			rbacv1helper.NewRule("get", "update").Groups("").Resources("configmaps").Names("b").RuleOrDie(),
+		}},
+		{Kind: "ClusterRole", Name: "legacy", Rules: []rbacv1.PolicyRule{
+			rbacv1helper.NewRule("delete", "list").Groups("").Resources("deployments", "pods").RuleOrDie(),
+			rbacv1helper.NewRule("get").URLs("/metrics").RuleOrDie(),
+			rbacv1helper.NewRule("delete", "get").Groups("", "apps").Resources("deployments").RuleOrDie(),
+			rbacv1helper.NewRule("delete", "get", "list").Groups("apps").Resources("pods").RuleOrDie(),
+		}},
+	}
+	if removed := DiffRoleRules(pruned, objects); !equality.Semantic.DeepEqual(expectedRemoved, removed) {
+		t.Errorf("unexpected removed rules\n%s", diff.ObjectGoPrintSideBySide(expectedRemoved, removed))
+	}
+}
+
+func TestPruneWildcardRoles(t *testing.T) {
+	bob := &user.DefaultInfo{Name: "bob"}
+	objects := &RBACObjects{
+		ClusterRoles: []*rbacv1.ClusterRole{{
+			ObjectMeta: metav1.ObjectMeta{Name: "admin"},
+			Rules: []rbacv1.PolicyRule{
+				rbacv1helper.NewRule("*").Groups("*").Resources("*").RuleOrDie(),
+				rbacv1helper.NewRule("get").URLs("/healthz/*").RuleOrDie(),
+			},
+		}},
+	}
+
+	requests := NewRequestAggregator()
+	now := time.Now()
+	requests.Add(authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", Resource: "pods", Name: "p1"}, now)
+	requests.Add(authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "list", Namespace: "ns1", Resource: "pods"}, now)
+	requests.Add(authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "update", Namespace: "ns2", APIGroup: "apps", Resource: "deployments", Subresource: "scale", Name: "d1"}, now)
+	requests.Add(authorizer.AttributesRecord{User: bob, Verb: "get", Path: "/healthz/etcd"}, now)
+
+	pruned := PruneRoles(objects, bob, requests.Requests())
+
+	expected := []rbacv1.PolicyRule{
+		rbacv1helper.NewRule("get", "list").Groups("").Resources("pods").RuleOrDie(),
+		rbacv1helper.NewRule("get").URLs("/healthz/etcd").RuleOrDie(),
+		rbacv1helper.NewRule("update").Groups("apps").Resources("deployments/scale").RuleOrDie(),
+	}
+	if !equality.Semantic.DeepEqual(expected, pruned.ClusterRoles[0].Rules) {
+		t.Errorf("unexpected rules\n%s", diff.ObjectGoPrintSideBySide(expected, pruned.ClusterRoles[0].Rules))
+	}
+}
@@ -0,0 +1,226 @@
+package pkg
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Finding is a problem found in RBAC objects or audit events, reported as a failing test case in JUnit reports and as a result in SARIF reports
+type Finding struct {
+	// Rule identifies the check that reported the finding, like "denied-request" or "unused-permission"
+	Rule string
+	// Level is the SARIF level of the finding: "error", "warning", or "note"
+	Level string
+	// Name is a short description of the finding, unique within the rule
+	Name string
+	// Message describes the finding in detail
+	Message string
+	// Location is the RBAC object the finding concerns, if any
+	Location *ObjectLocation
+}
+
+// ObjectLocation locates an RBAC object, or a rule in it
+type ObjectLocation struct {
+	// File is the path or URL of the manifest containing the object, if known
+	File string
+	// Line is the line of File the object or rule starts at, or 0 if unknown
+	Line int
+	// Kind, Namespace, and Name identify the object. They are empty if the location is a file of rules that are not part of an object
+	Kind      string
+	Namespace string
+	Name      string
+	// Rule is the index of the rule in the object, or -1 if the finding concerns the whole object
+	Rule int
+}
+
+// String returns a name for the object or rule like "Role/my-namespace/my-role/rules[1]"
+func (l ObjectLocation) String() string {
+	s := l.Kind
+	if len(l.Namespace) > 0 {
+		s += "/" + l.Namespace
+	}
+	s += "/" + l.Name
+	if l.Rule >= 0 {
+		s += fmt.Sprintf("/rules[%d]", l.Rule)
+	}
+	return s
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the findings as failing test cases of a JUnit XML test suite with the specified name.
+// If there are no findings, a single passing test case named after the suite is written.
+func WriteJUnit(w io.Writer, suite string, findings []Finding) error {
+	testSuite := junitTestSuite{Name: suite, Tests: len(findings), Failures: len(findings)}
+	for _, finding := range findings {
+		text := finding.Message
+		if finding.Location != nil {
+			where := []string{}
+			if len(finding.Location.Name) > 0 {
+				where = append(where, finding.Location.String())
+			}
+			if len(finding.Location.File) > 0 {
+				file := finding.Location.File
+				if finding.Location.Line > 0 {
+					file += fmt.Sprintf(":%d", finding.Location.Line)
+				}
+				where = append(where, file)
+			}
+			text += "\n\n" + strings.Join(where, " in ")
+		}
+		testSuite.TestCases = append(testSuite.TestCases, junitTestCase{
+			ClassName: suite + "." + finding.Rule,
+			Name:      finding.Name,
+			Failure:   &junitFailure{Message: finding.Message, Type: finding.Level, Text: text},
+		})
+	}
+	if len(findings) == 0 {
+		testSuite.Tests = 1
+		testSuite.TestCases = []junitTestCase{{ClassName: suite, Name: suite}}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(junitTestSuites{Suites: []junitTestSuite{testSuite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version,omitempty"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level,omitempty"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// WriteSARIF writes the findings as the results of a SARIF 2.1.0 log.
+// Findings with a location point at the manifest file, the line if it is known, and the object or rule they concern.
+func WriteSARIF(w io.Writer, findings []Finding) error {
+	rules := map[string]bool{}
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "audit2rbac",
+			Version:        strings.TrimPrefix(Version, "v"),
+			InformationURI: "https://github.com/liggitt/audit2rbac",
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+	for _, finding := range findings {
+		rules[finding.Rule] = true
+		result := sarifResult{RuleID: finding.Rule, Level: finding.Level, Message: sarifMessage{Text: finding.Message}}
+		if finding.Location != nil {
+			location := sarifLocation{}
+			if len(finding.Location.Name) > 0 {
+				location.LogicalLocations = []sarifLogicalLocation{{FullyQualifiedName: finding.Location.String(), Kind: "object"}}
+			}
+			if len(finding.Location.File) > 0 {
+				location.PhysicalLocation = &sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: finding.Location.File}}
+				if finding.Location.Line > 0 {
+					location.PhysicalLocation.Region = &sarifRegion{StartLine: finding.Location.Line}
+				}
+			}
+			result.Locations = []sarifLocation{location}
+		}
+		run.Results = append(run.Results, result)
+	}
+	ruleIDs := []string{}
+	for rule := range rules {
+		ruleIDs = append(ruleIDs, rule)
+	}
+	sort.Strings(ruleIDs)
+	for _, rule := range ruleIDs {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: rule})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(sarifLog{Schema: sarifSchema, Version: "2.1.0", Runs: []sarifRun{run}})
+}
@@ -0,0 +1,162 @@
+package pkg
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteReports(t *testing.T) {
+	findings := []Finding{
+		{
+			Rule:    "denied-request",
+			Level:   "error",
+			Name:    "get pods -n ns1",
+			Message: "get pods -n ns1 by bob would be denied",
+		},
+		{
+			Rule:     "unused-permission",
+			Level:    "warning",
+			Name:     "Role/ns1/app list <secrets>",
+			Message:  "role app grants list <secrets>",
+			Location: &ObjectLocation{File: "rbac/app.yaml", Line: 12, Kind: "Role", Namespace: "ns1", Name: "app", Rule: 1},
+		},
+		{
+			Rule:     "denied-by-policy",
+			Level:    "warning",
+			Name:     "get secrets -n ns1",
+			Message:  "get secrets -n ns1 by bob was denied by policy",
+			Location: &ObjectLocation{File: "never-grant.yaml", Rule: -1},
+		},
+	}
+
+	testcases := []struct {
+		name     string
+		write    func(*bytes.Buffer) error
+		expected string
+	}{
+		{
+			name:  "junit",
+			write: func(out *bytes.Buffer) error { return WriteJUnit(out, "audit2rbac verify", findings) },
+			expected: `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="audit2rbac verify" tests="3" failures="3">
+    <testcase classname="audit2rbac verify.denied-request" name="get pods -n ns1">
+      <failure message="get pods -n ns1 by bob would be denied" type="error">get pods -n ns1 by bob would be denied</failure>
+    </testcase>
+    <testcase classname="audit2rbac verify.unused-permission" name="Role/ns1/app list &lt;secrets&gt;">
+      <failure message="role app grants list &lt;secrets&gt;" type="warning">role app grants list &lt;secrets&gt;&#xA;&#xA;Role/ns1/app/rules[1] in rbac/app.yaml:12</failure>
+    </testcase>
+    <testcase classname="audit2rbac verify.denied-by-policy" name="get secrets -n ns1">
+      <failure message="get secrets -n ns1 by bob was denied by policy" type="warning">get secrets -n ns1 by bob was denied by policy&#xA;&#xA;never-grant.yaml</failure>
+    </testcase>
+  </testsuite>
+</testsuites>
+`,
+		},
+		{
+			name:  "junit without findings",
+			write: func(out *bytes.Buffer) error { return WriteJUnit(out, "audit2rbac verify", nil) },
+			expected: `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="audit2rbac verify" tests="1" failures="0">
+    <testcase classname="audit2rbac verify" name="audit2rbac verify"></testcase>
+  </testsuite>
+</testsuites>
+`,
+		},
+		{
+			name:  "sarif",
+			write: func(out *bytes.Buffer) error { return WriteSARIF(out, findings) },
+			expected: `{
+  "$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+  "version": "2.1.0",
+  "runs": [
+    {
+      "tool": {
+        "driver": {
+          "name": "audit2rbac",
+          "version": "unknown",
+          "informationUri": "https://github.com/liggitt/audit2rbac",
+          "rules": [
+            {
+              "id": "denied-by-policy"
+            },
+            {
+              "id": "denied-request"
+            },
+            {
+              "id": "unused-permission"
+            }
+          ]
+        }
+      },
+      "results": [
+        {
+          "ruleId": "denied-request",
+          "level": "error",
+          "message": {
+            "text": "get pods -n ns1 by bob would be denied"
+          }
+        },
+        {
+          "ruleId": "unused-permission",
+          "level": "warning",
+          "message": {
+            "text": "role app grants list <secrets>"
+          },
+          "locations": [
+            {
+              "physicalLocation": {
+                "artifactLocation": {
+                  "uri": "rbac/app.yaml"
+                },
+                "region": {
+                  "startLine": 12
+                }
+              },
+              "logicalLocations": [
+                {
+                  "fullyQualifiedName": "Role/ns1/app/rules[1]",
+                  "kind": "object"
+                }
+              ]
+            }
+          ]
+        },
+        {
+          "ruleId": "denied-by-policy",
+          "level": "warning",
+          "message": {
+            "text": "get secrets -n ns1 by bob was denied by policy"
+          },
+          "locations": [
+            {
+              "physicalLocation": {
+                "artifactLocation": {
+                  "uri": "never-grant.yaml"
+                }
+              }
+            }
+          ]
+        }
+      ]
+    }
+  ]
+}
+`,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			if err := tc.write(out); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tc.expected {
+				t.Errorf("unexpected output:\n%s", cmp.Diff(tc.expected, out.String()))
+			}
+		})
+	}
+}
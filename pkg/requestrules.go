@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	rbacauthorizer "k8s.io/kubernetes/plugin/pkg/auth/authorizer/rbac"
+	"sigs.k8s.io/yaml"
+)
+
+// RequestRule is a policy rule matching requests, used to select requests that must never be granted, or that are ignored
+type RequestRule struct {
+	rbacv1.PolicyRule `json:",inline"`
+	// ClusterWide limits the rule to requests that are not made in a namespace,
+	// like requests for cluster-scoped resources or for resources in all namespaces
+	ClusterWide bool `json:"clusterWide,omitempty"`
+}
+
+// Matches returns true if the rule matches the request
+func (r *RequestRule) Matches(request authorizer.AttributesRecord) bool {
+	if r.ClusterWide && request.Namespace != "" {
+		return false
+	}
+	return rbacauthorizer.RuleAllows(request, &r.PolicyRule)
+}
+
+// MatchedByRule returns true if the rule, granted in the namespace or cluster-wide if namespace is empty,
+// allows any request r matches
+func (r *RequestRule) MatchedByRule(namespace string, rule rbacv1.PolicyRule) bool {
+	if r.ClusterWide && namespace != "" {
+		return false
+	}
+	// non-resource URLs can only be granted cluster-wide
+	if namespace != "" && len(rule.NonResourceURLs) > 0 {
+		return false
+	}
+	return rulesOverlap(r.PolicyRule, rule)
+}
+
+// MatchRequestRules returns the index of the first rule that matches the request, or -1 if none match
+func MatchRequestRules(rules []RequestRule, request authorizer.AttributesRecord) int {
+	for i := range rules {
+		if rules[i].Matches(request) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ParseRequestRules parses a YAML or JSON list of policy rules, a single policy rule, or an object with a list of rules like a Role or ClusterRole.
+// Rules can set clusterWide: true to only match requests that are not made in a namespace.
+func ParseRequestRules(data []byte) ([]RequestRule, error) {
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	rules := []RequestRule{}
+	if trimmed := strings.TrimSpace(string(jsonData)); strings.HasPrefix(trimmed, "[") {
+		err = json.Unmarshal(jsonData, &rules)
+	} else if trimmed != "" && trimmed != "null" {
+		object := map[string]json.RawMessage{}
+		if err = json.Unmarshal(jsonData, &object); err == nil {
+			if list, ok := object["rules"]; ok {
+				err = json.Unmarshal(list, &rules)
+			} else {
+				rule := RequestRule{}
+				err = json.Unmarshal(jsonData, &rule)
+				rules = append(rules, rule)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, rule := range rules {
+		switch {
+		case len(rule.Verbs) == 0:
+			err = fmt.Errorf("verbs must not be empty")
+		case len(rule.NonResourceURLs) > 0 && (len(rule.APIGroups) > 0 || len(rule.Resources) > 0 || len(rule.ResourceNames) > 0):
+			err = fmt.Errorf("nonResourceURLs cannot be combined with apiGroups, resources, or resourceNames")
+		case len(rule.NonResourceURLs) == 0 && (len(rule.APIGroups) == 0 || len(rule.Resources) == 0):
+			err = fmt.Errorf("apiGroups and resources, or nonResourceURLs, must not be empty")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %d: %v", i, err)
+		}
+	}
+	return rules, nil
+}
@@ -0,0 +1,134 @@
+package pkg
+
+import (
+	"testing"
+
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+func TestMatchRequestRules(t *testing.T) {
+	rules, err := ParseRequestRules([]byte(`
+- apiGroups: [""]
+  resources: ["secrets"]
+  verbs: ["*"]
+  clusterWide: true
+- apiGroups: [""]
+  resources: ["nodes"]
+  verbs: ["delete", "deletecollection"]
+- nonResourceURLs: ["/debug/*"]
+  verbs: ["get"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		name     string
+		request  authorizer.AttributesRecord
+		expected int
+	}{
+		{
+			name:     "secrets in all namespaces",
+			request:  authorizer.AttributesRecord{ResourceRequest: true, Verb: "list", Resource: "secrets"},
+			expected: 0,
+		},
+		{
+			name:     "secrets in a namespace",
+			request:  authorizer.AttributesRecord{ResourceRequest: true, Verb: "list", Namespace: "ns1", Resource: "secrets"},
+			expected: -1,
+		},
+		{
+			name:     "delete node",
+			request:  authorizer.AttributesRecord{ResourceRequest: true, Verb: "delete", Resource: "nodes", Name: "node1"},
+			expected: 1,
+		},
+		{
+			name:     "get node",
+			request:  authorizer.AttributesRecord{ResourceRequest: true, Verb: "get", Resource: "nodes", Name: "node1"},
+			expected: -1,
+		},
+		{
+			name:     "non-resource URL",
+			request:  authorizer.AttributesRecord{Verb: "get", Path: "/debug/pprof"},
+			expected: 2,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if i := MatchRequestRules(rules, tc.request); i != tc.expected {
+				t.Errorf("expected rule %d to match, got %d", tc.expected, i)
+			}
+		})
+	}
+}
+
+func TestParseRequestRules(t *testing.T) {
+	testcases := []struct {
+		name          string
+		data          string
+		expectedRules int
+		expectedErr   string
+	}{
+		{
+			name:          "json list",
+			data:          `[{"apiGroups":[""],"resources":["secrets"],"verbs":["list"]}]`,
+			expectedRules: 1,
+		},
+		{
+			name: "cluster role",
+			data: `
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: never-grant
+rules:
+- apiGroups: [""]
+  resources: ["secrets"]
+  verbs: ["list"]
+- nonResourceURLs: ["/debug/*"]
+  verbs: ["get"]
+`,
+			expectedRules: 2,
+		},
+		{
+			name:          "single rule",
+			data:          `{verbs: [create], apiGroups: [""], resources: [events]}`,
+			expectedRules: 1,
+		},
+		{
+			name: "empty",
+		},
+		{
+			name:        "missing verbs",
+			data:        `[{"apiGroups":[""],"resources":["secrets"]}]`,
+			expectedErr: "invalid rule 0: verbs must not be empty",
+		},
+		{
+			name:        "missing resources",
+			data:        `[{"verbs":["get"]}]`,
+			expectedErr: "invalid rule 0: apiGroups and resources, or nonResourceURLs, must not be empty",
+		},
+		{
+			name:        "mixed resources and urls",
+			data:        `[{"apiGroups":[""],"resources":["secrets"],"nonResourceURLs":["/"],"verbs":["get"]}]`,
+			expectedErr: "invalid rule 0: nonResourceURLs cannot be combined with apiGroups, resources, or resourceNames",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			rules, err := ParseRequestRules([]byte(tc.data))
+			if len(tc.expectedErr) > 0 {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Errorf("expected error %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rules) != tc.expectedRules {
+				t.Errorf("expected %d rules, got %d", tc.expectedRules, len(rules))
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	rbacauthorizer "k8s.io/kubernetes/plugin/pkg/auth/authorizer/rbac"
+)
+
+// Severity ranks how dangerous a risk is
+type Severity int
+
+const (
+	SeverityLow Severity = iota + 1
+	SeverityMedium
+	SeverityHigh
+)
+
+// Severities lists the names of the severities, from lowest to highest
+var Severities = []string{SeverityLow.String(), SeverityMedium.String(), SeverityHigh.String()}
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityLow:
+		return "low"
+	case SeverityMedium:
+		return "medium"
+	case SeverityHigh:
+		return "high"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// ParseSeverity returns the severity with the specified name
+func ParseSeverity(s string) (Severity, error) {
+	for _, severity := range []Severity{SeverityLow, SeverityMedium, SeverityHigh} {
+		if s == severity.String() {
+			return severity, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown severity %q", s)
+}
+
+// Risk is a rule of a role that allows a user to escalate their privileges or access sensitive data
+type Risk struct {
+	// Check is the name of the check that flagged the rule, like "list-secrets"
+	Check    string
+	Severity Severity
+	// Explanation describes what the rule allows
+	Explanation string
+
+	// Kind, Namespace, and Name identify the role containing the rule
+	Kind      string
+	Namespace string
+	Name      string
+	// RuleIndex is the index of the rule in the role
+	RuleIndex int
+	Rule      rbacv1.PolicyRule
+}
+
+type riskCheck struct {
+	name        string
+	severity    Severity
+	explanation string
+	// matches returns true if the rule is risky
+	matches func(rule *rbacv1.PolicyRule) bool
+}
+
+// allowsAny returns a function that returns true if a rule allows any of the verbs on the resource.
+// The resource may include a subresource, like "nodes/proxy".
+func allowsAny(group, resource string, verbs ...string) func(rule *rbacv1.PolicyRule) bool {
+	subresource := ""
+	if parts := strings.SplitN(resource, "/", 2); len(parts) == 2 {
+		resource, subresource = parts[0], parts[1]
+	}
+	return func(rule *rbacv1.PolicyRule) bool {
+		for _, verb := range verbs {
+			request := authorizer.AttributesRecord{ResourceRequest: true, Verb: verb, APIGroup: group, Resource: resource, Subresource: subresource}
+			if rbacauthorizer.RuleAllows(request, rule) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func anyOf(funcs ...func(rule *rbacv1.PolicyRule) bool) func(rule *rbacv1.PolicyRule) bool {
+	return func(rule *rbacv1.PolicyRule) bool {
+		for _, f := range funcs {
+			if f(rule) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+var riskChecks = []riskCheck{
+	{
+		name:        "wildcard-verbs",
+		severity:    SeverityHigh,
+		explanation: "allows every verb, including verbs that grant further permissions and verbs added in the future",
+		matches: func(rule *rbacv1.PolicyRule) bool {
+			for _, verb := range rule.Verbs {
+				if verb == rbacv1.VerbAll {
+					return true
+				}
+			}
+			return false
+		},
+	},
+	{
+		name:        "escalate",
+		severity:    SeverityHigh,
+		explanation: "allows writing roles with permissions the user does not have",
+		matches:     anyOf(allowsAny(rbacv1.GroupName, "roles", "escalate"), allowsAny(rbacv1.GroupName, "clusterroles", "escalate")),
+	},
+	{
+		name:        "bind",
+		severity:    SeverityHigh,
+		explanation: "allows binding roles with permissions the user does not have",
+		matches:     anyOf(allowsAny(rbacv1.GroupName, "roles", "bind"), allowsAny(rbacv1.GroupName, "clusterroles", "bind")),
+	},
+	{
+		name:        "impersonate",
+		severity:    SeverityHigh,
+		explanation: "allows acting as other users, groups, or service accounts, with their permissions",
+		matches: anyOf(
+			allowsAny("", "users", "impersonate"),
+			allowsAny("", "groups", "impersonate"),
+			allowsAny("", "serviceaccounts", "impersonate"),
+			allowsAny("authentication.k8s.io", "uids", "impersonate"),
+			allowsAny("authentication.k8s.io", "userextras", "impersonate"),
+		),
+	},
+	{
+		name:        "nodes-proxy",
+		severity:    SeverityHigh,
+		explanation: "allows calling the kubelet API, which can run commands in any container on the node",
+		matches:     allowsAny("", "nodes/proxy", "get", "create"),
+	},
+	{
+		name:        "approve-certificates",
+		severity:    SeverityHigh,
+		explanation: "allows approving certificate signing requests, which can issue credentials for other users",
+		matches:     allowsAny("certificates.k8s.io", "certificatesigningrequests/approval", "update", "patch"),
+	},
+	{
+		name:        "serviceaccount-tokens",
+		severity:    SeverityHigh,
+		explanation: "allows creating tokens for service accounts, with their permissions",
+		matches:     allowsAny("", "serviceaccounts/token", "create"),
+	},
+	{
+		name:        "list-secrets",
+		severity:    SeverityHigh,
+		explanation: "allows reading the contents of every secret, including service account tokens",
+		matches:     allowsAny("", "secrets", "list", "watch"),
+	},
+	{
+		name:        "get-secrets",
+		severity:    SeverityMedium,
+		explanation: "allows reading the contents of any secret by name, including service account tokens",
+		matches:     allowsAny("", "secrets", "get"),
+	},
+	{
+		name:        "create-pods",
+		severity:    SeverityMedium,
+		explanation: "allows running pods as any service account in the namespace, with its permissions, and mounting any secret in the namespace",
+		matches:     allowsAny("", "pods", "create"),
+	},
+}
+
+// AnalyzeRisks returns the risky rules of the roles and cluster roles in objects, in the order of the roles and rules.
+// A rule is reported once for each check it matches.
+func AnalyzeRisks(objects *RBACObjects) []Risk {
+	risks := []Risk{}
+	analyze := func(kind, namespace, name string, rules []rbacv1.PolicyRule) {
+		for i := range rules {
+			for _, check := range riskChecks {
+				if check.matches(&rules[i]) {
+					risks = append(risks, Risk{
+						Check:       check.name,
+						Severity:    check.severity,
+						Explanation: check.explanation,
+						Kind:        kind,
+						Namespace:   namespace,
+						Name:        name,
+						RuleIndex:   i,
+						Rule:        rules[i],
+					})
+				}
+			}
+		}
+	}
+	for _, role := range objects.Roles {
+		analyze("Role", role.Namespace, role.Name, role.Rules)
+	}
+	for _, role := range objects.ClusterRoles {
+		analyze("ClusterRole", "", role.Name, role.Rules)
+	}
+	return risks
+}
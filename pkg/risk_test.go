@@ -0,0 +1,103 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func TestAnalyzeRisks(t *testing.T) {
+	testcases := []struct {
+		name     string
+		rule     rbacv1.PolicyRule
+		expected []string
+	}{
+		{
+			name: "safe",
+			rule: rbacv1helper.NewRule("get", "list", "watch").Groups("").Resources("pods", "configmaps").RuleOrDie(),
+		},
+		{
+			name:     "wildcard verbs",
+			rule:     rbacv1helper.NewRule("*").Groups("apps").Resources("deployments").RuleOrDie(),
+			expected: []string{"wildcard-verbs"},
+		},
+		{
+			name:     "wildcard everything",
+			rule:     rbacv1helper.NewRule("*").Groups("*").Resources("*").RuleOrDie(),
+			expected: []string{"wildcard-verbs", "escalate", "bind", "impersonate", "nodes-proxy", "approve-certificates", "serviceaccount-tokens", "list-secrets", "get-secrets", "create-pods"},
+		},
+		{
+			name:     "escalate and bind",
+			rule:     rbacv1helper.NewRule("escalate", "bind").Groups("rbac.authorization.k8s.io").Resources("clusterroles").RuleOrDie(),
+			expected: []string{"escalate", "bind"},
+		},
+		{
+			name:     "impersonate",
+			rule:     rbacv1helper.NewRule("impersonate").Groups("").Resources("serviceaccounts").RuleOrDie(),
+			expected: []string{"impersonate"},
+		},
+		{
+			name:     "nodes proxy",
+			rule:     rbacv1helper.NewRule("get").Groups("").Resources("nodes/proxy").RuleOrDie(),
+			expected: []string{"nodes-proxy"},
+		},
+		{
+			name: "nodes status",
+			rule: rbacv1helper.NewRule("get").Groups("").Resources("nodes/status").RuleOrDie(),
+		},
+		{
+			name:     "approve certificates",
+			rule:     rbacv1helper.NewRule("update").Groups("certificates.k8s.io").Resources("certificatesigningrequests/approval").RuleOrDie(),
+			expected: []string{"approve-certificates"},
+		},
+		{
+			name:     "service account tokens",
+			rule:     rbacv1helper.NewRule("create").Groups("").Resources("serviceaccounts/token").RuleOrDie(),
+			expected: []string{"serviceaccount-tokens"},
+		},
+		{
+			name:     "list secrets",
+			rule:     rbacv1helper.NewRule("list").Groups("").Resources("secrets").RuleOrDie(),
+			expected: []string{"list-secrets"},
+		},
+		{
+			name:     "get secrets",
+			rule:     rbacv1helper.NewRule("get").Groups("").Resources("secrets").RuleOrDie(),
+			expected: []string{"get-secrets"},
+		},
+		{
+			name: "get named secrets",
+			rule: rbacv1helper.NewRule("get").Groups("").Resources("secrets").Names("my-secret").RuleOrDie(),
+		},
+		{
+			name:     "create pods",
+			rule:     rbacv1helper.NewRule("create").Groups("").Resources("pods").RuleOrDie(),
+			expected: []string{"create-pods"},
+		},
+		{
+			name: "non-resource wildcard",
+			rule: rbacv1helper.NewRule("get").URLs("*").RuleOrDie(),
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			objects := &RBACObjects{Roles: []*rbacv1.Role{{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "role"}, Rules: []rbacv1.PolicyRule{tc.rule}}}}
+			checks := []string{}
+			for _, risk := range AnalyzeRisks(objects) {
+				if risk.Kind != "Role" || risk.Namespace != "ns1" || risk.Name != "role" || risk.RuleIndex != 0 {
+					t.Errorf("unexpected location %s %s/%s rules[%d]", risk.Kind, risk.Namespace, risk.Name, risk.RuleIndex)
+				}
+				checks = append(checks, risk.Check)
+			}
+			if tc.expected == nil {
+				tc.expected = []string{}
+			}
+			if !reflect.DeepEqual(tc.expected, checks) {
+				t.Errorf("expected %v, got %v", tc.expected, checks)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	helpervalidation "k8s.io/component-helpers/auth/rbac/validation"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+	rbacauthorizer "k8s.io/kubernetes/plugin/pkg/auth/authorizer/rbac"
+)
+
+// Trace records how a request contributed to the generated rules
+type Trace struct {
+	// Request is the request the rules were generated for.
+	// Only the user is set for rules required by roles and bindings the user wrote.
+	Request authorizer.AttributesRecord
+	// Namespace is the namespace the rules were generated in, or empty for cluster-wide rules
+	Namespace string
+	// Rules are the rules generated for the request, before compaction.
+	// Empty if the request was allowed by rules generated for other requests.
+	Rules []rbacv1.PolicyRule
+	// Reasons describes why the rules allow more than the request
+	Reasons []string
+}
+
+func (g *Generator) trace(t Trace) {
+	if g.Options.Trace {
+		g.traces = append(g.traces, t)
+	}
+}
+
+// Traces returns how each request contributed to the generated rules, if Options.Trace is set
+func (g *Generator) Traces() []Trace {
+	return g.traces
+}
+
+// Explain returns the traces that generated rules overlapping the rule, or that were allowed by the rule.
+// If namespace is set, only traces for the namespace and cluster-wide traces are considered.
+func Explain(traces []Trace, namespace string, rule rbacv1.PolicyRule) []Trace {
+	explained := []Trace{}
+	for _, t := range traces {
+		if len(t.Rules) == 0 {
+			if (namespace == "" || t.Request.Namespace == namespace) && rbacauthorizer.RuleAllows(t.Request, &rule) {
+				explained = append(explained, t)
+			}
+			continue
+		}
+		if namespace != "" && t.Namespace != "" && t.Namespace != namespace {
+			continue
+		}
+		for _, generated := range t.Rules {
+			if rulesOverlap(generated, rule) {
+				explained = append(explained, t)
+				break
+			}
+		}
+	}
+	return explained
+}
+
+// rulesOverlap returns true if there is a request both rules allow
+func rulesOverlap(a, b rbacv1.PolicyRule) bool {
+	for _, x := range helpervalidation.BreakdownRule(a) {
+		for _, y := range helpervalidation.BreakdownRule(b) {
+			if atomsOverlap(x, y) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// atomsOverlap compares rules with a single verb, and a single group, resource, and name or a single URL
+func atomsOverlap(a, b rbacv1.PolicyRule) bool {
+	if !valuesOverlap(a.Verbs[0], b.Verbs[0]) {
+		return false
+	}
+	if len(a.NonResourceURLs) > 0 || len(b.NonResourceURLs) > 0 {
+		return len(a.NonResourceURLs) > 0 && len(b.NonResourceURLs) > 0 && urlsOverlap(a.NonResourceURLs[0], b.NonResourceURLs[0])
+	}
+	if !valuesOverlap(a.APIGroups[0], b.APIGroups[0]) || !resourcesOverlap(a.Resources[0], b.Resources[0]) {
+		return false
+	}
+	return len(a.ResourceNames) == 0 || len(b.ResourceNames) == 0 || a.ResourceNames[0] == b.ResourceNames[0]
+}
+
+func valuesOverlap(a, b string) bool {
+	return a == b || a == rbacv1.VerbAll || b == rbacv1.VerbAll
+}
+
+// resourcesOverlap returns true if there is a request both resources match, matching them the way RBAC does.
+// */subresource matches the subresource of any resource, while resource/* only matches itself.
+func resourcesOverlap(a, b string) bool {
+	matches := func(pattern, resource string) bool {
+		subresource := ""
+		if parts := strings.SplitN(resource, "/", 2); len(parts) == 2 {
+			subresource = parts[1]
+		}
+		return rbacv1helper.ResourceMatches(&rbacv1.PolicyRule{Resources: []string{pattern}}, resource, subresource)
+	}
+	return matches(a, b) || matches(b, a)
+}
+
+func urlsOverlap(a, b string) bool {
+	matches := func(pattern, url string) bool {
+		return pattern == url || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(url, strings.TrimSuffix(pattern, "*")))
+	}
+	return matches(a, b) || matches(b, a)
+}
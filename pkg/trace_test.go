@@ -0,0 +1,167 @@
+package pkg
+
+import (
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func TestTraces(t *testing.T) {
+	bob := &user.DefaultInfo{Name: "bob"}
+	getPod1 := authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", Resource: "pods", Name: "pod1"}
+	getPod2 := authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns1", Resource: "pods", Name: "pod2"}
+	listPods := authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "list", Namespace: "ns2", Resource: "pods"}
+	getSecret := authorizer.AttributesRecord{User: bob, ResourceRequest: true, Verb: "get", Namespace: "ns2", Resource: "secrets", Name: "s1"}
+	metrics := authorizer.AttributesRecord{User: bob, Verb: "get", Path: "/healthz/etcd"}
+
+	opts := DefaultGenerateOptions()
+	opts.Trace = true
+	generator := NewGenerator(RBACObjects{}, []authorizer.AttributesRecord{getPod1, getPod2, listPods, getSecret, metrics}, opts)
+	generator.Generate()
+
+	getPods := rbacv1helper.NewRule("get").Groups("").Resources("pods").RuleOrDie()
+	expected := []Trace{
+		{
+			Request: metrics,
+			Rules:   []rbacv1.PolicyRule{rbacv1helper.NewRule("get").URLs("/healthz*").RuleOrDie()},
+			Reasons: []string{"allowed /healthz* in place of the requested path"},
+		},
+		{
+			Request: listPods, Namespace: "ns2",
+			Rules:   []rbacv1.PolicyRule{rbacv1helper.NewRule("list", "get", "watch").Groups("").Resources("pods").RuleOrDie()},
+			Reasons: []string{"also allowed get, watch, which are usually requested with list"},
+		},
+		{
+			Request: getPod1, Namespace: "ns1",
+			Rules:   []rbacv1.PolicyRule{getPods},
+			Reasons: []string{"allowed on any name, since it was also requested for names pod2"},
+		},
+		{
+			Request: getPod2, Namespace: "ns1",
+			Reasons: []string{"allowed by rules generated for other requests"},
+		},
+		{
+			Request: getSecret, Namespace: "ns2",
+			Rules:   []rbacv1.PolicyRule{rbacv1helper.NewRule("get").Groups("").Resources("secrets").Names("s1").RuleOrDie()},
+			Reasons: []string{},
+		},
+	}
+	if !equality.Semantic.DeepEqual(expected, generator.Traces()) {
+		t.Fatalf("unexpected traces\n%s", diff.ObjectGoPrintSideBySide(expected, generator.Traces()))
+	}
+
+	testcases := []struct {
+		name      string
+		namespace string
+		rule      rbacv1.PolicyRule
+		expected  []Trace
+	}{
+		{
+			name:      "named rule overlaps expanded rule",
+			namespace: "ns1",
+			rule:      rbacv1helper.NewRule("get").Groups("").Resources("pods").Names("pod2").RuleOrDie(),
+			expected:  []Trace{expected[2], expected[3]},
+		},
+		{
+			name:     "any namespace",
+			rule:     rbacv1helper.NewRule("get", "list").Groups("").Resources("pods").RuleOrDie(),
+			expected: []Trace{expected[1], expected[2], expected[3]},
+		},
+		{
+			name:      "other namespace",
+			namespace: "ns3",
+			rule:      rbacv1helper.NewRule("get").Groups("").Resources("pods").RuleOrDie(),
+			expected:  []Trace{},
+		},
+		{
+			name:     "wildcard",
+			rule:     rbacv1helper.NewRule("*").Groups("*").Resources("secrets").RuleOrDie(),
+			expected: []Trace{expected[4]},
+		},
+		{
+			name:     "url",
+			rule:     rbacv1helper.NewRule("get").URLs("/healthz").RuleOrDie(),
+			expected: []Trace{expected[0]},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			explained := Explain(generator.Traces(), tc.namespace, tc.rule)
+			if !equality.Semantic.DeepEqual(tc.expected, explained) {
+				t.Errorf("unexpected traces\n%s", diff.ObjectGoPrintSideBySide(tc.expected, explained))
+			}
+		})
+	}
+}
+
+func TestRulesOverlap(t *testing.T) {
+	testcases := []struct {
+		name     string
+		a, b     rbacv1.PolicyRule
+		expected bool
+	}{
+		{
+			name:     "same resource",
+			a:        rbacv1helper.NewRule("get").Groups("").Resources("pods").RuleOrDie(),
+			b:        rbacv1helper.NewRule("get", "list").Groups("").Resources("pods").Names("pod1").RuleOrDie(),
+			expected: true,
+		},
+		{
+			name:     "any resource",
+			a:        rbacv1helper.NewRule("get").Groups("").Resources("*").RuleOrDie(),
+			b:        rbacv1helper.NewRule("get").Groups("").Resources("pods/log").RuleOrDie(),
+			expected: true,
+		},
+		{
+			name:     "subresource of any resource",
+			a:        rbacv1helper.NewRule("update").Groups("").Resources("*/status").RuleOrDie(),
+			b:        rbacv1helper.NewRule("update").Groups("").Resources("pods/status").RuleOrDie(),
+			expected: true,
+		},
+		{
+			name:     "subresource of any resource and other subresource",
+			a:        rbacv1helper.NewRule("get").Groups("").Resources("*/status").RuleOrDie(),
+			b:        rbacv1helper.NewRule("get").Groups("").Resources("pods/log").RuleOrDie(),
+			expected: false,
+		},
+		{
+			name:     "subresource of any resource and resource",
+			a:        rbacv1helper.NewRule("get").Groups("").Resources("*/status").RuleOrDie(),
+			b:        rbacv1helper.NewRule("get").Groups("").Resources("pods").RuleOrDie(),
+			expected: false,
+		},
+		{
+			name:     "resource/* is not a wildcard",
+			a:        rbacv1helper.NewRule("get").Groups("").Resources("pods/*").RuleOrDie(),
+			b:        rbacv1helper.NewRule("get").Groups("").Resources("pods/status").RuleOrDie(),
+			expected: false,
+		},
+		{
+			name:     "resource/* and subresource of any resource",
+			a:        rbacv1helper.NewRule("get").Groups("").Resources("pods/*").RuleOrDie(),
+			b:        rbacv1helper.NewRule("get").Groups("").Resources("*/*").RuleOrDie(),
+			expected: true,
+		},
+		{
+			name:     "different verbs",
+			a:        rbacv1helper.NewRule("update").Groups("").Resources("*/status").RuleOrDie(),
+			b:        rbacv1helper.NewRule("get").Groups("").Resources("pods/status").RuleOrDie(),
+			expected: false,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := rulesOverlap(tc.a, tc.b); actual != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, actual)
+			}
+			if actual := rulesOverlap(tc.b, tc.a); actual != tc.expected {
+				t.Errorf("expected %v in reverse, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
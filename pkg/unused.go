@@ -0,0 +1,112 @@
+package pkg
+
+import (
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apiserver/pkg/authentication/serviceaccount"
+	"k8s.io/apiserver/pkg/authentication/user"
+	helpervalidation "k8s.io/component-helpers/auth/rbac/validation"
+	rbacauthorizer "k8s.io/kubernetes/plugin/pkg/auth/authorizer/rbac"
+)
+
+// PermissionUsage counts the requests allowed by an individual permission granted to a user
+type PermissionUsage struct {
+	// Namespace is the namespace the permission is granted in, or empty if it is granted cluster-wide
+	Namespace string
+	// RoleRef is the role granting the permission
+	RoleRef rbacv1.RoleRef
+	// Permission is a rule with a single verb, API group, resource, and resource name, or a single verb and non-resource URL
+	Permission rbacv1.PolicyRule
+	// Requests is the number of requests the permission allowed
+	Requests int
+}
+
+// PermissionUsages breaks down the roles bound to the user in objects into individual permissions,
+// and counts the requests each permission allowed. Requests allowed by more than one permission count for each of them.
+// Permissions are returned in the order of the bindings and rules that grant them.
+func PermissionUsages(objects *RBACObjects, u user.Info, requests []*AggregatedRequest) []PermissionUsage {
+	type scopedRoleRef struct {
+		namespace string
+		roleRef   rbacv1.RoleRef
+	}
+	seen := map[scopedRoleRef]bool{}
+	usages := []PermissionUsage{}
+	add := func(namespace string, roleRef rbacv1.RoleRef, rules []rbacv1.PolicyRule) {
+		if seen[scopedRoleRef{namespace, roleRef}] {
+			return
+		}
+		seen[scopedRoleRef{namespace, roleRef}] = true
+		for _, rule := range rules {
+			// non-resource permissions can only be granted cluster-wide
+			if namespace != "" && len(rule.NonResourceURLs) > 0 {
+				continue
+			}
+			for _, permission := range helpervalidation.BreakdownRule(rule) {
+				usages = append(usages, PermissionUsage{Namespace: namespace, RoleRef: roleRef, Permission: permission})
+			}
+		}
+	}
+
+	for _, binding := range objects.ClusterRoleBindings {
+		if binding.RoleRef.Kind != "ClusterRole" || !appliesTo(u, binding.Subjects, "") {
+			continue
+		}
+		if role := findClusterRole(objects, binding.RoleRef.Name); role != nil {
+			add("", binding.RoleRef, role.Rules)
+		}
+	}
+	for _, binding := range objects.RoleBindings {
+		if !appliesTo(u, binding.Subjects, binding.Namespace) {
+			continue
+		}
+		switch binding.RoleRef.Kind {
+		case "ClusterRole":
+			if role := findClusterRole(objects, binding.RoleRef.Name); role != nil {
+				add(binding.Namespace, binding.RoleRef, role.Rules)
+			}
+		case "Role":
+			if role := findRole(objects, binding.Namespace, binding.RoleRef.Name); role != nil {
+				add(binding.Namespace, binding.RoleRef, role.Rules)
+			}
+		}
+	}
+
+	for _, request := range requests {
+		for i := range usages {
+			if usages[i].Namespace != "" && usages[i].Namespace != request.Attributes.Namespace {
+				continue
+			}
+			if rbacauthorizer.RuleAllows(request.Attributes, &usages[i].Permission) {
+				usages[i].Requests += request.Count
+			}
+		}
+	}
+	return usages
+}
+
+// appliesTo returns true if one of the subjects of a binding in the namespace is the user, or a group of the user
+func appliesTo(u user.Info, subjects []rbacv1.Subject, namespace string) bool {
+	for _, subject := range subjects {
+		switch subject.Kind {
+		case rbacv1.UserKind:
+			if subject.Name == u.GetName() {
+				return true
+			}
+		case rbacv1.GroupKind:
+			for _, group := range u.GetGroups() {
+				if subject.Name == group {
+					return true
+				}
+			}
+		case rbacv1.ServiceAccountKind:
+			// service account subjects of role bindings default to the namespace of the binding
+			saNamespace := subject.Namespace
+			if len(saNamespace) == 0 {
+				saNamespace = namespace
+			}
+			if serviceaccount.MakeUsername(saNamespace, subject.Name) == u.GetName() {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func TestPermissionUsages(t *testing.T) {
+	sa := &user.DefaultInfo{Name: "system:serviceaccount:ns1:sa1", Groups: []string{"system:serviceaccounts", "system:authenticated"}}
+	objects := &RBACObjects{
+		ClusterRoles: []*rbacv1.ClusterRole{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "reader"},
+				Rules: []rbacv1.PolicyRule{
+					rbacv1helper.NewRule("get", "list").Groups("").Resources("pods").RuleOrDie(),
+					rbacv1helper.NewRule("get").URLs("/metrics").RuleOrDie(),
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "nodes"},
+				Rules:      []rbacv1.PolicyRule{rbacv1helper.NewRule("get").Groups("").Resources("nodes").RuleOrDie()},
+			},
+		},
+		ClusterRoleBindings: []*rbacv1.ClusterRoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "nodes"},
+				Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: "system:serviceaccounts"}},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "nodes"},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "other"},
+				Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "bob"}},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "reader"},
+			},
+		},
+		Roles: []*rbacv1.Role{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "ns1"},
+				Rules:      []rbacv1.PolicyRule{rbacv1helper.NewRule("update").Groups("").Resources("configmaps").Names("a", "b").RuleOrDie()},
+			},
+		},
+		RoleBindings: []*rbacv1.RoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "reader", Namespace: "ns1"},
+				// the namespace of service accounts defaults to the namespace of the binding
+				Subjects: []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "sa1"}},
+				RoleRef:  rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "reader"},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "ns1"},
+				Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "sa1", Namespace: "ns1"}},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "app"},
+			},
+		},
+	}
+
+	requests := NewRequestAggregator()
+	now := time.Now()
+	requests.Add(authorizer.AttributesRecord{User: sa, ResourceRequest: true, Verb: "get", Namespace: "ns1", Resource: "pods", Name: "p1"}, now)
+	requests.Add(authorizer.AttributesRecord{User: sa, ResourceRequest: true, Verb: "get", Namespace: "ns1", Resource: "pods", Name: "p1"}, now)
+	requests.Add(authorizer.AttributesRecord{User: sa, ResourceRequest: true, Verb: "update", Namespace: "ns1", Resource: "configmaps", Name: "a"}, now)
+	// not allowed in other namespaces
+	requests.Add(authorizer.AttributesRecord{User: sa, ResourceRequest: true, Verb: "list", Namespace: "ns2", Resource: "pods"}, now)
+	requests.Add(authorizer.AttributesRecord{User: sa, ResourceRequest: true, Verb: "get", Resource: "nodes", Name: "n1"}, now)
+
+	reader := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "reader"}
+	app := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "app"}
+	expected := []PermissionUsage{
+		{RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "nodes"}, Permission: rbacv1helper.NewRule("get").Groups("").Resources("nodes").RuleOrDie(), Requests: 1},
+		// non-resource permissions are not granted by role bindings
+		{Namespace: "ns1", RoleRef: reader, Permission: rbacv1helper.NewRule("get").Groups("").Resources("pods").RuleOrDie(), Requests: 2},
+		{Namespace: "ns1", RoleRef: reader, Permission: rbacv1helper.NewRule("list").Groups("").Resources("pods").RuleOrDie()},
+		{Namespace: "ns1", RoleRef: app, Permission: rbacv1helper.NewRule("update").Groups("").Resources("configmaps").Names("a").RuleOrDie(), Requests: 1},
+		{Namespace: "ns1", RoleRef: app, Permission: rbacv1helper.NewRule("update").Groups("").Resources("configmaps").Names("b").RuleOrDie()},
+	}
+	usages := PermissionUsages(objects, sa, requests.Requests())
+	if !equality.Semantic.DeepEqual(expected, usages) {
+		t.Errorf("unexpected usages\n%s", diff.ObjectGoPrintSideBySide(expected, usages))
+	}
+}
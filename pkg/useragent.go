@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"sort"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+// NormalizeUserAgent returns the program name of a user agent, without its version, platform, or other details.
+// For example, "kube-controller-manager/v1.23.4 (linux/amd64) kubernetes/e6c093d/leader-election" is normalized to "kube-controller-manager".
+func NormalizeUserAgent(userAgent string) string {
+	name := strings.TrimSpace(userAgent)
+	if i := strings.IndexAny(name, "/ "); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// UserAgentMatrix lists the rules generated for a set of user agents, and which user agents need each rule
+type UserAgentMatrix struct {
+	UserAgents []string
+	Rows       []UserAgentMatrixRow
+}
+
+// UserAgentMatrixRow is a rule generated for at least one user agent
+type UserAgentMatrixRow struct {
+	// Namespace is the namespace the rule is granted in, or empty if it is granted cluster-wide
+	Namespace string
+	Rule      rbacv1.PolicyRule
+	// Needed holds, for each of the matrix's user agents, whether the objects generated for it allow the rule
+	Needed []bool
+}
+
+// NewUserAgentMatrix returns the distinct rules bound by the objects generated for each user agent, and which user agents' objects allow them.
+// User agents are sorted. Cluster-wide rules are listed first, followed by the rules in each namespace,
+// in the order of the user agents and rules they were generated in.
+func NewUserAgentMatrix(generated map[string]*RBACObjects) *UserAgentMatrix {
+	matrix := &UserAgentMatrix{UserAgents: []string{}, Rows: []UserAgentMatrixRow{}}
+	for userAgent := range generated {
+		matrix.UserAgents = append(matrix.UserAgents, userAgent)
+	}
+	sort.Strings(matrix.UserAgents)
+
+	granted := make([]*Ceiling, len(matrix.UserAgents))
+	seen := map[string]bool{}
+	for i, userAgent := range matrix.UserAgents {
+		rules := boundRules(generated[userAgent])
+		granted[i] = &Ceiling{rules: rules}
+
+		namespaces := make([]string, 0, len(rules))
+		for namespace := range rules {
+			namespaces = append(namespaces, namespace)
+		}
+		sort.Strings(namespaces)
+		for _, namespace := range namespaces {
+			for _, rule := range rules[namespace] {
+				key := namespace + "\n" + rbacv1helper.CompactString(rule)
+				if !seen[key] {
+					seen[key] = true
+					matrix.Rows = append(matrix.Rows, UserAgentMatrixRow{Namespace: namespace, Rule: rule})
+				}
+			}
+		}
+	}
+	sort.SliceStable(matrix.Rows, func(i, j int) bool {
+		return matrix.Rows[i].Namespace < matrix.Rows[j].Namespace
+	})
+
+	for i := range matrix.Rows {
+		row := &matrix.Rows[i]
+		row.Needed = make([]bool, len(matrix.UserAgents))
+		for j := range matrix.UserAgents {
+			row.Needed[j] = granted[j].Allows(row.Namespace, row.Rule)
+		}
+	}
+	return matrix
+}
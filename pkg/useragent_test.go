@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func TestNormalizeUserAgent(t *testing.T) {
+	testcases := map[string]string{
+		"kube-controller-manager/v1.23.4 (linux/amd64) kubernetes/e6c093d/leader-election": "kube-controller-manager",
+		"kubectl/v1.23.4 (darwin/arm64) kubernetes/e6c093d":                                "kubectl",
+		"my-webhook":                    "my-webhook",
+		"  Go-http-client/1.1  ":        "Go-http-client",
+		"python-requests 2.28 (custom)": "python-requests",
+		"":                              "",
+	}
+	for userAgent, expected := range testcases {
+		if normalized := NormalizeUserAgent(userAgent); normalized != expected {
+			t.Errorf("%q: expected %q, got %q", userAgent, expected, normalized)
+		}
+	}
+}
+
+func TestNewUserAgentMatrix(t *testing.T) {
+	objects := func(name string, clusterRules []rbacv1.PolicyRule, namespace string, rules []rbacv1.PolicyRule) *RBACObjects {
+		subject := rbacv1.Subject{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "bob"}
+		return &RBACObjects{
+			ClusterRoles: []*rbacv1.ClusterRole{{ObjectMeta: metav1.ObjectMeta{Name: name}, Rules: clusterRules}},
+			ClusterRoleBindings: []*rbacv1.ClusterRoleBinding{{
+				ObjectMeta: metav1.ObjectMeta{Name: name},
+				Subjects:   []rbacv1.Subject{subject},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name},
+			}},
+			Roles: []*rbacv1.Role{{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}, Rules: rules}},
+			RoleBindings: []*rbacv1.RoleBinding{{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Subjects:   []rbacv1.Subject{subject},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name},
+			}},
+		}
+	}
+
+	watchPods := rbacv1helper.NewRule("get", "list", "watch").Groups("").Resources("pods").RuleOrDie()
+	getPods := rbacv1helper.NewRule("get").Groups("").Resources("pods").RuleOrDie()
+	updateDeployments := rbacv1helper.NewRule("update").Groups("apps").Resources("deployments").RuleOrDie()
+	getConfigMaps := rbacv1helper.NewRule("get").Groups("").Resources("configmaps").RuleOrDie()
+
+	matrix := NewUserAgentMatrix(map[string]*RBACObjects{
+		"kubectl":    objects("audit2rbac:bob:kubectl", []rbacv1.PolicyRule{getPods}, "ns1", []rbacv1.PolicyRule{getConfigMaps}),
+		"controller": objects("audit2rbac:bob:controller", []rbacv1.PolicyRule{watchPods}, "ns1", []rbacv1.PolicyRule{updateDeployments, getPods}),
+	})
+
+	expected := &UserAgentMatrix{
+		UserAgents: []string{"controller", "kubectl"},
+		Rows: []UserAgentMatrixRow{
+			{Rule: watchPods, Needed: []bool{true, false}},
+			// allowed by the broader rule generated for the controller
+			{Rule: getPods, Needed: []bool{true, true}},
+			{Namespace: "ns1", Rule: updateDeployments, Needed: []bool{true, false}},
+			// the same rule is listed separately in each namespace it is granted in
+			{Namespace: "ns1", Rule: getPods, Needed: []bool{true, true}},
+			{Namespace: "ns1", Rule: getConfigMaps, Needed: []bool{false, true}},
+		},
+	}
+	if !equality.Semantic.DeepEqual(expected, matrix) {
+		t.Errorf("unexpected matrix:\n%s", diff.ObjectGoPrintSideBySide(expected, matrix))
+	}
+}
@@ -3,7 +3,6 @@ package pkg
 import (
 	"fmt"
 	"io"
-	"reflect"
 	"sort"
 	"strings"
 
@@ -19,9 +18,7 @@ import (
 	"k8s.io/apiserver/pkg/authentication/serviceaccount"
 	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/apiserver/pkg/authorization/authorizer"
-	helpervalidation "k8s.io/component-helpers/auth/rbac/validation"
 	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
-	"k8s.io/kubernetes/pkg/registry/rbac/validation"
 )
 
 func userToSubject(user user.Info) rbacv1.Subject {
@@ -43,73 +40,59 @@ func attributesToResourceRule(request authorizer.AttributesRecord, options Gener
 	return rule
 }
 
-func compactRules(rules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
-	breakdownRules := []rbacv1.PolicyRule{}
-	for _, rule := range rules {
-		breakdownRules = append(breakdownRules, helpervalidation.BreakdownRule(rule)...)
+// requestKey holds the attributes of a request that distinguish it from other requests.
+// The API version, and the path of resource requests (which contains the version), are not included,
+// since RBAC does not consider them when authorizing resource requests.
+type requestKey struct {
+	user            string
+	groups          string
+	verb            string
+	namespace       string
+	apiGroup        string
+	resource        string
+	subresource     string
+	name            string
+	resourceRequest bool
+	path            string
+}
+
+func newRequestKey(request authorizer.AttributesRecord) requestKey {
+	key := requestKey{
+		verb:            request.Verb,
+		namespace:       request.Namespace,
+		apiGroup:        request.APIGroup,
+		resource:        request.Resource,
+		subresource:     request.Subresource,
+		name:            request.Name,
+		resourceRequest: request.ResourceRequest,
 	}
-	compactRules, err := validation.CompactRules(breakdownRules)
-	if err != nil {
-		return rules
+	if !request.ResourceRequest {
+		key.path = request.Path
 	}
-	// TODO: fix CompactRules to dedupe verbs
-	for i := range compactRules {
-		compactRules[i].Verbs = sets.NewString(compactRules[i].Verbs...).List()
+	if request.User != nil {
+		key.user = request.User.GetName()
+		key.groups = strings.Join(request.User.GetGroups(), "\n")
 	}
+	return key
+}
 
-	accumulatingRules := []rbacv1.PolicyRule{}
-	for _, rule := range compactRules {
-		// Non-resource rules just accumulate
-		if len(rule.Resources) == 0 {
-			accumulatingRules = append(accumulatingRules, rule)
-			continue
-		}
+// hasOther returns true if values contains a value other than the specified value
+func hasOther(values sets.String, value string) bool {
+	return values.Len() > 1 || (values.Len() == 1 && !values.Has(value))
+}
 
-		accumulated := false
-		// strip resource
-		resourcelessRule := rule
-		resourcelessRule.Resources = nil
-		// strip name
-		namelessRule := rule
-		namelessRule.ResourceNames = nil
-		for j, accumulatingRule := range accumulatingRules {
-			// strip name
-			namelessAccumulatingRule := accumulatingRule
-			namelessAccumulatingRule.ResourceNames = nil
-			if reflect.DeepEqual(namelessRule, namelessAccumulatingRule) {
-				combinedNames := sets.NewString(accumulatingRule.ResourceNames...)
-				combinedNames.Insert(rule.ResourceNames...)
-				accumulatingRule.ResourceNames = combinedNames.List()
-				accumulatingRules[j] = accumulatingRule
-				accumulated = true
-				break
-			}
+// objectKey identifies an object by kind, namespace, and name
+func objectKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
 
-			// strip resource
-			resourcelessAccumulatingRule := accumulatingRule
-			resourcelessAccumulatingRule.Resources = nil
-			if reflect.DeepEqual(resourcelessRule, resourcelessAccumulatingRule) {
-				combinedResources := sets.NewString(accumulatingRule.Resources...)
-				combinedResources.Insert(rule.Resources...)
-				accumulatingRule.Resources = combinedResources.List()
-				accumulatingRules[j] = accumulatingRule
-				accumulated = true
-				break
-			}
-		}
-		if !accumulated {
-			accumulatingRules = append(accumulatingRules, rule)
-		}
+// rulesKey returns a string that is identical for identical lists of rules
+func rulesKey(rules []rbacv1.PolicyRule) string {
+	keys := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		keys = append(keys, rbacv1helper.CompactString(rule))
 	}
-
-	sort.SliceStable(accumulatingRules, func(i, j int) bool {
-		// TODO: fix upstream sorting to prioritize API group
-		if c := strings.Compare(strings.Join(accumulatingRules[i].APIGroups, ","), strings.Join(accumulatingRules[j].APIGroups, ",")); c != 0 {
-			return c < 0
-		}
-		return strings.Compare(rbacv1helper.CompactString(accumulatingRules[i]), rbacv1helper.CompactString(accumulatingRules[j])) < 0
-	})
-	return accumulatingRules
+	return strings.Join(keys, "\n")
 }
 
 func sortRequests(requests []authorizer.AttributesRecord) {
@@ -0,0 +1,23 @@
+package pkg
+
+import (
+	"context"
+
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/kubernetes/pkg/registry/rbac/validation"
+	rbacauthorizer "k8s.io/kubernetes/plugin/pkg/auth/authorizer/rbac"
+)
+
+// DeniedRequests returns the requests the roles and bindings in objects do not allow, in the order they were observed
+func DeniedRequests(objects *RBACObjects, requests []*AggregatedRequest) []*AggregatedRequest {
+	_, getter := validation.NewTestRuleResolver(objects.Roles, objects.RoleBindings, objects.ClusterRoles, objects.ClusterRoleBindings)
+	rbacAuthorizer := rbacauthorizer.New(getter, getter, getter, getter)
+
+	denied := []*AggregatedRequest{}
+	for _, request := range requests {
+		if decision, _, _ := rbacAuthorizer.Authorize(context.Background(), request.Attributes); decision != authorizer.DecisionAllow {
+			denied = append(denied, request)
+		}
+	}
+	return denied
+}
@@ -0,0 +1,54 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	rbacv1helper "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func TestDeniedRequests(t *testing.T) {
+	sa := &user.DefaultInfo{Name: "system:serviceaccount:ns1:sa1", Groups: []string{"system:serviceaccounts", "system:authenticated"}}
+	objects := &RBACObjects{
+		ClusterRoles: []*rbacv1.ClusterRole{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "reader"},
+				Rules:      []rbacv1.PolicyRule{rbacv1helper.NewRule("get", "list").Groups("").Resources("pods").RuleOrDie()},
+			},
+		},
+		RoleBindings: []*rbacv1.RoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "reader", Namespace: "ns1"},
+				Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "sa1", Namespace: "ns1"}},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "reader"},
+			},
+		},
+	}
+
+	requests := NewRequestAggregator()
+	now := time.Now()
+	requests.Add(authorizer.AttributesRecord{User: sa, ResourceRequest: true, Verb: "get", Namespace: "ns1", Resource: "pods", Name: "p1"}, now)
+	requests.Add(authorizer.AttributesRecord{User: sa, ResourceRequest: true, Verb: "list", Namespace: "ns2", Resource: "pods"}, now)
+	requests.Add(authorizer.AttributesRecord{User: sa, ResourceRequest: true, Verb: "list", Namespace: "ns2", Resource: "pods"}, now)
+	requests.Add(authorizer.AttributesRecord{User: sa, ResourceRequest: true, Verb: "delete", Namespace: "ns1", Resource: "pods", Name: "p1"}, now)
+	requests.Add(authorizer.AttributesRecord{User: sa, Verb: "get", Path: "/healthz"}, now)
+
+	denied := DeniedRequests(objects, requests.Requests())
+	expected := []string{"list ns2 pods", "delete ns1 pods", "get  /healthz"}
+	if len(denied) != len(expected) {
+		t.Fatalf("expected %d denied requests, got %d", len(expected), len(denied))
+	}
+	for i, request := range denied {
+		a := request.Attributes
+		if s := a.Verb + " " + a.Namespace + " " + a.Resource + a.Path; s != expected[i] {
+			t.Errorf("%d: expected %q, got %q", i, expected[i], s)
+		}
+	}
+	if denied[0].Count != 2 {
+		t.Errorf("expected identical denied requests to be counted together, got %d", denied[0].Count)
+	}
+}